@@ -5,7 +5,7 @@ import (
 	"strings"
 )
 
-func parseOutput(resp []map[string]string, msg string, tableFields []string) string {
+func parseOutput(resp []map[string]*string, msg string, tableFields []string) string {
 	var str string
 	if len(resp) > 0 {
 		fields := make([]string, len(resp[0]))
@@ -24,7 +24,11 @@ func parseOutput(resp []map[string]string, msg string, tableFields []string) str
 		for i := 0; i < len(resp); i++ {
 			str += "|"
 			for j := 0; j < len(fields); j++ {
-				str += fmt.Sprintf(" %10s |", resp[i][fields[j]])
+				value := "NULL"
+				if resp[i][fields[j]] != nil {
+					value = *resp[i][fields[j]]
+				}
+				str += fmt.Sprintf(" %10s |", value)
 			}
 			str += "\n"
 		}
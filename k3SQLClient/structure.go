@@ -33,10 +33,10 @@ type k3Request struct {
 }
 
 type k3Response struct {
-	RespType    string              `json:"resp_type"`
-	Status      bool                `json:"status"`
-	Message     string              `json:"message"`
-	TableFields []string            `json:"table_fields"`
-	Fields      []map[string]string `json:"fields"`
-	Error       string              `json:"error"`
+	RespType    string               `json:"resp_type"`
+	Status      bool                 `json:"status"`
+	Message     string               `json:"message"`
+	TableFields []string             `json:"table_fields"`
+	Fields      []map[string]*string `json:"fields"`
+	Error       string               `json:"error"`
 }
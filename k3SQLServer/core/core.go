@@ -26,6 +26,9 @@ func init() {
 			err2 := os.MkdirAll(shared.K3ConfigurationPath, os.ModePerm)
 			if err1 == nil && err2 == nil {
 				err = CreateDatabase("k3db")
+				if err == nil {
+					err = storage.WriteFormatVersion()
+				}
 			}
 		}
 	}
@@ -163,6 +166,9 @@ func uploadTables() {
 }
 
 func StartService() error {
+	if err := storage.MigrateDataFiles(); err != nil {
+		return err
+	}
 	err := readAllFiles(shared.K3FilesPath, func(path string, isDir bool) error {
 		if !isDir {
 			if strings.HasPrefix(path, shared.K3DataPath) && strings.HasSuffix(path, shared.Extension) {
@@ -0,0 +1,267 @@
+package core
+
+import (
+	"fmt"
+	"k3SQLServer/shared"
+	"k3SQLServer/storage"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+func foreignKeys(table *shared.K3Table, parent string) []*shared.K3Constraint {
+	var constraints []*shared.K3Constraint
+	for _, constraint := range table.Constraints {
+		if constraint.Kind == shared.K3ForeignKey && (parent == "" || constraint.RefTable == parent) {
+			constraints = append(constraints, constraint)
+		}
+	}
+	return constraints
+}
+
+func anyCondition(conditions []*shared.K3Expr) *shared.K3Expr {
+	if len(conditions) == 1 {
+		return conditions[0]
+	}
+	half := len(conditions) / 2
+	return &shared.K3Expr{Kind: shared.K3ExprOr, Args: []*shared.K3Expr{anyCondition(conditions[:half]), anyCondition(conditions[half:])}}
+}
+
+// referencingWhere matches the rows of child whose foreign key fk points at
+// one of the parent rows, or is nil when none of them has a complete key.
+func referencingWhere(child *shared.K3Table, fk *shared.K3Constraint, rows []map[string]string) *shared.K3Expr {
+	var conditions []*shared.K3Expr
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		if slices.ContainsFunc(fk.RefColumns, func(column string) bool { return storage.IsNull(row, column) }) {
+			continue
+		}
+		key := rowKey(fk.RefColumns, row)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		equals := make([]*shared.K3Expr, len(fk.Columns))
+		for i, column := range fk.Columns {
+			equals[i] = columnEquals(column, row[fk.RefColumns[i]])
+			equals[i].Args[0].Type = child.Types[column]
+			equals[i].Args[1].Type = child.Types[column]
+		}
+		conditions = append(conditions, allConditions(equals...))
+	}
+	if len(conditions) == 0 {
+		return nil
+	}
+	return anyCondition(conditions)
+}
+
+func rowKey(columns []string, row map[string]string) string {
+	var key strings.Builder
+	for _, column := range columns {
+		if value, ok := row[column]; ok {
+			key.WriteString(strconv.Itoa(len(value)) + ":" + value)
+		} else {
+			key.WriteString("N;")
+		}
+	}
+	return key.String()
+}
+
+// checkInsertReferences checks the foreign keys of the rows inserted by
+// query. The tables they reference stay locked until release is called.
+// ON CONFLICT DO UPDATE may not change a column that takes part in a foreign
+// key, as the rows it updates are only known once the table is locked.
+func checkInsertReferences(query *shared.K3InsertQuery) (release func(), err error) {
+	if query.Conflict != nil && len(query.Conflict.Update) > 0 {
+		children, err := storage.ReferencingTables(query.Table)
+		if err != nil {
+			return nil, err
+		}
+		for column := range query.Conflict.Update {
+			if keyColumn(query.Table, children, column) {
+				return nil, fmt.Errorf("%s: ON CONFLICT DO UPDATE of foreign key column %s", shared.NotSupported, column)
+			}
+		}
+	}
+	if len(foreignKeys(query.Table, "")) == 0 {
+		return func() {}, nil
+	}
+	if err := storage.PrepareInsert(query); err != nil {
+		return nil, err
+	}
+	return storage.CheckReferences(query.Table, query.Values)
+}
+
+// keyColumn reports whether column of table takes part in a foreign key,
+// either of table or of one of its children.
+func keyColumn(table *shared.K3Table, children []*shared.K3Table, column string) bool {
+	for _, fk := range foreignKeys(table, "") {
+		if slices.Contains(fk.Columns, column) {
+			return true
+		}
+	}
+	for _, child := range children {
+		for _, fk := range foreignKeys(child, table.Name) {
+			if slices.Contains(fk.RefColumns, column) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setsColumns reports whether query assigns one of columns.
+func setsColumns(query *shared.K3UpdateQuery, columns []string) bool {
+	return slices.ContainsFunc(columns, func(column string) bool { _, ok := query.SetValues[column]; return ok })
+}
+
+// updatesReferences reports whether query changes a foreign key of its
+// table, or a key that one of children references.
+func updatesReferences(query *shared.K3UpdateQuery, children []*shared.K3Table) bool {
+	if slices.ContainsFunc(foreignKeys(query.Table, ""), func(fk *shared.K3Constraint) bool { return setsColumns(query, fk.Columns) }) {
+		return true
+	}
+	return slices.ContainsFunc(children, func(child *shared.K3Table) bool {
+		return slices.ContainsFunc(foreignKeys(child, query.Table.Name), func(fk *shared.K3Constraint) bool {
+			return setsColumns(query, fk.RefColumns)
+		})
+	})
+}
+
+// updateReferenced runs an update that changes foreign keys, checking them
+// from both ends. The table stays locked from the moment its rows are read,
+// and its children and parents from before they are checked, until the
+// update is written.
+func updateReferenced(query *shared.K3UpdateQuery, children []*shared.K3Table) (int, error) {
+	if err := storage.PrepareUpdate(query); err != nil {
+		return 0, err
+	}
+	batch, err := storage.LockTables(query.Table)
+	if err != nil {
+		return 0, err
+	}
+	defer batch.Release()
+	rows, updated, err := batch.Update(query)
+	if err != nil {
+		return 0, err
+	}
+	if err := batch.Lock(children...); err != nil {
+		return 0, err
+	}
+	for _, child := range children {
+		for _, fk := range foreignKeys(child, query.Table.Name) {
+			if !setsColumns(query, fk.RefColumns) {
+				continue
+			}
+			var changed []map[string]string
+			for i, row := range rows {
+				if rowKey(fk.RefColumns, row) != rowKey(fk.RefColumns, updated[i]) {
+					changed = append(changed, row)
+				}
+			}
+			where := referencingWhere(child, fk, changed)
+			if where == nil {
+				continue
+			}
+			referencing, err := batch.Rows(child, where)
+			if err != nil {
+				return 0, err
+			}
+			if len(referencing) > 0 {
+				return 0, fmt.Errorf("%s: update on table %s violates constraint %s on table %s", shared.ForeignKeyViolation,
+					query.Table.Name, fk.Name, child.Name)
+			}
+		}
+	}
+	if err := batch.CheckReferences(query.Table, updated); err != nil {
+		return 0, err
+	}
+	return len(rows), batch.Commit()
+}
+
+// deleteReferenced runs a delete from a table that others reference,
+// together with the deletes and updates to NULL that their foreign keys
+// require, failing when a restricting key still references a deleted row.
+// Every table stays locked from the moment its rows are read until all the
+// changes are written.
+func deleteReferenced(query *shared.K3DeleteQuery) (int, error) {
+	if err := storage.PrepareDelete(query); err != nil {
+		return 0, err
+	}
+	batch, err := storage.LockTables(query.Table)
+	if err != nil {
+		return 0, err
+	}
+	defer batch.Release()
+	rows, err := batch.Delete(query)
+	if err != nil {
+		return 0, err
+	}
+	if err := cascadeDelete(batch, query.Table, rows); err != nil {
+		return 0, err
+	}
+	return len(rows), batch.Commit()
+}
+
+func cascadeDelete(batch *storage.Batch, table *shared.K3Table, rows []map[string]string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	children, err := storage.ReferencingTables(table)
+	if err != nil {
+		return err
+	}
+	if err := batch.Lock(children...); err != nil {
+		return err
+	}
+	for _, child := range children {
+		for _, fk := range foreignKeys(child, table.Name) {
+			where := referencingWhere(child, fk, rows)
+			if where == nil {
+				continue
+			}
+			switch fk.OnDelete {
+			case shared.K3Cascade:
+				deleted, err := batch.Delete(&shared.K3DeleteQuery{Table: child, Where: where})
+				if err != nil {
+					return err
+				}
+				if err := cascadeDelete(batch, child, deleted); err != nil {
+					return err
+				}
+			case shared.K3SetNull:
+				values := make(map[string]*shared.K3Expr, len(fk.Columns))
+				for _, column := range fk.Columns {
+					values[column] = &shared.K3Expr{Kind: shared.K3ExprNull}
+				}
+				if _, _, err := batch.Update(&shared.K3UpdateQuery{Table: child, SetValues: values, Where: where}); err != nil {
+					return err
+				}
+			default:
+				referencing, err := batch.Rows(child, where)
+				if err != nil {
+					return err
+				}
+				if len(referencing) > 0 {
+					return fmt.Errorf("%s: delete on table %s violates constraint %s on table %s", shared.ForeignKeyViolation,
+						table.Name, fk.Name, child.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func checkDropReferences(table *shared.K3Table) error {
+	children, err := storage.ReferencingTables(table)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if child != table {
+			return fmt.Errorf("%s: cannot drop table %s because constraint %s on table %s depends on it", shared.ForeignKeyViolation,
+				table.Name, foreignKeys(child, table.Name)[0].Name, child.Name)
+		}
+	}
+	return nil
+}
@@ -2,31 +2,122 @@ package core
 
 import (
 	"errors"
+	"fmt"
 	"k3SQLServer/shared"
 	"k3SQLServer/storage"
+	"slices"
 	"strconv"
+	"strings"
 )
 
+func columnEquals(column, value string) *shared.K3Expr {
+	return &shared.K3Expr{
+		Kind:     shared.K3ExprCompare,
+		Operator: "=",
+		Args: []*shared.K3Expr{
+			{Kind: shared.K3ExprColumn, Column: column},
+			{Kind: shared.K3ExprLiteral, Value: value},
+		},
+	}
+}
+
+func allConditions(conditions ...*shared.K3Expr) *shared.K3Expr {
+	where := conditions[0]
+	for _, condition := range conditions[1:] {
+		where = &shared.K3Expr{Kind: shared.K3ExprAnd, Args: []*shared.K3Expr{where, condition}}
+	}
+	return where
+}
+
+func subqueries(exprs ...*shared.K3Expr) []*shared.K3SelectQuery {
+	var queries []*shared.K3SelectQuery
+	for _, expr := range exprs {
+		if expr == nil {
+			continue
+		}
+		if expr.Subquery != nil {
+			queries = append(queries, nestedQueries(expr.Subquery)...)
+		}
+		queries = append(queries, subqueries(expr.Args...)...)
+	}
+	return queries
+}
+
+func nestedQueries(query *shared.K3SelectQuery) []*shared.K3SelectQuery {
+	queries := []*shared.K3SelectQuery{query}
+	if query.SetOp != nil {
+		queries = append(queries, nestedQueries(query.SetOp.Left)...)
+		queries = append(queries, nestedQueries(query.SetOp.Right)...)
+	}
+	for _, cte := range query.With {
+		queries = append(queries, nestedQueries(cte.Query)...)
+		if cte.Recursive != nil {
+			queries = append(queries, nestedQueries(cte.Recursive)...)
+		}
+	}
+	return append(queries, subqueries(storage.QueryExprs(query)...)...)
+}
+
+func checkReadable(queries []*shared.K3SelectQuery, user string) error {
+	for _, query := range queries {
+		if query.Table == nil {
+			continue
+		}
+		tables := []*shared.K3Table{query.Table}
+		for _, join := range query.Joins {
+			tables = append(tables, join.Table)
+		}
+		for _, table := range tables {
+			if table.CTE != nil {
+				continue
+			}
+			if !storage.ExistsTable(table) {
+				return errors.New(shared.TableNotExists)
+			}
+			if !checkPermission(table, user, shared.K3Read) {
+				return errors.New(shared.AccessDenied)
+			}
+		}
+	}
+	return nil
+}
+
+func returningExprs(returning *shared.K3SelectQuery) []*shared.K3Expr {
+	if returning == nil {
+		return nil
+	}
+	return storage.QueryExprs(returning)
+}
+
+func checkSubqueries(target *shared.K3Table, user string, exprs ...*shared.K3Expr) error {
+	queries := subqueries(exprs...)
+	if err := checkReadable(queries, user); err != nil {
+		return err
+	}
+	correlated, readsTarget := false, false
+	for _, query := range queries {
+		correlated = correlated || query.Correlated
+		readsTarget = readsTarget || query.Table == target
+		for _, join := range query.Joins {
+			readsTarget = readsTarget || join.Table == target
+		}
+	}
+	if correlated && readsTarget {
+		return fmt.Errorf("%s: correlated subqueries reading the table being modified", shared.NotSupported)
+	}
+	return nil
+}
+
 func checkPermission(table *shared.K3Table, user string, permission int) bool {
 	if user == shared.CoreUser {
 		return true
 	}
 	approve := false
-	conditions := make([]shared.K3Condition, 2)
-	conditions[0] = shared.K3Condition{
-		Column:   "user",
-		Operator: "=",
-		Value:    user,
-	}
-	conditions[1] = shared.K3Condition{
-		Column:   "table",
-		Operator: "=",
-		Value:    table.Name,
-	}
 	selectPermissions := shared.K3SelectQuery{
-		Table:      shared.K3Tables[table.Database+"."+shared.K3PermissionsTable],
-		Values:     []string{"permission"},
-		Conditions: conditions,
+		Table:   shared.K3Tables[table.Database+"."+shared.K3PermissionsTable],
+		Values:  []*shared.K3Expr{{Kind: shared.K3ExprColumn, Column: "permission"}},
+		Columns: []string{"permission"},
+		Where:   allConditions(columnEquals("user", user), columnEquals("table", table.Name)),
 	}
 	values, _, err := storage.SelectTableFile(&selectPermissions)
 	if err == nil {
@@ -48,8 +139,29 @@ func checkPermission(table *shared.K3Table, user string, permission int) bool {
 func CreateTable(query *shared.K3CreateQuery) error {
 	if storage.DatabaseExists(query.Table.Database) {
 		if !storage.ExistsTable(query.Table) {
+			for _, fk := range foreignKeys(&shared.K3Table{Constraints: query.Constraints}, "") {
+				if fk.RefTable == query.Table.Name {
+					continue
+				}
+				parent, err := storage.LoadTable(query.Table.Database, fk.RefTable)
+				if err != nil {
+					return err
+				}
+				if !checkPermission(parent, query.User, shared.K3Read) {
+					return errors.New(shared.AccessDenied)
+				}
+			}
+			var rows []map[string]string
+			if query.Select != nil {
+				var err error
+				if rows, _, err = SelectTable(query.Select, query.User); err != nil {
+					return err
+				}
+			}
 			err := storage.CreateTableFile(query)
 			if err == nil {
+				query.Table.Types = query.Fields
+				query.Table.Constraints = query.Constraints
 				shared.K3Tables[query.Table.Database+"."+query.Table.Name] = query.Table
 				insertValues := make([]map[string]string, 1)
 				insertValues[0] = make(map[string]string, 1)
@@ -62,6 +174,12 @@ func CreateTable(query *shared.K3CreateQuery) error {
 				if err == nil {
 					err = InsertTable(&insertQuery, shared.CoreUser)
 				}
+				if err == nil && query.Select != nil {
+					err = InsertTable(&shared.K3InsertQuery{Table: query.Table, Values: rows}, query.User)
+					if err != nil {
+						DropTable(query.Table, shared.CoreUser)
+					}
+				}
 			}
 			return err
 		}
@@ -74,6 +192,36 @@ func InsertTable(query *shared.K3InsertQuery, user string) error {
 	if storage.DatabaseExists(query.Table.Database) {
 		if storage.ExistsTable(query.Table) {
 			if checkPermission(query.Table, user, shared.K3Write) {
+				if query.Select != nil {
+					rows, _, err := SelectTable(query.Select, user)
+					if err != nil {
+						return err
+					}
+					query.Values = make([]map[string]string, len(rows))
+					for i, row := range rows {
+						query.Values[i] = make(map[string]string, len(query.Columns))
+						for j, column := range query.Columns {
+							if value, ok := row[query.Select.Columns[j]]; ok {
+								query.Values[i][column] = value
+							}
+						}
+					}
+				}
+				exprs := returningExprs(query.Returning)
+				if query.Conflict != nil {
+					exprs = append(exprs, query.Conflict.Where)
+					for _, expr := range query.Conflict.Update {
+						exprs = append(exprs, expr)
+					}
+				}
+				if err := checkSubqueries(query.Table, user, exprs...); err != nil {
+					return err
+				}
+				release, err := checkInsertReferences(query)
+				if err != nil {
+					return err
+				}
+				defer release()
 				return storage.InsertTableFile(query)
 			}
 			return errors.New(shared.AccessDenied)
@@ -84,15 +232,16 @@ func InsertTable(query *shared.K3InsertQuery, user string) error {
 }
 
 func SelectTable(query *shared.K3SelectQuery, user string) ([]map[string]string, int, error) {
-	if storage.DatabaseExists(query.Table.Database) {
-		if storage.ExistsTable(query.Table) {
-			if checkPermission(query.Table, user, shared.K3Read) {
-				resp, rows, err := storage.SelectTableFile(query)
-				return resp, rows, err
-			}
-			return nil, 0, errors.New(shared.AccessDenied)
+	database := query
+	for database.SetOp != nil {
+		database = database.SetOp.Left
+	}
+	if storage.DatabaseExists(database.Table.Database) {
+		if err := checkReadable(nestedQueries(query), user); err != nil {
+			return nil, 0, err
 		}
-		return nil, 0, errors.New(shared.TableNotExists)
+		resp, rows, err := storage.SelectTableFile(query)
+		return resp, rows, err
 	}
 	return nil, 0, errors.New(shared.DatabaseNotExists)
 }
@@ -104,6 +253,20 @@ func UpdateTable(query *shared.K3UpdateQuery, user string) (int, error) {
 				return 0, errors.New(shared.AccessDenied)
 			}
 			if checkPermission(query.Table, user, shared.K3Write) {
+				exprs := append(returningExprs(query.Returning), query.Where)
+				for _, expr := range query.SetValues {
+					exprs = append(exprs, expr)
+				}
+				if err := checkSubqueries(query.Table, user, exprs...); err != nil {
+					return 0, err
+				}
+				children, err := storage.ReferencingTables(query.Table)
+				if err != nil {
+					return 0, err
+				}
+				if updatesReferences(query, children) {
+					return updateReferenced(query, children)
+				}
 				return storage.UpdateTableFile(query)
 			}
 			return 0, errors.New(shared.AccessDenied)
@@ -120,6 +283,16 @@ func DeleteTable(query *shared.K3DeleteQuery, user string) (int, error) {
 				return 0, errors.New(shared.AccessDenied)
 			}
 			if checkPermission(query.Table, user, shared.K3Write) {
+				if err := checkSubqueries(query.Table, user, append(returningExprs(query.Returning), query.Where)...); err != nil {
+					return 0, err
+				}
+				children, err := storage.ReferencingTables(query.Table)
+				if err != nil {
+					return 0, err
+				}
+				if len(children) > 0 {
+					return deleteReferenced(query)
+				}
 				return storage.DeleteTableFile(query)
 			}
 			return 0, errors.New(shared.AccessDenied)
@@ -129,6 +302,58 @@ func DeleteTable(query *shared.K3DeleteQuery, user string) (int, error) {
 	return 0, errors.New(shared.DatabaseNotExists)
 }
 
+func AlterTable(query *shared.K3AlterQuery, user string) error {
+	if storage.DatabaseExists(query.Table.Database) {
+		if storage.ExistsTable(query.Table) {
+			if strings.HasPrefix(query.Table.Name, shared.K3ServiceTablesPrefix) {
+				return errors.New(shared.AccessDenied)
+			}
+			if checkPermission(query.Table, user, shared.K3All) {
+				oldName := query.Table.Name
+				children, err := storage.ReferencingTables(query.Table)
+				if err != nil {
+					return err
+				}
+				if query.Action == shared.K3AlterColumnType {
+					for _, child := range children {
+						for _, fk := range foreignKeys(child, oldName) {
+							if child != query.Table && slices.Contains(fk.RefColumns, query.Column) {
+								return fmt.Errorf("%s: column %s is used by constraint %s on table %s", shared.InvalidSQLLogic, query.Column, fk.Name, child.Name)
+							}
+						}
+					}
+				}
+				err = storage.AlterTableFile(query)
+				if err == nil && query.Action == shared.K3AlterRenameColumn {
+					err = storage.RenameReferences(children, oldName, oldName, query.Column, query.NewName)
+				}
+				if err == nil && query.Action == shared.K3AlterRenameTable {
+					if err = storage.RenameReferences(children, oldName, query.Table.Name, "", ""); err != nil {
+						return err
+					}
+					delete(shared.K3Tables, query.Table.Database+"."+oldName)
+					shared.K3Tables[query.Table.Database+"."+query.Table.Name] = query.Table
+					newName := &shared.K3Expr{Kind: shared.K3ExprLiteral, Type: shared.K3TEXT, Value: query.Table.Name}
+					for _, serviceTable := range []string{shared.K3TablesTable, shared.K3PermissionsTable} {
+						renameQuery := shared.K3UpdateQuery{
+							Table:     shared.K3Tables[query.Table.Database+"."+serviceTable],
+							SetValues: map[string]*shared.K3Expr{"table": newName},
+							Where:     columnEquals("table", oldName),
+						}
+						if _, err = storage.UpdateTableFile(&renameQuery); err != nil {
+							break
+						}
+					}
+				}
+				return err
+			}
+			return errors.New(shared.AccessDenied)
+		}
+		return errors.New(shared.TableNotExists)
+	}
+	return errors.New(shared.DatabaseNotExists)
+}
+
 func DropTable(table *shared.K3Table, user string) error {
 	if storage.DatabaseExists(table.Database) {
 		if storage.ExistsTable(table) {
@@ -136,27 +361,16 @@ func DropTable(table *shared.K3Table, user string) error {
 				return errors.New(shared.AccessDenied)
 			}
 			if checkPermission(table, user, shared.K3Write) {
-				conditionsTables := make([]shared.K3Condition, 1)
-				conditionsPermissions := make([]shared.K3Condition, 1)
-				conditionTables := shared.K3Condition{
-					Column:   "table",
-					Value:    table.Name,
-					Operator: "=",
-				}
-				conditionPermissions := shared.K3Condition{
-					Column:   "table",
-					Value:    table.Name,
-					Operator: "=",
-				}
-				conditionsTables[0] = conditionTables
-				conditionsPermissions[0] = conditionPermissions
+				if err := checkDropReferences(table); err != nil {
+					return err
+				}
 				queryTables := shared.K3DeleteQuery{
-					Table:      shared.K3Tables[table.Database+"."+shared.K3TablesTable],
-					Conditions: conditionsTables,
+					Table: shared.K3Tables[table.Database+"."+shared.K3TablesTable],
+					Where: columnEquals("table", table.Name),
 				}
 				queryPermissions := shared.K3DeleteQuery{
-					Table:      shared.K3Tables[table.Database+"."+shared.K3PermissionsTable],
-					Conditions: conditionsPermissions,
+					Table: shared.K3Tables[table.Database+"."+shared.K3PermissionsTable],
+					Where: columnEquals("table", table.Name),
 				}
 				_, err := storage.DeleteTableFile(&queryTables)
 				if err == nil {
@@ -194,15 +408,9 @@ func ProcessUser(userQuery *shared.K3UserQuery) error {
 			}
 			return storage.InsertTableFile(insertQuery)
 		} else if userQuery.Action == shared.K3DELETE {
-			cond := make([]shared.K3Condition, 1)
-			cond[0] = shared.K3Condition{
-				Column:   "name",
-				Operator: "=",
-				Value:    userQuery.Username,
-			}
 			deleteQuery := &shared.K3DeleteQuery{
-				Table:      shared.K3Tables[userQuery.Database+"."+shared.K3UsersTable],
-				Conditions: cond,
+				Table: shared.K3Tables[userQuery.Database+"."+shared.K3UsersTable],
+				Where: columnEquals("name", userQuery.Username),
 			}
 			n, err := storage.DeleteTableFile(deleteQuery)
 			if n == 0 {
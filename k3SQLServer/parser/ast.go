@@ -0,0 +1,330 @@
+package parser
+
+type Pos struct {
+	Line int
+	Col  int
+}
+
+type Statement interface {
+	statementNode()
+}
+
+type Expr interface {
+	Position() Pos
+}
+
+type SelectStmt struct {
+	Distinct bool
+	Columns  []*SelectItem
+	From     *TableRef
+	Joins    []*JoinClause
+	Where    Expr
+	GroupBy  []Expr
+	Having   Expr
+	OrderBy  []*OrderItem
+	Limit    Expr
+	Offset   Expr
+}
+
+type SetOpStmt struct {
+	Pos
+	Op      string
+	All     bool
+	Left    Statement
+	Right   Statement
+	OrderBy []*OrderItem
+	Limit   Expr
+	Offset  Expr
+}
+
+type WithStmt struct {
+	Recursive bool
+	CTEs      []*CommonTableExpr
+	Body      Statement
+}
+
+type CommonTableExpr struct {
+	Pos
+	Name    string
+	Columns []string
+	Query   Statement
+}
+
+type SelectItem struct {
+	Pos
+	Star      bool
+	StarTable string
+	Expr      Expr
+	Alias     string
+}
+
+type TableRef struct {
+	Pos
+	Name  string
+	Alias string
+}
+
+type JoinClause struct {
+	Pos
+	Type  string
+	Table *TableRef
+	On    Expr
+}
+
+type OrderItem struct {
+	Expr  Expr
+	Desc  bool
+	Nulls string
+}
+
+type InsertStmt struct {
+	Ignore    bool
+	Table     *TableRef
+	Columns   []string
+	Rows      [][]Expr
+	Select    Statement
+	Conflict  *ConflictClause
+	Returning []*SelectItem
+}
+
+type ConflictClause struct {
+	Pos
+	Columns []string
+	Set     []*Assignment
+	Where   Expr
+}
+
+type UpdateStmt struct {
+	Table     *TableRef
+	Set       []*Assignment
+	Where     Expr
+	Returning []*SelectItem
+}
+
+type Assignment struct {
+	Pos
+	Column string
+	Value  Expr
+}
+
+type DeleteStmt struct {
+	Table     *TableRef
+	Where     Expr
+	Returning []*SelectItem
+}
+
+type CreateTableStmt struct {
+	Temporary   bool
+	IfNotExists bool
+	Table       *TableRef
+	Columns     []*ColumnDef
+	Constraints []*ConstraintDef
+	AsSelect    Statement
+}
+
+type ColumnDef struct {
+	Pos
+	Name string
+	Type string
+}
+
+type ConstraintDef struct {
+	Pos
+	Name       string
+	Kind       string
+	Columns    []string
+	Expr       Expr
+	RefTable   string
+	RefColumns []string
+	OnDelete   string
+}
+
+type CreateDatabaseStmt struct {
+	IfNotExists bool
+	Name        string
+}
+
+type AlterTableStmt struct {
+	Pos
+	Table       *TableRef
+	Action      string
+	Column      *ColumnDef
+	Constraints []*ConstraintDef
+	NewName     string
+}
+
+type DropStmt struct {
+	Pos
+	Object   string
+	IfExists bool
+	Name     string
+}
+
+type UserStmt struct {
+	Action   int
+	Username string
+	Password string
+}
+
+func (*SelectStmt) statementNode()         {}
+func (*SetOpStmt) statementNode()          {}
+func (*WithStmt) statementNode()           {}
+func (*InsertStmt) statementNode()         {}
+func (*UpdateStmt) statementNode()         {}
+func (*DeleteStmt) statementNode()         {}
+func (*CreateTableStmt) statementNode()    {}
+func (*CreateDatabaseStmt) statementNode() {}
+func (*AlterTableStmt) statementNode()     {}
+func (*DropStmt) statementNode()           {}
+func (*UserStmt) statementNode()           {}
+
+const (
+	LitString = iota
+	LitNumber
+	LitNull
+	LitBool
+)
+
+type Ident struct {
+	Pos
+	Table string
+	Name  string
+}
+
+type Literal struct {
+	Pos
+	Kind  int
+	Value string
+}
+
+type BinaryExpr struct {
+	Pos
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+type UnaryExpr struct {
+	Pos
+	Op      string
+	Operand Expr
+}
+
+type LikeExpr struct {
+	Pos
+	Not     bool
+	Expr    Expr
+	Pattern Expr
+}
+
+type InExpr struct {
+	Pos
+	Not      bool
+	Expr     Expr
+	List     []Expr
+	Subquery Statement
+}
+
+type BetweenExpr struct {
+	Pos
+	Not  bool
+	Expr Expr
+	Low  Expr
+	High Expr
+}
+
+type IsNullExpr struct {
+	Pos
+	Not  bool
+	Expr Expr
+}
+
+type FuncCall struct {
+	Pos
+	Name     string
+	Distinct bool
+	Star     bool
+	Args     []Expr
+	Over     *WindowSpec
+}
+
+type WindowSpec struct {
+	PartitionBy []Expr
+	OrderBy     []*OrderItem
+}
+
+type CaseExpr struct {
+	Pos
+	Operand Expr
+	Whens   []*WhenClause
+	Else    Expr
+}
+
+type WhenClause struct {
+	Cond   Expr
+	Result Expr
+}
+
+type SubqueryExpr struct {
+	Pos
+	Select Statement
+}
+
+type ExistsExpr struct {
+	Pos
+	Select Statement
+}
+
+type CastExpr struct {
+	Pos
+	Expr Expr
+	Type string
+}
+
+func (p Pos) Position() Pos {
+	return p
+}
+
+func children(expr Expr) []Expr {
+	switch e := expr.(type) {
+	case *BinaryExpr:
+		return []Expr{e.Left, e.Right}
+	case *UnaryExpr:
+		return []Expr{e.Operand}
+	case *LikeExpr:
+		return []Expr{e.Expr, e.Pattern}
+	case *InExpr:
+		return append([]Expr{e.Expr}, e.List...)
+	case *BetweenExpr:
+		return []Expr{e.Expr, e.Low, e.High}
+	case *IsNullExpr:
+		return []Expr{e.Expr}
+	case *FuncCall:
+		if e.Over == nil {
+			return e.Args
+		}
+		exprs := append(append([]Expr{}, e.Args...), e.Over.PartitionBy...)
+		for _, item := range e.Over.OrderBy {
+			exprs = append(exprs, item.Expr)
+		}
+		return exprs
+	case *CaseExpr:
+		exprs := []Expr{e.Operand}
+		for _, when := range e.Whens {
+			exprs = append(exprs, when.Cond, when.Result)
+		}
+		return append(exprs, e.Else)
+	case *CastExpr:
+		return []Expr{e.Expr}
+	}
+	return nil
+}
+
+func walkExpr(expr Expr, fn func(Expr) bool) {
+	if expr == nil || !fn(expr) {
+		return
+	}
+	for _, child := range children(expr) {
+		walkExpr(child, fn)
+	}
+}
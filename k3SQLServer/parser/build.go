@@ -0,0 +1,680 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"k3SQLServer/shared"
+	"k3SQLServer/storage"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func lookupTable(db, name string) (*shared.K3Table, error) {
+	return storage.LoadTable(db, name)
+}
+
+// orderBy sorts NULL above every value unless the item says otherwise.
+func orderBy(item *OrderItem) shared.K3OrderBy {
+	return shared.K3OrderBy{Desc: item.Desc, NullsFirst: item.Nulls == "FIRST" || item.Nulls == "" && item.Desc}
+}
+
+func queryError(kind string, pos Pos, format string, args ...any) error {
+	return fmt.Errorf("%s at line %d, column %d: %s", kind, pos.Line, pos.Col, fmt.Sprintf(format, args...))
+}
+
+func notSupported(pos Pos, feature string) error {
+	return queryError(shared.NotSupported, pos, "%s", feature)
+}
+
+func literalValue(expr Expr) (string, error) {
+	lit, ok := expr.(*Literal)
+	if !ok || lit.Kind == LitNull {
+		return "", notSupported(expr.Position(), "only string, number and boolean literals are allowed here")
+	}
+	if lit.Kind == LitBool {
+		return strings.ToLower(lit.Value), nil
+	}
+	return lit.Value, nil
+}
+
+var joinTypes = map[string]int{
+	"INNER": shared.K3InnerJoin,
+	"LEFT":  shared.K3LeftJoin,
+	"RIGHT": shared.K3RightJoin,
+	"FULL":  shared.K3FullJoin,
+	"CROSS": shared.K3CrossJoin,
+}
+
+type selectColumn struct {
+	expr          *shared.K3Expr
+	name          string
+	qualifiedName string
+	alias         bool
+	pos           Pos
+}
+
+func BuildSelectQuery(stmt Statement, db string) (*shared.K3SelectQuery, error) {
+	return buildQuery(stmt, db, nil, nil)
+}
+
+func buildQuery(stmt Statement, db string, outer *buildScope, ctes *cteScope) (*shared.K3SelectQuery, error) {
+	switch stmt := stmt.(type) {
+	case *SelectStmt:
+		return buildSelect(stmt, db, outer, ctes)
+	case *SetOpStmt:
+		return buildSetOperation(stmt, db, outer, ctes)
+	case *WithStmt:
+		return buildWith(stmt, db, outer, ctes)
+	}
+	return nil, errors.New(shared.InvalidSQLSyntax)
+}
+
+func buildSetOperation(stmt *SetOpStmt, db string, outer *buildScope, ctes *cteScope) (*shared.K3SelectQuery, error) {
+	left, err := buildQuery(stmt.Left, db, outer, ctes)
+	if err != nil {
+		return nil, err
+	}
+	right, err := buildQuery(stmt.Right, db, outer, ctes)
+	if err != nil {
+		return nil, err
+	}
+	if len(left.Values) != len(right.Values) {
+		return nil, queryError(shared.InvalidSQLLogic, stmt.Pos, "each %s query must have the same number of columns", stmt.Op)
+	}
+	query := &shared.K3SelectQuery{
+		SetOp:      &shared.K3SetOperation{Operator: stmt.Op, All: stmt.All, Left: left, Right: right},
+		Columns:    left.Columns,
+		Correlated: left.Correlated || right.Correlated,
+	}
+	for i, column := range left.Columns {
+		columnType, err := commonType(stmt.Op, stmt.Pos, []*shared.K3Expr{left.Values[i], right.Values[i]})
+		if err != nil {
+			return nil, err
+		}
+		query.Values = append(query.Values, &shared.K3Expr{Kind: shared.K3ExprColumn, Type: columnType, Column: column})
+	}
+	for _, item := range stmt.OrderBy {
+		order := orderBy(item)
+		switch e := item.Expr.(type) {
+		case *Literal:
+			if e.Kind == LitNumber {
+				order.Expr, err = selectListExpr(query.Values, e)
+			}
+		case *Ident:
+			if i := slices.Index(query.Columns, e.Name); i >= 0 && e.Table == "" {
+				order.Expr = query.Values[i]
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if order.Expr == nil {
+			return nil, queryError(shared.InvalidSQLLogic, item.Expr.Position(), "ORDER BY on a %s result must name a result column", stmt.Op)
+		}
+		query.OrderBy = append(query.OrderBy, order)
+	}
+	if err := buildLimit(query, stmt.Limit, stmt.Offset); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+func buildSelect(stmt *SelectStmt, db string, outer *buildScope, ctes *cteScope) (*shared.K3SelectQuery, error) {
+	table, err := ctes.lookup(db, stmt.From.Name)
+	if err != nil {
+		return nil, err
+	}
+	query := &shared.K3SelectQuery{Table: table, Alias: tableAlias(stmt.From), Distinct: stmt.Distinct}
+	scope := &buildScope{db: db, query: query, qualified: len(stmt.Joins) > 0, outer: outer, ctes: ctes}
+	scope.sources = append(scope.sources, scopeSource{alias: query.Alias, fields: table.Fields, types: table.Types})
+	for _, join := range stmt.Joins {
+		joinTable, err := ctes.lookup(db, join.Table.Name)
+		if err != nil {
+			return nil, err
+		}
+		alias := tableAlias(join.Table)
+		for _, source := range scope.sources {
+			if source.alias == alias {
+				return nil, queryError(shared.InvalidSQLLogic, join.Table.Pos, "table name %s specified more than once", alias)
+			}
+		}
+		scope.sources = append(scope.sources, scopeSource{alias: alias, fields: joinTable.Fields, types: joinTable.Types})
+		on, err := buildCondition(join.On, scope, "JOIN ON")
+		if err != nil {
+			return nil, err
+		}
+		query.Joins = append(query.Joins, shared.K3Join{Table: joinTable, Alias: alias, Type: joinTypes[join.Type], On: on})
+	}
+	if query.Where, err = buildCondition(stmt.Where, scope, "WHERE"); err != nil {
+		return nil, err
+	}
+	scope.aggregates, scope.windows = true, true
+	var columns []selectColumn
+	for _, item := range stmt.Columns {
+		if item.Star {
+			found := false
+			for _, source := range scope.sources {
+				if item.StarTable != "" && item.StarTable != source.alias {
+					continue
+				}
+				found = true
+				for _, field := range source.fields {
+					column, err := scope.resolve(&Ident{Pos: item.Pos, Table: source.alias, Name: field})
+					if err != nil {
+						return nil, err
+					}
+					columns = append(columns, selectColumn{expr: column, name: field, qualifiedName: source.alias + "." + field, pos: item.Pos})
+				}
+			}
+			if !found {
+				return nil, queryError(shared.InvalidSQLLogic, item.Pos, "unknown table %s", item.StarTable)
+			}
+			continue
+		}
+		value, err := buildExpr(item.Expr, scope)
+		if err != nil {
+			return nil, err
+		}
+		if isCondition(value) {
+			return nil, notSupported(item.Pos, "conditions in the select list")
+		}
+		column := selectColumn{expr: value, name: exprString(item.Expr), alias: item.Alias != "", pos: item.Pos}
+		switch {
+		case column.alias:
+			column.name = item.Alias
+		case value.Kind == shared.K3ExprColumn:
+			column.name = value.Column
+			column.qualifiedName = recordKey(value)
+		case value.Kind == shared.K3ExprAggregate:
+			column.name = value.Column
+		case value.Kind == shared.K3ExprSubquery:
+			column.name = value.Subquery.Columns[0]
+		}
+		if column.qualifiedName == "" {
+			column.qualifiedName = column.name
+		}
+		columns = append(columns, column)
+	}
+	for _, column := range columns {
+		name := column.name
+		for _, other := range columns {
+			if other.name == column.name && other.qualifiedName != column.qualifiedName {
+				name = column.qualifiedName
+			}
+		}
+		for j, other := range query.Columns {
+			if other == name && !reflect.DeepEqual(columns[j].expr, column.expr) {
+				return nil, queryError(shared.InvalidSQLLogic, column.pos, "column name %s is used more than once in the select list", name)
+			}
+		}
+		query.Values = append(query.Values, column.expr)
+		query.Columns = append(query.Columns, name)
+	}
+	for _, expr := range stmt.GroupBy {
+		if _, ok := expr.(*Ident); !ok {
+			return nil, notSupported(expr.Position(), "GROUP BY expressions")
+		}
+		group, err := buildExpr(expr, scope)
+		if err != nil {
+			return nil, err
+		}
+		query.GroupBy = append(query.GroupBy, group)
+	}
+	scope.windows = false
+	if query.Having, err = buildCondition(stmt.Having, scope, "HAVING"); err != nil {
+		return nil, err
+	}
+	scope.windows = true
+	var orderExprs []Expr
+	for _, item := range stmt.OrderBy {
+		order := orderBy(item)
+		if lit, ok := item.Expr.(*Literal); ok && lit.Kind == LitNumber {
+			if order.Expr, err = selectListExpr(query.Values, lit); err != nil {
+				return nil, err
+			}
+		} else if order.Expr = aliasExpr(columns, item.Expr); order.Expr == nil {
+			if order.Expr, err = buildExpr(item.Expr, scope); err != nil {
+				return nil, err
+			}
+			orderExprs = append(orderExprs, item.Expr)
+		}
+		if query.Distinct && !slices.ContainsFunc(query.Values, func(value *shared.K3Expr) bool {
+			return reflect.DeepEqual(value, order.Expr)
+		}) {
+			return nil, queryError(shared.InvalidSQLLogic, item.Expr.Position(), "for SELECT DISTINCT, ORDER BY expressions must appear in select list")
+		}
+		query.OrderBy = append(query.OrderBy, order)
+	}
+	if len(query.GroupBy) > 0 || len(query.Aggregates) > 0 || query.Having != nil {
+		if err := checkGrouping(stmt, orderExprs, scope); err != nil {
+			return nil, err
+		}
+	}
+	if err := buildLimit(query, stmt.Limit, stmt.Offset); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+func buildLimit(query *shared.K3SelectQuery, limit, offset Expr) error {
+	var err error
+	if limit != nil {
+		query.HasLimit = true
+		if query.Limit, err = nonNegativeInt(limit, "LIMIT"); err != nil {
+			return err
+		}
+	}
+	if offset != nil {
+		if query.Offset, err = nonNegativeInt(offset, "OFFSET"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tableAlias(ref *TableRef) string {
+	if ref.Alias != "" {
+		return ref.Alias
+	}
+	return ref.Name
+}
+
+func aliasExpr(columns []selectColumn, expr Expr) *shared.K3Expr {
+	ident, ok := expr.(*Ident)
+	if !ok || ident.Table != "" {
+		return nil
+	}
+	for _, column := range columns {
+		if column.alias && column.name == ident.Name {
+			return column.expr
+		}
+	}
+	return nil
+}
+
+func checkGrouping(stmt *SelectStmt, orderExprs []Expr, scope *buildScope) error {
+	groupKeys := make([]string, len(scope.query.GroupBy))
+	for i, group := range scope.query.GroupBy {
+		groupKeys[i] = recordKey(group)
+	}
+	exprs := []Expr{stmt.Having}
+	for _, item := range stmt.Columns {
+		if item.Star {
+			return queryError(shared.InvalidSQLLogic, item.Pos, "* is not allowed in a grouped query")
+		}
+		exprs = append(exprs, item.Expr)
+	}
+	for _, expr := range append(exprs, orderExprs...) {
+		var err error
+		walkExpr(expr, func(e Expr) bool {
+			if isAggregateCall(e) || err != nil {
+				return false
+			}
+			if ident, ok := e.(*Ident); ok {
+				column, resolveErr := scope.resolve(ident)
+				if resolveErr != nil {
+					err = resolveErr
+				} else if !strings.HasPrefix(column.Column, shared.K3OuterPrefix) && !slices.Contains(groupKeys, recordKey(column)) {
+					err = queryError(shared.InvalidSQLLogic, ident.Pos, "column %s must appear in the GROUP BY clause or be used in an aggregate function", exprString(ident))
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func selectListExpr(exprs []*shared.K3Expr, lit *Literal) (*shared.K3Expr, error) {
+	position, err := strconv.Atoi(lit.Value)
+	if err != nil || position < 1 || position > len(exprs) {
+		return nil, queryError(shared.InvalidSQLLogic, lit.Pos, "ORDER BY position %s is not in select list", lit.Value)
+	}
+	return exprs[position-1], nil
+}
+
+func nonNegativeInt(expr Expr, clause string) (int, error) {
+	if lit, ok := expr.(*Literal); ok && lit.Kind == LitNumber {
+		if n, err := strconv.Atoi(lit.Value); err == nil && n >= 0 {
+			return n, nil
+		}
+	}
+	return 0, queryError(shared.InvalidSQLLogic, expr.Position(), "%s must be a non-negative integer", clause)
+}
+
+func buildCreateAs(stmt *CreateTableStmt, query *shared.K3CreateQuery, db string) (*shared.K3CreateQuery, error) {
+	selectQuery, err := BuildSelectQuery(stmt.AsSelect, db)
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range selectQuery.Columns {
+		if strings.ContainsAny(name, "|. \t") {
+			return nil, queryError(shared.InvalidSQLLogic, stmt.Table.Pos, "column name %s cannot be used in a table, give it an alias", name)
+		}
+		if _, ok := query.Fields[name]; ok {
+			return nil, queryError(shared.InvalidSQLLogic, stmt.Table.Pos, "duplicate column %s", name)
+		}
+		query.Fields[name] = selectQuery.Values[i].Type
+		if query.Fields[name] == 0 {
+			query.Fields[name] = shared.K3TEXT
+		}
+		query.Table.Fields = append(query.Table.Fields, name)
+	}
+	query.Select = selectQuery
+	return query, nil
+}
+
+func BuildInsertQuery(stmt *InsertStmt, db string) (*shared.K3InsertQuery, error) {
+	table, err := lookupTable(db, stmt.Table.Name)
+	if err != nil {
+		return nil, err
+	}
+	query := &shared.K3InsertQuery{Table: table, Values: make([]map[string]string, len(stmt.Rows))}
+	if stmt.Ignore {
+		query.Conflict = &shared.K3Conflict{}
+	}
+	if stmt.Conflict != nil {
+		if stmt.Ignore {
+			return nil, queryError(shared.InvalidSQLLogic, stmt.Conflict.Pos, "INSERT IGNORE cannot be combined with ON CONFLICT")
+		}
+		if query.Conflict, err = buildConflict(stmt.Conflict, table); err != nil {
+			return nil, err
+		}
+	}
+	if query.Returning, err = buildReturning(stmt.Returning, stmt.Table, db); err != nil {
+		return nil, err
+	}
+	if stmt.Select != nil {
+		return buildInsertSelect(stmt, query, db)
+	}
+	if err := checkInsertColumns(stmt, stmt.Columns, table); err != nil {
+		return nil, err
+	}
+	query.Columns = stmt.Columns
+	for i, row := range stmt.Rows {
+		if len(row) != len(stmt.Columns) {
+			pos := stmt.Table.Pos
+			if len(row) > 0 {
+				pos = row[0].Position()
+			}
+			return nil, queryError(shared.InvalidSQLLogic, pos, "%d columns but %d values", len(stmt.Columns), len(row))
+		}
+		query.Values[i] = make(map[string]string, len(row))
+		for j, expr := range row {
+			if lit, ok := expr.(*Literal); ok && lit.Kind == LitNull {
+				continue
+			}
+			value, err := literalValue(expr)
+			if err != nil {
+				return nil, err
+			}
+			query.Values[i][stmt.Columns[j]] = value
+		}
+	}
+	return query, nil
+}
+
+func checkInsertColumns(stmt *InsertStmt, columns []string, table *shared.K3Table) error {
+	for i, column := range columns {
+		if !slices.Contains(table.Fields, column) {
+			return queryError(shared.ColumnNotExists, stmt.Table.Pos, "%s", column)
+		}
+		if slices.Contains(columns[:i], column) {
+			return queryError(shared.InvalidSQLLogic, stmt.Table.Pos, "column %s specified more than once", column)
+		}
+	}
+	return nil
+}
+
+func buildInsertSelect(stmt *InsertStmt, query *shared.K3InsertQuery, db string) (*shared.K3InsertQuery, error) {
+	columns := stmt.Columns
+	if columns == nil {
+		columns = query.Table.Fields
+	}
+	if err := checkInsertColumns(stmt, columns, query.Table); err != nil {
+		return nil, err
+	}
+	selectQuery, err := BuildSelectQuery(stmt.Select, db)
+	if err != nil {
+		return nil, err
+	}
+	if len(selectQuery.Values) != len(columns) {
+		return nil, queryError(shared.InvalidSQLLogic, stmt.Table.Pos, "%d columns but %d values", len(columns), len(selectQuery.Values))
+	}
+	query.Columns, query.Select = columns, selectQuery
+	return query, nil
+}
+
+func BuildUpdateQuery(stmt *UpdateStmt, db string) (*shared.K3UpdateQuery, error) {
+	table, err := lookupTable(db, stmt.Table.Name)
+	if err != nil {
+		return nil, err
+	}
+	query := &shared.K3UpdateQuery{Table: table, SetValues: make(map[string]*shared.K3Expr, len(stmt.Set))}
+	scope := tableScope(table)
+	if query.SetValues, err = buildAssignments(stmt.Set, table, scope); err != nil {
+		return nil, err
+	}
+	if query.Where, err = buildCondition(stmt.Where, scope, "WHERE"); err != nil {
+		return nil, err
+	}
+	if query.Returning, err = buildReturning(stmt.Returning, stmt.Table, db); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+func buildAssignments(set []*Assignment, table *shared.K3Table, scope *buildScope) (map[string]*shared.K3Expr, error) {
+	values := make(map[string]*shared.K3Expr, len(set))
+	for _, assignment := range set {
+		if !slices.Contains(table.Fields, assignment.Column) {
+			return nil, queryError(shared.ColumnNotExists, assignment.Pos, "%s", assignment.Column)
+		}
+		if _, ok := values[assignment.Column]; ok {
+			return nil, queryError(shared.InvalidSQLLogic, assignment.Pos, "column %s is assigned more than once", assignment.Column)
+		}
+		value, err := buildExpr(assignment.Value, scope)
+		if err != nil {
+			return nil, err
+		}
+		if isCondition(value) {
+			return nil, notSupported(assignment.Pos, "conditions in SET")
+		}
+		values[assignment.Column] = value
+	}
+	return values, nil
+}
+
+func buildConflict(clause *ConflictClause, table *shared.K3Table) (*shared.K3Conflict, error) {
+	conflict := &shared.K3Conflict{Columns: clause.Columns}
+	for i, column := range clause.Columns {
+		if !slices.Contains(table.Fields, column) {
+			return nil, queryError(shared.ColumnNotExists, clause.Pos, "%s", column)
+		}
+		if slices.Contains(clause.Columns[:i], column) {
+			return nil, queryError(shared.InvalidSQLLogic, clause.Pos, "column %s specified more than once", column)
+		}
+	}
+	if clause.Columns != nil && !slices.ContainsFunc(table.Constraints, func(c *shared.K3Constraint) bool {
+		return (c.Kind == shared.K3PrimaryKey || c.Kind == shared.K3Unique) && sameColumns(c.Columns, clause.Columns)
+	}) {
+		return nil, queryError(shared.InvalidSQLLogic, clause.Pos, "there is no unique or exclusion constraint matching the ON CONFLICT specification")
+	}
+	if clause.Set == nil {
+		return conflict, nil
+	}
+	if clause.Columns == nil {
+		return nil, queryError(shared.InvalidSQLLogic, clause.Pos, "ON CONFLICT DO UPDATE requires a conflict target")
+	}
+	scope := tableScope(table)
+	scope.qualified = true
+	scope.sources = append(scope.sources, scopeSource{alias: "excluded", fields: table.Fields, types: table.Types})
+	var err error
+	if conflict.Update, err = buildAssignments(clause.Set, table, scope); err != nil {
+		return nil, err
+	}
+	if conflict.Where, err = buildCondition(clause.Where, scope, "WHERE"); err != nil {
+		return nil, err
+	}
+	return conflict, nil
+}
+
+func BuildDeleteQuery(stmt *DeleteStmt, db string) (*shared.K3DeleteQuery, error) {
+	table, err := lookupTable(db, stmt.Table.Name)
+	if err != nil {
+		return nil, err
+	}
+	query := &shared.K3DeleteQuery{Table: table}
+	if query.Where, err = buildCondition(stmt.Where, tableScope(table), "WHERE"); err != nil {
+		return nil, err
+	}
+	if query.Returning, err = buildReturning(stmt.Returning, stmt.Table, db); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+func buildReturning(items []*SelectItem, ref *TableRef, db string) (*shared.K3SelectQuery, error) {
+	if items == nil {
+		return nil, nil
+	}
+	query, err := buildSelect(&SelectStmt{Columns: items, From: ref}, db, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(query.Aggregates) > 0 || len(query.Windows) > 0 {
+		return nil, queryError(shared.InvalidSQLLogic, items[0].Pos, "aggregate and window functions are not allowed in RETURNING")
+	}
+	return query, nil
+}
+
+func BuildCreateQuery(stmt *CreateTableStmt, db string) (*shared.K3CreateQuery, error) {
+	if stmt.Temporary {
+		return nil, notSupported(stmt.Table.Pos, "TEMPORARY tables")
+	}
+	table := &shared.K3Table{Name: stmt.Table.Name, Database: db, Mu: new(sync.RWMutex), LU: time.Now()}
+	query := &shared.K3CreateQuery{Table: table, Fields: make(map[string]int, len(stmt.Columns))}
+	table.Fields = make([]string, 0, len(stmt.Columns))
+	if stmt.AsSelect != nil {
+		return buildCreateAs(stmt, query, db)
+	}
+	for _, column := range stmt.Columns {
+		if _, ok := query.Fields[column.Name]; ok {
+			return nil, queryError(shared.InvalidSQLLogic, column.Pos, "duplicate column %s", column.Name)
+		}
+		columnType, ok := columnTypes[column.Type]
+		if !ok {
+			return nil, queryError(shared.InvalidSQLLogic, column.Pos, "invalid type %s", column.Type)
+		}
+		query.Fields[column.Name] = columnType
+		table.Fields = append(table.Fields, column.Name)
+	}
+	table.Types = query.Fields
+	constraints, err := buildConstraints(stmt.Constraints, table, nil)
+	if err != nil {
+		return nil, err
+	}
+	query.Constraints = constraints
+	return query, nil
+}
+
+func BuildCreateDatabaseQuery(stmt *CreateDatabaseStmt) (*shared.K3CreateQuery, error) {
+	table := &shared.K3Table{Name: "", Database: stmt.Name, Mu: nil, LU: time.Now()}
+	return &shared.K3CreateQuery{Table: table}, nil
+}
+
+func BuildAlterQuery(stmt *AlterTableStmt, db string) (*shared.K3AlterQuery, error) {
+	table, err := lookupTable(db, stmt.Table.Name)
+	if err != nil {
+		return nil, err
+	}
+	query := &shared.K3AlterQuery{Table: table, Column: stmt.Column.Name, NewName: stmt.NewName}
+	exists := slices.Contains(table.Fields, stmt.Column.Name)
+	switch stmt.Action {
+	case "ADD COLUMN":
+		query.Action = shared.K3AlterAddColumn
+		if exists {
+			return nil, queryError(shared.InvalidSQLLogic, stmt.Column.Pos, "column %s already exists", stmt.Column.Name)
+		}
+		columnType, ok := columnTypes[stmt.Column.Type]
+		if !ok {
+			return nil, queryError(shared.InvalidSQLLogic, stmt.Column.Pos, "invalid type %s", stmt.Column.Type)
+		}
+		shape := &shared.K3Table{Name: table.Name, Database: db, Fields: append(slices.Clone(table.Fields), stmt.Column.Name),
+			Types: map[string]int{stmt.Column.Name: columnType}}
+		for field, fieldType := range table.Types {
+			shape.Types[field] = fieldType
+		}
+		if query.Constraints, err = buildConstraints(stmt.Constraints, shape, table.Constraints); err != nil {
+			return nil, err
+		}
+		if columnConstraint(query.Constraints, shared.K3ForeignKey, stmt.Column.Name) != nil &&
+			columnConstraint(query.Constraints, shared.K3Default, stmt.Column.Name) != nil {
+			return nil, notSupported(stmt.Column.Pos, "DEFAULT on a new REFERENCES column")
+		}
+	case "DROP COLUMN":
+		query.Action = shared.K3AlterDropColumn
+		if exists && len(table.Fields) == 1 {
+			return nil, queryError(shared.InvalidSQLLogic, stmt.Column.Pos, "cannot drop the only column of table %s", table.Name)
+		}
+		if constraint := constraintUsing(table, stmt.Column.Name); constraint != nil {
+			return nil, queryError(shared.InvalidSQLLogic, stmt.Column.Pos, "column %s is used by constraint %s", stmt.Column.Name, constraint.Name)
+		}
+	case "RENAME COLUMN":
+		query.Action = shared.K3AlterRenameColumn
+		if slices.Contains(table.Fields, stmt.NewName) {
+			return nil, queryError(shared.InvalidSQLLogic, stmt.Column.Pos, "column %s already exists", stmt.NewName)
+		}
+	case "ALTER COLUMN TYPE":
+		query.Action = shared.K3AlterColumnType
+		for _, constraint := range table.Constraints {
+			if constraint.Kind == shared.K3ForeignKey && (slices.Contains(constraint.Columns, stmt.Column.Name) ||
+				constraint.RefTable == table.Name && slices.Contains(constraint.RefColumns, stmt.Column.Name)) {
+				return nil, queryError(shared.InvalidSQLLogic, stmt.Column.Pos, "column %s is used by constraint %s", stmt.Column.Name, constraint.Name)
+			}
+		}
+		if columnType, ok := columnTypes[stmt.Column.Type]; ok && exists {
+			if query.Constraints, err = retypeConstraints(table, stmt.Column.Name, columnType, stmt.Column.Pos); err != nil {
+				return nil, err
+			}
+		}
+	case "RENAME TO":
+		query.Action = shared.K3AlterRenameTable
+		if _, err := lookupTable(db, stmt.NewName); err == nil {
+			return nil, errors.New(shared.TableAlreadyExists)
+		}
+		return query, nil
+	}
+	if !exists && query.Action != shared.K3AlterAddColumn {
+		return nil, queryError(shared.ColumnNotExists, stmt.Column.Pos, "%s", stmt.Column.Name)
+	}
+	if stmt.Column.Type != "" {
+		var ok bool
+		if query.Type, ok = columnTypes[stmt.Column.Type]; !ok {
+			return nil, queryError(shared.InvalidSQLLogic, stmt.Column.Pos, "invalid type %s", stmt.Column.Type)
+		}
+	}
+	return query, nil
+}
+
+func BuildDropQuery(stmt *DropStmt, db string) (*shared.K3Table, error) {
+	if stmt.Object != "TABLE" {
+		return nil, notSupported(stmt.Pos, "DROP "+stmt.Object)
+	}
+	return lookupTable(db, stmt.Name)
+}
+
+func BuildUserQuery(stmt *UserStmt, db string) (*shared.K3UserQuery, error) {
+	return &shared.K3UserQuery{
+		Database: db,
+		Action:   stmt.Action,
+		Username: stmt.Username,
+		Password: stmt.Password,
+	}, nil
+}
@@ -0,0 +1,361 @@
+package parser
+
+import (
+	"k3SQLServer/shared"
+	"k3SQLServer/storage"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var constraintKinds = map[string]int{
+	"PRIMARY KEY": shared.K3PrimaryKey,
+	"UNIQUE":      shared.K3Unique,
+	"NOT NULL":    shared.K3NotNull,
+	"DEFAULT":     shared.K3Default,
+	"CHECK":       shared.K3Check,
+	"FOREIGN KEY": shared.K3ForeignKey,
+}
+
+var constraintSuffixes = map[int]string{
+	shared.K3PrimaryKey: "pkey",
+	shared.K3Unique:     "key",
+	shared.K3NotNull:    "not_null",
+	shared.K3Default:    "default",
+	shared.K3Check:      "check",
+	shared.K3ForeignKey: "fkey",
+}
+
+var referentialActions = map[string]int{
+	"NO ACTION": shared.K3NoAction,
+	"RESTRICT":  shared.K3Restrict,
+	"CASCADE":   shared.K3Cascade,
+	"SET NULL":  shared.K3SetNull,
+}
+
+// buildConstraints appends the constraints in defs to existing ones, checking
+// them against the columns of table.
+func buildConstraints(defs []*ConstraintDef, table *shared.K3Table, existing []*shared.K3Constraint) ([]*shared.K3Constraint, error) {
+	constraints := slices.Clone(existing)
+	names := make(map[string]bool, len(existing)+len(defs))
+	for _, constraint := range existing {
+		names[constraint.Name] = true
+	}
+	// foreign keys go last so that they can reference keys of the same table
+	ordered := slices.Clone(defs)
+	slices.SortStableFunc(ordered, func(a, b *ConstraintDef) int {
+		return strings.Compare(strconv.FormatBool(a.Kind == "FOREIGN KEY"), strconv.FormatBool(b.Kind == "FOREIGN KEY"))
+	})
+	for _, def := range ordered {
+		constraint := &shared.K3Constraint{Name: def.Name, Kind: constraintKinds[def.Kind], Columns: def.Columns}
+		for i, column := range def.Columns {
+			if _, ok := table.Types[column]; !ok {
+				return nil, queryError(shared.ColumnNotExists, def.Pos, "%s", column)
+			}
+			if slices.Contains(def.Columns[:i], column) {
+				return nil, queryError(shared.InvalidSQLLogic, def.Pos, "column %s appears twice in %s constraint", column, def.Kind)
+			}
+		}
+		switch constraint.Kind {
+		case shared.K3PrimaryKey:
+			if slices.ContainsFunc(constraints, func(c *shared.K3Constraint) bool { return c.Kind == shared.K3PrimaryKey }) {
+				return nil, queryError(shared.InvalidSQLLogic, def.Pos, "multiple primary keys for table %s are not allowed", table.Name)
+			}
+		case shared.K3NotNull:
+			if columnConstraint(constraints, shared.K3NotNull, def.Columns[0]) != nil {
+				continue
+			}
+		case shared.K3Default:
+			if columnConstraint(constraints, shared.K3Default, def.Columns[0]) != nil {
+				return nil, queryError(shared.InvalidSQLLogic, def.Pos, "multiple default values specified for column %s", def.Columns[0])
+			}
+			expr, err := buildDefault(def, table.Types[def.Columns[0]])
+			if err != nil {
+				return nil, err
+			}
+			constraint.Expr = expr
+		case shared.K3Check:
+			expr, err := buildCheck(def, table)
+			if err != nil {
+				return nil, err
+			}
+			constraint.Expr, constraint.Columns = expr, exprColumns(expr, nil)
+		case shared.K3ForeignKey:
+			if err := buildForeignKey(def, constraint, table, constraints); err != nil {
+				return nil, err
+			}
+		}
+		if constraint.Name == "" {
+			constraint.Name = constraintName(table.Name, constraint, names)
+		}
+		if names[constraint.Name] {
+			return nil, queryError(shared.InvalidSQLLogic, def.Pos, "duplicate constraint %s", constraint.Name)
+		}
+		names[constraint.Name] = true
+		constraints = append(constraints, constraint)
+	}
+	return constraints, nil
+}
+
+func buildForeignKey(def *ConstraintDef, constraint *shared.K3Constraint, table *shared.K3Table, constraints []*shared.K3Constraint) error {
+	parent, parentConstraints := table, constraints
+	if def.RefTable != table.Name {
+		var err error
+		if parent, err = lookupTable(table.Database, def.RefTable); err != nil {
+			return queryError(shared.TableNotExists, def.Pos, "%s", def.RefTable)
+		}
+		if strings.HasPrefix(parent.Name, shared.K3ServiceTablesPrefix) {
+			return queryError(shared.AccessDenied, def.Pos, "cannot reference service table %s", parent.Name)
+		}
+		parentConstraints = parent.Constraints
+	}
+	refColumns := def.RefColumns
+	if refColumns == nil {
+		i := slices.IndexFunc(parentConstraints, func(c *shared.K3Constraint) bool { return c.Kind == shared.K3PrimaryKey })
+		if i < 0 {
+			return queryError(shared.InvalidSQLLogic, def.Pos, "there is no primary key for referenced table %s", parent.Name)
+		}
+		refColumns = parentConstraints[i].Columns
+	}
+	if len(refColumns) != len(def.Columns) {
+		return queryError(shared.InvalidSQLLogic, def.Pos, "number of referencing and referenced columns for foreign key disagree")
+	}
+	for i, column := range refColumns {
+		refType, ok := parent.Types[column]
+		if !ok {
+			return queryError(shared.ColumnNotExists, def.Pos, "%s", column)
+		}
+		if columnType := table.Types[def.Columns[i]]; columnType != refType {
+			return queryError(shared.InvalidSQLLogic, def.Pos, "foreign key column %s of type %s cannot reference %s of type %s",
+				def.Columns[i], columnTypeName(columnType), column, columnTypeName(refType))
+		}
+	}
+	if !slices.ContainsFunc(parentConstraints, func(c *shared.K3Constraint) bool {
+		return (c.Kind == shared.K3PrimaryKey || c.Kind == shared.K3Unique) && sameColumns(c.Columns, refColumns)
+	}) {
+		return queryError(shared.InvalidSQLLogic, def.Pos, "there is no unique constraint matching given keys for referenced table %s", parent.Name)
+	}
+	constraint.RefTable, constraint.RefColumns, constraint.OnDelete = parent.Name, refColumns, referentialActions[def.OnDelete]
+	if constraint.OnDelete == shared.K3SetNull {
+		for _, column := range def.Columns {
+			if columnConstraint(constraints, shared.K3NotNull, column) != nil || slices.ContainsFunc(constraints, func(c *shared.K3Constraint) bool {
+				return c.Kind == shared.K3PrimaryKey && slices.Contains(c.Columns, column)
+			}) {
+				return queryError(shared.InvalidSQLLogic, def.Pos, "column %s is NOT NULL and cannot be SET NULL on delete", column)
+			}
+		}
+	}
+	return nil
+}
+
+func buildDefault(def *ConstraintDef, columnType int) (*shared.K3Expr, error) {
+	expr, err := buildExpr(def.Expr, &buildScope{})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkConstraintExpr(def, expr); err != nil {
+		return nil, err
+	}
+	if isCondition(expr) {
+		return nil, queryError(shared.InvalidSQLLogic, def.Pos, "DEFAULT must be a value, not a condition")
+	}
+	valid := true
+	switch {
+	case columnType == shared.K3TEXT || expr.Kind == shared.K3ExprNull:
+	case expr.Kind == shared.K3ExprLiteral:
+		_, err := storage.CanonicalValue(columnType, expr.Value)
+		valid = err == nil
+	case numericType(columnType):
+		valid = isNumeric(expr) && (columnType != shared.K3INT || expr.Type == shared.K3INT)
+	default:
+		valid = expr.Type == columnType
+	}
+	if !valid {
+		return nil, queryError(shared.InvalidSQLLogic, def.Pos, "DEFAULT of type %s does not match column %s", typeName(expr), def.Columns[0])
+	}
+	return expr, nil
+}
+
+func buildCheck(def *ConstraintDef, table *shared.K3Table) (*shared.K3Expr, error) {
+	expr, err := buildExpr(def.Expr, tableScope(table))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkConstraintExpr(def, expr); err != nil {
+		return nil, err
+	}
+	if !isBoolean(expr) {
+		return nil, queryError(shared.InvalidSQLLogic, def.Pos, "CHECK must be a condition")
+	}
+	return expr, nil
+}
+
+func checkConstraintExpr(def *ConstraintDef, expr *shared.K3Expr) error {
+	var found bool
+	walkK3Expr(expr, func(e *shared.K3Expr) {
+		found = found || e.Subquery != nil
+	})
+	if found {
+		return notSupported(def.Pos, "subqueries in "+def.Kind)
+	}
+	return nil
+}
+
+func walkK3Expr(expr *shared.K3Expr, fn func(*shared.K3Expr)) {
+	if expr == nil {
+		return
+	}
+	fn(expr)
+	for _, arg := range expr.Args {
+		walkK3Expr(arg, fn)
+	}
+}
+
+func exprColumns(expr *shared.K3Expr, columns []string) []string {
+	walkK3Expr(expr, func(e *shared.K3Expr) {
+		if e.Kind == shared.K3ExprColumn && !slices.Contains(columns, e.Column) {
+			columns = append(columns, e.Column)
+		}
+	})
+	return columns
+}
+
+func constraintName(table string, constraint *shared.K3Constraint, names map[string]bool) string {
+	name := table + "_" + constraintSuffixes[constraint.Kind]
+	if constraint.Kind != shared.K3PrimaryKey && len(constraint.Columns) > 0 {
+		name = table + "_" + strings.Join(constraint.Columns, "_") + "_" + constraintSuffixes[constraint.Kind]
+	}
+	if constraint.Kind != shared.K3Check {
+		return name
+	}
+	unique := name
+	for i := 1; names[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	return unique
+}
+
+func columnConstraint(constraints []*shared.K3Constraint, kind int, column string) *shared.K3Constraint {
+	for _, constraint := range constraints {
+		if constraint.Kind == kind && slices.Equal(constraint.Columns, []string{column}) {
+			return constraint
+		}
+	}
+	return nil
+}
+
+// sameColumns reports whether a and b name the same columns in any order.
+func sameColumns(a, b []string) bool {
+	return len(a) == len(b) && !slices.ContainsFunc(a, func(column string) bool { return !slices.Contains(b, column) })
+}
+
+// constraintUsing finds a constraint that would break if column went away;
+// NOT NULL and DEFAULT simply go with their column.
+func constraintUsing(table *shared.K3Table, column string) *shared.K3Constraint {
+	for _, constraint := range table.Constraints {
+		if constraint.Kind != shared.K3NotNull && constraint.Kind != shared.K3Default && slices.Contains(constraint.Columns, column) {
+			return constraint
+		}
+	}
+	return nil
+}
+
+// retypeConstraints builds the CHECK and DEFAULT constraints on column again
+// as if it had columnType, failing when one of them no longer fits.
+func retypeConstraints(table *shared.K3Table, column string, columnType int, pos Pos) ([]*shared.K3Constraint, error) {
+	shape := &shared.K3Table{Name: table.Name, Database: table.Database, Fields: table.Fields, Types: map[string]int{}}
+	for field, fieldType := range table.Types {
+		shape.Types[field] = fieldType
+	}
+	shape.Types[column] = columnType
+	constraints := make([]*shared.K3Constraint, len(table.Constraints))
+	for i, constraint := range table.Constraints {
+		constraints[i] = constraint
+		if (constraint.Kind != shared.K3Check && constraint.Kind != shared.K3Default) || !slices.Contains(constraint.Columns, column) {
+			continue
+		}
+		tree, ok := syntaxTree(constraint.Expr)
+		var expr *shared.K3Expr
+		var err error
+		if ok {
+			def := &ConstraintDef{Pos: pos, Name: constraint.Name, Columns: constraint.Columns, Expr: tree}
+			if constraint.Kind == shared.K3Check {
+				def.Kind = "CHECK"
+				expr, err = buildCheck(def, shape)
+			} else {
+				def.Kind = "DEFAULT"
+				expr, err = buildDefault(def, columnType)
+			}
+		}
+		if !ok || err != nil {
+			return nil, queryError(shared.InvalidSQLLogic, pos, "constraint %s does not fit column %s of type %s", constraint.Name, column, columnTypeName(columnType))
+		}
+		retyped := *constraint
+		retyped.Expr = expr
+		constraints[i] = &retyped
+	}
+	return constraints, nil
+}
+
+// syntaxTree turns a built constraint expression back into the syntax it
+// was built from, or reports false for expressions constraints cannot hold.
+func syntaxTree(expr *shared.K3Expr) (Expr, bool) {
+	args := make([]Expr, len(expr.Args))
+	for i, arg := range expr.Args {
+		var ok bool
+		if args[i], ok = syntaxTree(arg); !ok {
+			return nil, false
+		}
+	}
+	switch expr.Kind {
+	case shared.K3ExprColumn:
+		return &Ident{Table: expr.Table, Name: expr.Column}, true
+	case shared.K3ExprNull:
+		return &Literal{Kind: LitNull, Value: "NULL"}, true
+	case shared.K3ExprLiteral:
+		switch {
+		case expr.Type == shared.K3BOOLEAN:
+			return &Literal{Kind: LitBool, Value: strings.ToUpper(expr.Value)}, true
+		case numericType(expr.Type):
+			return &Literal{Kind: LitNumber, Value: expr.Value}, true
+		}
+		return &Literal{Kind: LitString, Value: expr.Value}, true
+	case shared.K3ExprAnd:
+		return &BinaryExpr{Op: "AND", Left: args[0], Right: args[1]}, true
+	case shared.K3ExprOr:
+		return &BinaryExpr{Op: "OR", Left: args[0], Right: args[1]}, true
+	case shared.K3ExprCompare, shared.K3ExprArithmetic:
+		return &BinaryExpr{Op: expr.Operator, Left: args[0], Right: args[1]}, true
+	case shared.K3ExprConcat:
+		return &BinaryExpr{Op: "||", Left: args[0], Right: args[1]}, true
+	case shared.K3ExprNot:
+		return &UnaryExpr{Op: "NOT", Operand: args[0]}, true
+	case shared.K3ExprNegate:
+		return &UnaryExpr{Op: "-", Operand: args[0]}, true
+	case shared.K3ExprLike:
+		return &LikeExpr{Not: expr.Not, Expr: args[0], Pattern: args[1]}, true
+	case shared.K3ExprIn:
+		if expr.Subquery != nil {
+			return nil, false
+		}
+		return &InExpr{Not: expr.Not, Expr: args[0], List: args[1:]}, true
+	case shared.K3ExprBetween:
+		return &BetweenExpr{Not: expr.Not, Expr: args[0], Low: args[1], High: args[2]}, true
+	case shared.K3ExprIsNull:
+		return &IsNullExpr{Not: expr.Not, Expr: args[0]}, true
+	case shared.K3ExprFunction:
+		return &FuncCall{Name: strings.ToLower(expr.Operator), Args: args}, true
+	case shared.K3ExprCase:
+		result := &CaseExpr{}
+		for i := 0; i+1 < len(args); i += 2 {
+			result.Whens = append(result.Whens, &WhenClause{Cond: args[i], Result: args[i+1]})
+		}
+		if len(args)%2 == 1 {
+			result.Else = args[len(args)-1]
+		}
+		return result, true
+	case shared.K3ExprCast:
+		return &CastExpr{Expr: args[0], Type: columnTypeName(expr.Type)}, true
+	}
+	return nil, false
+}
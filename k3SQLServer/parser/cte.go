@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"k3SQLServer/shared"
+	"k3SQLServer/storage"
+	"slices"
+)
+
+type cteScope struct {
+	tables map[string]*shared.K3Table
+	parent *cteScope
+}
+
+func (c *cteScope) lookup(db, name string) (*shared.K3Table, error) {
+	for scope := c; scope != nil; scope = scope.parent {
+		if table, ok := scope.tables[name]; ok {
+			return table, nil
+		}
+	}
+	return lookupTable(db, name)
+}
+
+func buildWith(stmt *WithStmt, db string, outer *buildScope, ctes *cteScope) (*shared.K3SelectQuery, error) {
+	scope := &cteScope{tables: make(map[string]*shared.K3Table), parent: ctes}
+	var with []*shared.K3CommonTable
+	for _, def := range stmt.CTEs {
+		if _, ok := scope.tables[def.Name]; ok {
+			return nil, queryError(shared.InvalidSQLLogic, def.Pos, "WITH query name %s specified more than once", def.Name)
+		}
+		cte := &shared.K3CommonTable{}
+		table := &shared.K3Table{Database: db, Name: def.Name, CTE: cte}
+		setOp, recursive := def.Query.(*SetOpStmt)
+		recursive = recursive && stmt.Recursive && setOp.Op == "UNION" && setOp.OrderBy == nil && setOp.Limit == nil && setOp.Offset == nil
+		anchorStmt := def.Query
+		if recursive {
+			anchorStmt = setOp.Left
+		}
+		anchor, err := buildQuery(anchorStmt, db, nil, scope)
+		if err != nil {
+			return nil, err
+		}
+		if err := cteColumns(def, table, anchor); err != nil {
+			return nil, err
+		}
+		cte.Query = anchor
+		if recursive {
+			scope.tables[def.Name] = table
+			term, err := buildQuery(setOp.Right, db, nil, scope)
+			if err != nil {
+				return nil, err
+			}
+			if readsTable(term, table) {
+				if len(term.Values) != len(anchor.Values) {
+					return nil, queryError(shared.InvalidSQLLogic, setOp.Pos, "each UNION query must have the same number of columns")
+				}
+				for i, field := range table.Fields {
+					if table.Types[field], err = commonType("UNION", setOp.Pos, []*shared.K3Expr{anchor.Values[i], term.Values[i]}); err != nil {
+						return nil, err
+					}
+				}
+				cte.Recursive, cte.All = term, setOp.All
+			} else {
+				delete(scope.tables, def.Name)
+				if cte.Query, err = buildQuery(def.Query, db, nil, scope); err != nil {
+					return nil, err
+				}
+			}
+		}
+		scope.tables[def.Name] = table
+		with = append(with, cte)
+	}
+	body, err := buildQuery(stmt.Body, db, outer, scope)
+	if err != nil {
+		return nil, err
+	}
+	body.With = append(with, body.With...)
+	return body, nil
+}
+
+func cteColumns(def *CommonTableExpr, table *shared.K3Table, query *shared.K3SelectQuery) error {
+	table.Fields = query.Columns
+	if def.Columns != nil {
+		if len(def.Columns) != len(query.Values) {
+			return queryError(shared.InvalidSQLLogic, def.Pos, "WITH query %s has %d columns available but %d columns specified", def.Name, len(query.Values), len(def.Columns))
+		}
+		table.Fields = def.Columns
+	}
+	table.Types = make(map[string]int, len(table.Fields))
+	for i, field := range table.Fields {
+		if slices.Contains(table.Fields[:i], field) {
+			return queryError(shared.InvalidSQLLogic, def.Pos, "column %s specified more than once in WITH query %s", field, def.Name)
+		}
+		table.Types[field] = query.Values[i].Type
+	}
+	return nil
+}
+
+func readsTable(query *shared.K3SelectQuery, table *shared.K3Table) bool {
+	if query.Table == table {
+		return true
+	}
+	for _, join := range query.Joins {
+		if join.Table == table {
+			return true
+		}
+	}
+	if query.SetOp != nil && (readsTable(query.SetOp.Left, table) || readsTable(query.SetOp.Right, table)) {
+		return true
+	}
+	return exprsReadTable(storage.QueryExprs(query), table)
+}
+
+func exprsReadTable(exprs []*shared.K3Expr, table *shared.K3Table) bool {
+	for _, expr := range exprs {
+		if expr == nil {
+			continue
+		}
+		if expr.Subquery != nil && readsTable(expr.Subquery, table) {
+			return true
+		}
+		if exprsReadTable(expr.Args, table) {
+			return true
+		}
+	}
+	return false
+}
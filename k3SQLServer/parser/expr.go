@@ -0,0 +1,486 @@
+package parser
+
+import (
+	"k3SQLServer/shared"
+	"k3SQLServer/storage"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var aggregateFunctions = map[string]bool{
+	"count": true, "sum": true, "avg": true, "min": true, "max": true,
+}
+
+type scopeSource struct {
+	alias  string
+	fields []string
+	types  map[string]int
+}
+
+type buildScope struct {
+	db         string
+	sources    []scopeSource
+	qualified  bool
+	query      *shared.K3SelectQuery
+	aggregates bool
+	windows    bool
+	outer      *buildScope
+	ctes       *cteScope
+}
+
+func tableScope(table *shared.K3Table) *buildScope {
+	return &buildScope{db: table.Database, sources: []scopeSource{{alias: table.Name, fields: table.Fields, types: table.Types}}}
+}
+
+func (s *buildScope) resolve(ident *Ident) (*shared.K3Expr, error) {
+	column, knownTable, err := s.resolveLocal(ident)
+	if column != nil || err != nil {
+		return column, err
+	}
+	if s.outer != nil && !knownTable {
+		if outerColumn, err := s.outer.resolve(ident); err == nil {
+			s.query.Correlated = true
+			return &shared.K3Expr{Kind: shared.K3ExprColumn, Type: outerColumn.Type, Column: shared.K3OuterPrefix + recordKey(outerColumn)}, nil
+		}
+	}
+	if ident.Table != "" && !knownTable {
+		return nil, queryError(shared.InvalidSQLLogic, ident.Pos, "unknown table %s", ident.Table)
+	}
+	return nil, queryError(shared.ColumnNotExists, ident.Pos, "%s", exprString(ident))
+}
+
+func (s *buildScope) resolveLocal(ident *Ident) (*shared.K3Expr, bool, error) {
+	var match *scopeSource
+	knownTable := false
+	for i, source := range s.sources {
+		if ident.Table != "" {
+			if ident.Table != source.alias {
+				continue
+			}
+			knownTable = true
+		}
+		if slices.Contains(source.fields, ident.Name) {
+			if match != nil {
+				return nil, knownTable, queryError(shared.InvalidSQLLogic, ident.Pos, "column %s is ambiguous", ident.Name)
+			}
+			match = &s.sources[i]
+		}
+	}
+	if match == nil {
+		return nil, knownTable, nil
+	}
+	column := &shared.K3Expr{Kind: shared.K3ExprColumn, Type: match.types[ident.Name], Column: ident.Name}
+	if s.qualified {
+		column.Table = match.alias
+	}
+	return column, knownTable, nil
+}
+
+func recordKey(expr *shared.K3Expr) string {
+	if expr.Table != "" {
+		return expr.Table + "." + expr.Column
+	}
+	return expr.Column
+}
+
+func buildExpr(expr Expr, scope *buildScope) (*shared.K3Expr, error) {
+	if expr == nil {
+		return nil, nil
+	}
+	switch e := expr.(type) {
+	case *Ident:
+		return scope.resolve(e)
+	case *Literal:
+		switch e.Kind {
+		case LitNull:
+			return &shared.K3Expr{Kind: shared.K3ExprNull}, nil
+		case LitBool:
+			return &shared.K3Expr{Kind: shared.K3ExprLiteral, Type: shared.K3BOOLEAN, Value: strings.ToLower(e.Value)}, nil
+		case LitNumber:
+			return &shared.K3Expr{Kind: shared.K3ExprLiteral, Type: numberType(e.Value), Value: e.Value}, nil
+		}
+		return &shared.K3Expr{Kind: shared.K3ExprLiteral, Type: shared.K3TEXT, Value: e.Value}, nil
+	case *BinaryExpr:
+		args, err := buildExprs([]Expr{e.Left, e.Right}, scope)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case "AND":
+			return &shared.K3Expr{Kind: shared.K3ExprAnd, Args: args}, nil
+		case "OR":
+			return &shared.K3Expr{Kind: shared.K3ExprOr, Args: args}, nil
+		case "+", "-", "*", "/", "%":
+			if !isNumeric(args[0]) || !isNumeric(args[1]) {
+				return nil, queryError(shared.InvalidSQLLogic, e.Pos, "operator %s requires numeric operands", e.Op)
+			}
+			resultType := max(args[0].Type, args[1].Type, shared.K3INT)
+			return &shared.K3Expr{Kind: shared.K3ExprArithmetic, Type: resultType, Operator: e.Op, Args: args}, nil
+		case "||":
+			if isCondition(args[0]) || isCondition(args[1]) {
+				return nil, queryError(shared.InvalidSQLLogic, e.Pos, "operator || requires value operands")
+			}
+			return &shared.K3Expr{Kind: shared.K3ExprConcat, Type: shared.K3TEXT, Args: args}, nil
+		}
+		if err := checkComparison(e.Pos, args); err != nil {
+			return nil, err
+		}
+		return &shared.K3Expr{Kind: shared.K3ExprCompare, Operator: e.Op, Args: args}, nil
+	case *UnaryExpr:
+		operand, err := buildExpr(e.Operand, scope)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op == "-" {
+			if !isNumeric(operand) {
+				return nil, queryError(shared.InvalidSQLLogic, e.Pos, "operator - requires a numeric operand")
+			}
+			return &shared.K3Expr{Kind: shared.K3ExprNegate, Type: max(operand.Type, shared.K3INT), Args: []*shared.K3Expr{operand}}, nil
+		}
+		return &shared.K3Expr{Kind: shared.K3ExprNot, Args: []*shared.K3Expr{operand}}, nil
+	case *LikeExpr:
+		args, err := buildExprs([]Expr{e.Expr, e.Pattern}, scope)
+		if err != nil {
+			return nil, err
+		}
+		return &shared.K3Expr{Kind: shared.K3ExprLike, Not: e.Not, Args: args}, nil
+	case *InExpr:
+		args, err := buildExprs(append([]Expr{e.Expr}, e.List...), scope)
+		if err != nil {
+			return nil, err
+		}
+		in := &shared.K3Expr{Kind: shared.K3ExprIn, Not: e.Not, Args: args}
+		if e.Subquery != nil {
+			if in.Subquery, err = buildSubquery(e.Subquery, e.Pos, scope, true); err != nil {
+				return nil, err
+			}
+			args = append(slices.Clip(args), in.Subquery.Values[0])
+		}
+		if err := checkComparison(e.Pos, args); err != nil {
+			return nil, err
+		}
+		return in, nil
+	case *SubqueryExpr:
+		subquery, err := buildSubquery(e.Select, e.Pos, scope, true)
+		if err != nil {
+			return nil, err
+		}
+		return &shared.K3Expr{Kind: shared.K3ExprSubquery, Type: subquery.Values[0].Type, Subquery: subquery}, nil
+	case *ExistsExpr:
+		subquery, err := buildSubquery(e.Select, e.Pos, scope, false)
+		if err != nil {
+			return nil, err
+		}
+		return &shared.K3Expr{Kind: shared.K3ExprExists, Subquery: subquery}, nil
+	case *BetweenExpr:
+		args, err := buildExprs([]Expr{e.Expr, e.Low, e.High}, scope)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkComparison(e.Pos, args); err != nil {
+			return nil, err
+		}
+		return &shared.K3Expr{Kind: shared.K3ExprBetween, Not: e.Not, Args: args}, nil
+	case *IsNullExpr:
+		operand, err := buildExpr(e.Expr, scope)
+		if err != nil {
+			return nil, err
+		}
+		return &shared.K3Expr{Kind: shared.K3ExprIsNull, Not: e.Not, Args: []*shared.K3Expr{operand}}, nil
+	case *FuncCall:
+		if e.Over != nil {
+			return buildWindow(e, scope)
+		}
+		if windowFunctions[e.Name] {
+			return nil, queryError(shared.InvalidSQLLogic, e.Pos, "window function %s requires an OVER clause", e.Name)
+		}
+		if aggregateFunctions[e.Name] {
+			return buildAggregate(e, scope)
+		}
+		return buildFunction(e, scope)
+	case *CaseExpr:
+		return buildCase(e, scope)
+	case *CastExpr:
+		return buildCast(e, scope)
+	}
+	return nil, notSupported(expr.Position(), "expression")
+}
+
+func buildSubquery(stmt Statement, pos Pos, scope *buildScope, single bool) (*shared.K3SelectQuery, error) {
+	subquery, err := buildQuery(stmt, scope.db, scope, scope.ctes)
+	if err != nil {
+		return nil, err
+	}
+	if single && len(subquery.Values) != 1 {
+		return nil, queryError(shared.InvalidSQLLogic, pos, "subquery must return only one column")
+	}
+	return subquery, nil
+}
+
+// buildCondition builds the condition of clause, which has to be a boolean
+// expression or NULL whether or not the query finds any rows.
+func buildCondition(expr Expr, scope *buildScope, clause string) (*shared.K3Expr, error) {
+	cond, err := buildExpr(expr, scope)
+	if err != nil || cond == nil {
+		return cond, err
+	}
+	if !isBoolean(cond) && cond.Kind != shared.K3ExprNull {
+		return nil, queryError(shared.InvalidSQLLogic, expr.Position(), "argument of %s must be a condition, not %s", clause, typeName(cond))
+	}
+	return cond, nil
+}
+
+func buildExprs(exprs []Expr, scope *buildScope) ([]*shared.K3Expr, error) {
+	result := make([]*shared.K3Expr, len(exprs))
+	for i, expr := range exprs {
+		built, err := buildExpr(expr, scope)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = built
+	}
+	return result, nil
+}
+
+func buildAggregate(call *FuncCall, scope *buildScope) (*shared.K3Expr, error) {
+	if !scope.aggregates {
+		return nil, queryError(shared.InvalidSQLLogic, call.Pos, "aggregate function %s is not allowed here", call.Name)
+	}
+	aggregate := &shared.K3Expr{
+		Kind:     shared.K3ExprAggregate,
+		Operator: strings.ToUpper(call.Name),
+		Distinct: call.Distinct,
+		Column:   exprString(call),
+	}
+	if call.Star {
+		if call.Name != "count" {
+			return nil, queryError(shared.InvalidSQLLogic, call.Pos, "%s(*) is not allowed", call.Name)
+		}
+	} else {
+		if len(call.Args) != 1 {
+			return nil, queryError(shared.InvalidSQLLogic, call.Pos, "%s expects exactly one argument", call.Name)
+		}
+		inner := *scope
+		inner.aggregates = false
+		arg, err := buildExpr(call.Args[0], &inner)
+		if err != nil {
+			return nil, err
+		}
+		switch call.Name {
+		case "sum", "avg":
+			if !isNumeric(arg) {
+				return nil, queryError(shared.InvalidSQLLogic, call.Pos, "%s requires a numeric argument", call.Name)
+			}
+		}
+		aggregate.Args = []*shared.K3Expr{arg}
+	}
+	aggregate.Type = aggregateType(aggregate)
+	for _, known := range scope.query.Aggregates {
+		if known.Column == aggregate.Column {
+			return known, nil
+		}
+	}
+	scope.query.Aggregates = append(scope.query.Aggregates, aggregate)
+	return aggregate, nil
+}
+
+func aggregateType(aggregate *shared.K3Expr) int {
+	switch aggregate.Operator {
+	case "COUNT":
+		return shared.K3INT
+	case "AVG":
+		if aggregate.Args[0].Type == shared.K3DECIMAL {
+			return shared.K3DECIMAL
+		}
+		return shared.K3FLOAT
+	case "SUM":
+		return max(aggregate.Args[0].Type, shared.K3INT)
+	}
+	return aggregate.Args[0].Type
+}
+
+func numberType(value string) int {
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return shared.K3INT
+	}
+	return shared.K3FLOAT
+}
+
+func isNumeric(expr *shared.K3Expr) bool {
+	return numericType(expr.Type) || expr.Kind == shared.K3ExprNull
+}
+
+func numericType(valueType int) bool {
+	return valueType == shared.K3INT || valueType == shared.K3FLOAT || valueType == shared.K3DECIMAL
+}
+
+// isBoolean reports whether expr can stand where a condition is expected.
+func isBoolean(expr *shared.K3Expr) bool {
+	return isCondition(expr) || expr.Type == shared.K3BOOLEAN
+}
+
+func isStringLiteral(expr *shared.K3Expr) bool {
+	return expr.Kind == shared.K3ExprLiteral && expr.Type == shared.K3TEXT
+}
+
+// coerceLiterals turns the string literals among exprs into the type of the
+// other expressions, as in d > '2024-01-31'.
+func coerceLiterals(pos Pos, exprs []*shared.K3Expr) error {
+	target := 0
+	for _, expr := range exprs {
+		if !isStringLiteral(expr) {
+			if valueType, ok := storage.ComparisonType(target, expr.Type); ok {
+				target = valueType
+			}
+		}
+	}
+	if target == 0 || target == shared.K3TEXT {
+		return nil
+	}
+	for _, expr := range exprs {
+		if !isStringLiteral(expr) {
+			continue
+		}
+		value, err := storage.CanonicalValue(target, expr.Value)
+		if err != nil {
+			return queryError(shared.InvalidSQLLogic, pos, "%v", err)
+		}
+		expr.Type, expr.Value = target, value
+	}
+	return nil
+}
+
+// checkComparison coerces the string literals among the operands of a
+// comparison and makes sure that the operands can be compared at all.
+func checkComparison(pos Pos, args []*shared.K3Expr) error {
+	if err := coerceLiterals(pos, args); err != nil {
+		return err
+	}
+	valueType := 0
+	for _, arg := range args {
+		next, ok := storage.ComparisonType(valueType, arg.Type)
+		if !ok {
+			return queryError(shared.InvalidSQLLogic, pos, "cannot compare %s with %s", typeName(&shared.K3Expr{Type: valueType}), typeName(arg))
+		}
+		valueType = next
+	}
+	return nil
+}
+
+func isCondition(expr *shared.K3Expr) bool {
+	switch expr.Kind {
+	case shared.K3ExprAnd, shared.K3ExprOr, shared.K3ExprNot, shared.K3ExprCompare,
+		shared.K3ExprLike, shared.K3ExprIn, shared.K3ExprBetween, shared.K3ExprIsNull, shared.K3ExprExists:
+		return true
+	}
+	return false
+}
+
+func isAggregateCall(expr Expr) bool {
+	call, ok := expr.(*FuncCall)
+	return ok && call.Over == nil && aggregateFunctions[call.Name]
+}
+
+func exprString(expr Expr) string {
+	switch e := expr.(type) {
+	case *Ident:
+		if e.Table != "" {
+			return e.Table + "." + e.Name
+		}
+		return e.Name
+	case *Literal:
+		if e.Kind == LitString {
+			return "'" + strings.ReplaceAll(e.Value, "'", "''") + "'"
+		}
+		return strings.ToLower(e.Value)
+	case *BinaryExpr:
+		left, right := exprString(e.Left), exprString(e.Right)
+		if operand, ok := e.Left.(*BinaryExpr); ok && precedence(operand.Op) < precedence(e.Op) {
+			left = "(" + left + ")"
+		}
+		if operand, ok := e.Right.(*BinaryExpr); ok && precedence(operand.Op) <= precedence(e.Op) {
+			right = "(" + right + ")"
+		}
+		return left + " " + strings.ToLower(e.Op) + " " + right
+	case *UnaryExpr:
+		if e.Op == "-" {
+			return "-" + exprString(e.Operand)
+		}
+		return strings.ToLower(e.Op) + " " + exprString(e.Operand)
+	case *FuncCall:
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = exprString(arg)
+		}
+		if e.Star {
+			args = []string{"*"}
+		}
+		prefix := ""
+		if e.Distinct {
+			prefix = "distinct "
+		}
+		text := e.Name + "(" + prefix + strings.Join(args, ", ") + ")"
+		if e.Over != nil {
+			text += " over (" + windowString(e.Over) + ")"
+		}
+		return text
+	case *CaseExpr:
+		var text strings.Builder
+		text.WriteString("case")
+		if e.Operand != nil {
+			text.WriteString(" " + exprString(e.Operand))
+		}
+		for _, when := range e.Whens {
+			text.WriteString(" when " + exprString(when.Cond) + " then " + exprString(when.Result))
+		}
+		if e.Else != nil {
+			text.WriteString(" else " + exprString(e.Else))
+		}
+		text.WriteString(" end")
+		return text.String()
+	case *CastExpr:
+		return "cast(" + exprString(e.Expr) + " as " + strings.ToLower(e.Type) + ")"
+	case *SubqueryExpr:
+		return "(select ...)"
+	case *ExistsExpr:
+		return "exists (select ...)"
+	case *LikeExpr:
+		return exprString(e.Expr) + notString(e.Not) + " like " + exprString(e.Pattern)
+	case *InExpr:
+		list := make([]string, len(e.List))
+		for i, item := range e.List {
+			list[i] = exprString(item)
+		}
+		if e.Subquery != nil {
+			list = []string{"select ..."}
+		}
+		return exprString(e.Expr) + notString(e.Not) + " in (" + strings.Join(list, ", ") + ")"
+	case *BetweenExpr:
+		return exprString(e.Expr) + notString(e.Not) + " between " + exprString(e.Low) + " and " + exprString(e.High)
+	case *IsNullExpr:
+		return exprString(e.Expr) + " is" + notString(e.Not) + " null"
+	}
+	return "?column?"
+}
+
+func notString(not bool) string {
+	if not {
+		return " not"
+	}
+	return ""
+}
+
+func precedence(op string) int {
+	switch op {
+	case "OR":
+		return 1
+	case "AND":
+		return 2
+	case "+", "-", "||":
+		return 4
+	case "*", "/", "%":
+		return 5
+	}
+	return 3
+}
@@ -0,0 +1,214 @@
+package parser
+
+import (
+	"k3SQLServer/shared"
+	"strings"
+)
+
+const (
+	paramAny = iota
+	paramText
+	paramInt
+	paramNumeric
+)
+
+type scalarFunction struct {
+	params   []int
+	optional int
+	variadic bool
+}
+
+var scalarFunctions = map[string]scalarFunction{
+	"upper":    {params: []int{paramText}},
+	"lower":    {params: []int{paramText}},
+	"trim":     {params: []int{paramText}},
+	"length":   {params: []int{paramText}},
+	"substr":   {params: []int{paramText, paramInt, paramInt}, optional: 1},
+	"replace":  {params: []int{paramText, paramText, paramText}},
+	"concat":   {params: []int{paramAny}, variadic: true},
+	"abs":      {params: []int{paramNumeric}},
+	"round":    {params: []int{paramNumeric, paramInt}, optional: 1},
+	"floor":    {params: []int{paramNumeric}},
+	"ceil":     {params: []int{paramNumeric}},
+	"coalesce": {params: []int{paramAny}, variadic: true},
+	"nullif":   {params: []int{paramAny, paramAny}},
+
+	"current_timestamp": {},
+	"now":               {},
+	"current_date":      {},
+	"gen_random_uuid":   {},
+}
+
+var columnTypes = map[string]int{
+	"INT":       shared.K3INT,
+	"FLOAT":     shared.K3FLOAT,
+	"TEXT":      shared.K3TEXT,
+	"BOOLEAN":   shared.K3BOOLEAN,
+	"DATE":      shared.K3DATE,
+	"TIMESTAMP": shared.K3TIMESTAMP,
+	"DECIMAL":   shared.K3DECIMAL,
+	"UUID":      shared.K3UUID,
+	"BLOB":      shared.K3BLOB,
+	"JSON":      shared.K3JSON,
+}
+
+func columnTypeName(columnType int) string {
+	for name, code := range columnTypes {
+		if code == columnType {
+			return name
+		}
+	}
+	return ""
+}
+
+func typeName(expr *shared.K3Expr) string {
+	if name := columnTypeName(expr.Type); name != "" {
+		return name
+	}
+	if expr.Kind == shared.K3ExprNull {
+		return "NULL"
+	}
+	return "condition"
+}
+
+func buildFunction(call *FuncCall, scope *buildScope) (*shared.K3Expr, error) {
+	function, ok := scalarFunctions[call.Name]
+	if !ok {
+		return nil, queryError(shared.InvalidSQLLogic, call.Pos, "unknown function %s", call.Name)
+	}
+	if call.Star || call.Distinct {
+		return nil, queryError(shared.InvalidSQLLogic, call.Pos, "%s is not an aggregate function", call.Name)
+	}
+	required := len(function.params) - function.optional
+	if len(call.Args) < required || (!function.variadic && len(call.Args) > len(function.params)) {
+		return nil, queryError(shared.InvalidSQLLogic, call.Pos, "wrong number of arguments to %s", call.Name)
+	}
+	args, err := buildExprs(call.Args, scope)
+	if err != nil {
+		return nil, err
+	}
+	for i, arg := range args {
+		param := function.params[min(i, len(function.params)-1)]
+		valid := !isCondition(arg)
+		switch param {
+		case paramText:
+			valid = arg.Type == shared.K3TEXT || arg.Kind == shared.K3ExprNull
+		case paramInt:
+			valid = arg.Type == shared.K3INT || arg.Kind == shared.K3ExprNull
+		case paramNumeric:
+			valid = isNumeric(arg)
+		}
+		if !valid {
+			return nil, queryError(shared.InvalidSQLLogic, call.Args[i].Position(), "function %s does not accept %s as argument %d", call.Name, typeName(arg), i+1)
+		}
+	}
+	result := &shared.K3Expr{Kind: shared.K3ExprFunction, Operator: strings.ToUpper(call.Name), Args: args}
+	switch call.Name {
+	case "length":
+		result.Type = shared.K3INT
+	case "abs", "floor", "ceil":
+		result.Type = max(args[0].Type, shared.K3INT)
+	case "round":
+		result.Type = max(args[0].Type, shared.K3INT)
+		if len(args) > 1 && result.Type != shared.K3DECIMAL {
+			result.Type = shared.K3FLOAT
+		}
+	case "current_timestamp", "now":
+		result.Type = shared.K3TIMESTAMP
+	case "current_date":
+		result.Type = shared.K3DATE
+	case "gen_random_uuid":
+		result.Type = shared.K3UUID
+	case "coalesce", "nullif":
+		if err := coerceLiterals(call.Pos, args); err != nil {
+			return nil, err
+		}
+		if result.Type, err = commonType(call.Name, call.Pos, args); err != nil {
+			return nil, err
+		}
+	default:
+		result.Type = shared.K3TEXT
+	}
+	return result, nil
+}
+
+func commonType(context string, pos Pos, exprs []*shared.K3Expr) (int, error) {
+	result := 0
+	for _, expr := range exprs {
+		switch {
+		case expr.Type == 0 || expr.Type == result:
+		case result == 0:
+			result = expr.Type
+		case isNumeric(expr) && numericType(result):
+			result = max(result, expr.Type)
+		default:
+			return 0, queryError(shared.InvalidSQLLogic, pos, "%s types %s and %s cannot be matched", context, typeName(&shared.K3Expr{Type: result}), typeName(expr))
+		}
+	}
+	return result, nil
+}
+
+func buildCase(expr *CaseExpr, scope *buildScope) (*shared.K3Expr, error) {
+	result := &shared.K3Expr{Kind: shared.K3ExprCase}
+	operand, err := buildExpr(expr.Operand, scope)
+	if err != nil {
+		return nil, err
+	}
+	var results []*shared.K3Expr
+	for _, when := range expr.Whens {
+		cond, err := buildExpr(when.Cond, scope)
+		if err != nil {
+			return nil, err
+		}
+		if operand != nil {
+			args := []*shared.K3Expr{operand, cond}
+			if err := checkComparison(when.Cond.Position(), args); err != nil {
+				return nil, err
+			}
+			cond = &shared.K3Expr{Kind: shared.K3ExprCompare, Operator: "=", Args: args}
+		} else if !isBoolean(cond) {
+			return nil, queryError(shared.InvalidSQLLogic, when.Cond.Position(), "argument of WHEN must be a condition")
+		}
+		value, err := buildExpr(when.Result, scope)
+		if err != nil {
+			return nil, err
+		}
+		result.Args = append(result.Args, cond, value)
+		results = append(results, value)
+	}
+	if expr.Else != nil {
+		value, err := buildExpr(expr.Else, scope)
+		if err != nil {
+			return nil, err
+		}
+		result.Args = append(result.Args, value)
+		results = append(results, value)
+	}
+	for _, value := range results {
+		if isCondition(value) {
+			return nil, notSupported(expr.Pos, "conditions as CASE results")
+		}
+	}
+	if err := coerceLiterals(expr.Pos, results); err != nil {
+		return nil, err
+	}
+	if result.Type, err = commonType("CASE", expr.Pos, results); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func buildCast(expr *CastExpr, scope *buildScope) (*shared.K3Expr, error) {
+	target, ok := columnTypes[expr.Type]
+	if !ok {
+		return nil, queryError(shared.InvalidSQLLogic, expr.Pos, "invalid type %s", expr.Type)
+	}
+	value, err := buildExpr(expr.Expr, scope)
+	if err != nil {
+		return nil, err
+	}
+	if isCondition(value) {
+		return nil, queryError(shared.InvalidSQLLogic, expr.Pos, "cannot cast condition to %s", expr.Type)
+	}
+	return &shared.K3Expr{Kind: shared.K3ExprCast, Type: target, Args: []*shared.K3Expr{value}}, nil
+}
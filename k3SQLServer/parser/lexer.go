@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"fmt"
+	"k3SQLServer/shared"
+	"strings"
+)
+
+const (
+	tokEOF = iota
+	tokWord
+	tokQuotedIdent
+	tokString
+	tokNumber
+	tokSymbol
+)
+
+type token struct {
+	kind  int
+	value string
+	line  int
+	col   int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokString:
+		return "'" + t.value + "'"
+	case tokQuotedIdent:
+		return `"` + t.value + `"`
+	default:
+		return "'" + t.value + "'"
+	}
+}
+
+type lexer struct {
+	src    string
+	pos    int
+	line   int
+	col    int
+	tokens []token
+}
+
+func syntaxError(line, col int, format string, args ...any) error {
+	return fmt.Errorf("%s at line %d, column %d: %s", shared.InvalidSQLSyntax, line, col, fmt.Sprintf(format, args...))
+}
+
+func tokenize(src string) ([]token, error) {
+	l := &lexer{src: src, line: 1, col: 1}
+	for {
+		if err := l.skipSpaceAndComments(); err != nil {
+			return nil, err
+		}
+		if l.pos >= len(l.src) {
+			l.tokens = append(l.tokens, token{kind: tokEOF, line: l.line, col: l.col})
+			return l.tokens, nil
+		}
+		if err := l.next(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (l *lexer) advance(n int) {
+	for i := 0; i < n && l.pos < len(l.src); i++ {
+		if l.src[l.pos] == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+		l.pos++
+	}
+}
+
+func (l *lexer) skipSpaceAndComments() error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			l.advance(1)
+		case strings.HasPrefix(l.src[l.pos:], "--"):
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.advance(1)
+			}
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end < 0 {
+				return syntaxError(l.line, l.col, "unterminated comment")
+			}
+			l.advance(end + 4)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *lexer) next() error {
+	c := l.src[l.pos]
+	line, col := l.line, l.col
+	switch {
+	case isIdentStart(c):
+		start := l.pos
+		for l.pos < len(l.src) && isIdentPart(l.src[l.pos]) {
+			l.advance(1)
+		}
+		l.tokens = append(l.tokens, token{kind: tokWord, value: l.src[start:l.pos], line: line, col: col})
+	case isDigit(c) || (c == '.' && l.pos+1 < len(l.src) && isDigit(l.src[l.pos+1])):
+		l.tokens = append(l.tokens, token{kind: tokNumber, value: l.readNumber(), line: line, col: col})
+	case c == '\'':
+		value, err := l.readQuoted('\'')
+		if err != nil {
+			return err
+		}
+		l.tokens = append(l.tokens, token{kind: tokString, value: value, line: line, col: col})
+	case c == '"' || c == '`':
+		value, err := l.readQuoted(c)
+		if err != nil {
+			return err
+		}
+		if value == "" {
+			return syntaxError(line, col, "empty quoted identifier")
+		}
+		l.tokens = append(l.tokens, token{kind: tokQuotedIdent, value: value, line: line, col: col})
+	default:
+		for _, op := range []string{"<>", "!=", "<=", ">=", "||"} {
+			if strings.HasPrefix(l.src[l.pos:], op) {
+				l.advance(len(op))
+				l.tokens = append(l.tokens, token{kind: tokSymbol, value: op, line: line, col: col})
+				return nil
+			}
+		}
+		if strings.IndexByte("=<>+-*/%(),.;", c) < 0 {
+			return syntaxError(line, col, "unexpected character %q", c)
+		}
+		l.advance(1)
+		l.tokens = append(l.tokens, token{kind: tokSymbol, value: string(c), line: line, col: col})
+	}
+	return nil
+}
+
+func (l *lexer) readNumber() string {
+	start := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.advance(1)
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		l.advance(1)
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.advance(1)
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		end := l.pos + 1
+		if end < len(l.src) && (l.src[end] == '+' || l.src[end] == '-') {
+			end++
+		}
+		if end < len(l.src) && isDigit(l.src[end]) {
+			l.advance(end - l.pos)
+			for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+				l.advance(1)
+			}
+		}
+	}
+	return l.src[start:l.pos]
+}
+
+func (l *lexer) readQuoted(quote byte) (string, error) {
+	line, col := l.line, l.col
+	l.advance(1)
+	var sb strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == quote {
+			if l.pos+1 < len(l.src) && l.src[l.pos+1] == quote {
+				sb.WriteByte(quote)
+				l.advance(2)
+				continue
+			}
+			l.advance(1)
+			return sb.String(), nil
+		}
+		sb.WriteByte(c)
+		l.advance(1)
+	}
+	return "", syntaxError(line, col, "unterminated quoted string")
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '$'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []token
+	}{
+		{"42", []token{{kind: tokNumber, value: "42", line: 1, col: 1}}},
+		{"3.14 .5 1e10 2.5E-3", []token{
+			{kind: tokNumber, value: "3.14", line: 1, col: 1},
+			{kind: tokNumber, value: ".5", line: 1, col: 6},
+			{kind: tokNumber, value: "1e10", line: 1, col: 9},
+			{kind: tokNumber, value: "2.5E-3", line: 1, col: 14},
+		}},
+		{"-7", []token{
+			{kind: tokSymbol, value: "-", line: 1, col: 1},
+			{kind: tokNumber, value: "7", line: 1, col: 2},
+		}},
+		{"'it''s'", []token{{kind: tokString, value: "it's", line: 1, col: 1}}},
+		{"''", []token{{kind: tokString, value: "", line: 1, col: 1}}},
+		{"'a -- b'", []token{{kind: tokString, value: "a -- b", line: 1, col: 1}}},
+		{`"Order" ` + "`my col`", []token{
+			{kind: tokQuotedIdent, value: "Order", line: 1, col: 1},
+			{kind: tokQuotedIdent, value: "my col", line: 1, col: 9},
+		}},
+		{`"a""b"`, []token{{kind: tokQuotedIdent, value: `a"b`, line: 1, col: 1}}},
+		{"a<>b != c <= d >= e || f", []token{
+			{kind: tokWord, value: "a", line: 1, col: 1},
+			{kind: tokSymbol, value: "<>", line: 1, col: 2},
+			{kind: tokWord, value: "b", line: 1, col: 4},
+			{kind: tokSymbol, value: "!=", line: 1, col: 6},
+			{kind: tokWord, value: "c", line: 1, col: 9},
+			{kind: tokSymbol, value: "<=", line: 1, col: 11},
+			{kind: tokWord, value: "d", line: 1, col: 14},
+			{kind: tokSymbol, value: ">=", line: 1, col: 16},
+			{kind: tokWord, value: "e", line: 1, col: 19},
+			{kind: tokSymbol, value: "||", line: 1, col: 21},
+			{kind: tokWord, value: "f", line: 1, col: 24},
+		}},
+		{"SELECT -- comment\n  x /* block\n */ FROM t", []token{
+			{kind: tokWord, value: "SELECT", line: 1, col: 1},
+			{kind: tokWord, value: "x", line: 2, col: 3},
+			{kind: tokWord, value: "FROM", line: 3, col: 5},
+			{kind: tokWord, value: "t", line: 3, col: 10},
+		}},
+		{"t.col_1$", []token{
+			{kind: tokWord, value: "t", line: 1, col: 1},
+			{kind: tokSymbol, value: ".", line: 1, col: 2},
+			{kind: tokWord, value: "col_1$", line: 1, col: 3},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := tokenize(tt.src)
+		if err != nil {
+			t.Errorf("tokenize(%q): %v", tt.src, err)
+			continue
+		}
+		// Every token list ends with EOF; compare everything before it.
+		if last := got[len(got)-1]; last.kind != tokEOF {
+			t.Errorf("tokenize(%q) does not end with EOF: %v", tt.src, got)
+			continue
+		}
+		if got = got[:len(got)-1]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %+v, want %+v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"'abc", "line 1, column 1: unterminated quoted string"},
+		{"SELECT\n  'abc", "line 2, column 3: unterminated quoted string"},
+		{`x = "abc`, "line 1, column 5: unterminated quoted string"},
+		{`SELECT ""`, "line 1, column 8: empty quoted identifier"},
+		{"a ? b", "line 1, column 3: unexpected character '?'"},
+		{"a\n\n  @", "line 3, column 3: unexpected character '@'"},
+		{"SELECT n FROM big /* unterminated", "line 1, column 19: unterminated comment"},
+		{"SELECT 1\n  /* a */ /* b", "line 2, column 11: unterminated comment"},
+	}
+	for _, tt := range tests {
+		_, err := tokenize(tt.src)
+		if err == nil || !strings.HasSuffix(err.Error(), tt.want) {
+			t.Errorf("tokenize(%q) error = %v, want suffix %q", tt.src, err, tt.want)
+		}
+	}
+}
@@ -1,526 +1,1424 @@
 package parser
 
 import (
-	"errors"
-	"fmt"
 	"k3SQLServer/shared"
-	"k3SQLServer/storage"
-	"os"
+	"strconv"
 	"strings"
-	"sync"
-	"time"
 )
 
-func ParseUserQuery(queryStr, db string) (*shared.K3UserQuery, error) {
-	parts := strings.Fields(queryStr)
-	query := new(shared.K3UserQuery)
-	query.Database = db
-	newFlag := false
-	delFlag := false
-	pwdFlag := false
-	for _, part := range parts {
-		if strings.EqualFold(part, "new") {
-			newFlag = true
-			continue
+var reservedWords = map[string]bool{
+	"ALL": true, "AND": true, "AS": true, "ASC": true, "BETWEEN": true, "BY": true,
+	"CASE": true, "CHECK": true, "CONSTRAINT": true, "CROSS": true, "DEFAULT": true, "DESC": true, "DISTINCT": true, "ELSE": true,
+	"END": true, "EXCEPT": true, "EXISTS": true, "FALSE": true, "FOREIGN": true, "FROM": true,
+	"FULL": true, "GROUP": true, "HAVING": true, "IN": true, "INNER": true,
+	"INTERSECT": true, "INTO": true, "IS": true, "JOIN": true, "LEFT": true,
+	"LIKE": true, "LIMIT": true, "NOT": true, "NULL": true, "OFFSET": true,
+	"ON": true, "OR": true, "ORDER": true, "OUTER": true, "PRIMARY": true,
+	"REFERENCES": true, "RETURNING": true, "RIGHT": true, "SELECT": true, "SET": true, "THEN": true, "TRUE": true, "UNION": true,
+	"UNIQUE": true, "VALUES": true, "WHEN": true, "WHERE": true, "WITH": true,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func Parse(queryStr string) (Statement, error) {
+	tokens, err := tokenize(queryStr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	stmt, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptSymbol(";")
+	if p.peek().kind != tokEOF {
+		return nil, p.unexpected("end of query")
+	}
+	return stmt, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(n int) token {
+	if p.pos+n < len(p.tokens) {
+		return p.tokens[p.pos+n]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) position() Pos {
+	tok := p.peek()
+	return Pos{Line: tok.line, Col: tok.col}
+}
+
+func (p *parser) unexpected(expected string) error {
+	tok := p.peek()
+	return syntaxError(tok.line, tok.col, "expected %s, found %s", expected, tok)
+}
+
+func (p *parser) isKeyword(tok token, keyword string) bool {
+	return tok.kind == tokWord && strings.EqualFold(tok.value, keyword)
+}
+
+func (p *parser) peekKeyword(keywords ...string) bool {
+	for i, keyword := range keywords {
+		if !p.isKeyword(p.peekAt(i), keyword) {
+			return false
 		}
-		if strings.EqualFold(part, "delete") {
-			delFlag = true
-			continue
+	}
+	return true
+}
+
+func (p *parser) acceptKeyword(keywords ...string) bool {
+	if p.peekKeyword(keywords...) {
+		p.pos += len(keywords)
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(keywords ...string) error {
+	for _, keyword := range keywords {
+		if !p.acceptKeyword(keyword) {
+			return p.unexpected(keyword)
 		}
-		if newFlag {
-			query.Username = part
-			query.Action = shared.K3CREATE
-			newFlag = false
-			pwdFlag = true
-			continue
+	}
+	return nil
+}
+
+func (p *parser) peekSymbol(symbol string) bool {
+	tok := p.peek()
+	return tok.kind == tokSymbol && tok.value == symbol
+}
+
+func (p *parser) acceptSymbol(symbol string) bool {
+	if p.peekSymbol(symbol) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectSymbol(symbol string) error {
+	if !p.acceptSymbol(symbol) {
+		return p.unexpected("'" + symbol + "'")
+	}
+	return nil
+}
+
+func (p *parser) isIdentifier(tok token) bool {
+	if tok.kind == tokQuotedIdent {
+		return true
+	}
+	return tok.kind == tokWord && !reservedWords[strings.ToUpper(tok.value)]
+}
+
+func identifierName(tok token) string {
+	if tok.kind == tokQuotedIdent {
+		return tok.value
+	}
+	return strings.ToLower(tok.value)
+}
+
+func (p *parser) parseIdentifier(what string) (string, error) {
+	tok := p.peek()
+	if !p.isIdentifier(tok) {
+		return "", p.unexpected(what)
+	}
+	p.advance()
+	return identifierName(tok), nil
+}
+
+func (p *parser) parseStatement() (Statement, error) {
+	tok := p.peek()
+	switch {
+	case p.isKeyword(tok, "SELECT"), p.isKeyword(tok, "WITH"), tok.kind == tokSymbol && tok.value == "(":
+		return p.parseQuery()
+	case p.isKeyword(tok, "INSERT"):
+		return p.parseInsert()
+	case p.isKeyword(tok, "UPDATE"):
+		return p.parseUpdate()
+	case p.isKeyword(tok, "DELETE"):
+		return p.parseDelete()
+	case p.isKeyword(tok, "CREATE"):
+		return p.parseCreate()
+	case p.isKeyword(tok, "DROP"):
+		return p.parseDrop()
+	case p.isKeyword(tok, "ALTER"):
+		return p.parseAlter()
+	case p.isKeyword(tok, "USER"):
+		return p.parseUser()
+	}
+	return nil, p.unexpected("statement")
+}
+
+func (p *parser) parseSelect() (*SelectStmt, error) {
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	stmt := new(SelectStmt)
+	if p.acceptKeyword("DISTINCT") {
+		stmt.Distinct = true
+	} else {
+		p.acceptKeyword("ALL")
+	}
+	var err error
+	if stmt.Columns, err = p.parseSelectItems(); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	from, err := p.parseTableRef(true)
+	if err != nil {
+		return nil, err
+	}
+	stmt.From = from
+	for {
+		join, err := p.parseJoin()
+		if err != nil {
+			return nil, err
 		}
-		if delFlag {
-			query.Username = part
-			query.Action = shared.K3DELETE
-			delFlag = false
-			pwdFlag = true
-			continue
+		if join == nil {
+			break
 		}
-		if pwdFlag {
-			query.Password = part
-			pwdFlag = false
-			continue
+		stmt.Joins = append(stmt.Joins, join)
+	}
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if stmt.GroupBy, err = p.parseExprList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("HAVING") {
+		if stmt.Having, err = p.parseExpr(); err != nil {
+			return nil, err
 		}
 	}
-	return query, nil
+	return stmt, nil
 }
 
-func ParseCreateQuery(queryStr, db string) (*shared.K3CreateQuery, error) {
-	parts := strings.Fields(queryStr)
-	query := new(shared.K3CreateQuery)
-	tableFlag := false
-	ifFlag := false
-	notFlag := false
-	databaseFlag := false
-	for _, part := range parts {
-		if strings.EqualFold(part, "database") {
-			databaseFlag = true
-			continue
+func (p *parser) parseIdentifierList() ([]string, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	var names []string
+	for {
+		name, err := p.parseIdentifier("column name")
+		if err != nil {
+			return nil, err
 		}
-		if strings.EqualFold(part, "table") {
-			tableFlag = true
-			continue
-		} else if strings.EqualFold(part, "if") {
-			ifFlag = true
-			continue
-		} else if strings.EqualFold(part, "not") {
-			if !ifFlag {
-				return nil, errors.New(shared.InvalidSQLSyntax)
-			}
-			notFlag = true
-			continue
-		} else if strings.EqualFold(part, "exists") {
-			if !ifFlag {
-				return nil, errors.New(shared.InvalidSQLSyntax)
-			}
-			if !notFlag {
-				return nil, errors.New(shared.InvalidSQLLogic)
+		names = append(names, name)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func (p *parser) parseWith() (*WithStmt, error) {
+	if err := p.expectKeyword("WITH"); err != nil {
+		return nil, err
+	}
+	stmt := &WithStmt{Recursive: p.acceptKeyword("RECURSIVE")}
+	for {
+		cte := &CommonTableExpr{Pos: p.position()}
+		var err error
+		if cte.Name, err = p.parseIdentifier("query name"); err != nil {
+			return nil, err
+		}
+		if p.peekSymbol("(") {
+			if cte.Columns, err = p.parseIdentifierList(); err != nil {
+				return nil, err
 			}
-			continue
 		}
-		if tableFlag {
-			table := shared.K3Table{Name: part, Database: db, Mu: new(sync.RWMutex), LU: time.Now()}
-			query.Table = &table
-			tableFlag = false
+		if err := p.expectKeyword("AS"); err != nil {
+			return nil, err
 		}
-		if databaseFlag {
-			table := shared.K3Table{Name: "", Database: part, Mu: nil, LU: time.Now()}
-			query.Table = &table
-			return query, nil
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
 		}
-	}
-	fieldsStr := ""
-	flag := false
-	for i := 0; i < len(queryStr); i++ {
-		if queryStr[i] == '(' {
-			flag = true
-			continue
+		if cte.Query, err = p.parseQuery(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
 		}
-		if queryStr[i] == ')' {
+		stmt.CTEs = append(stmt.CTEs, cte)
+		if !p.acceptSymbol(",") {
 			break
 		}
-		if flag {
-			fieldsStr += string(queryStr[i])
-		}
-	}
-	fieldsPartsTypes := strings.Split(fieldsStr, ",")
-	fields := make(map[string]int, len(fieldsPartsTypes))
-	queryFields := make([]string, len(fieldsPartsTypes))
-	for i := 0; i < len(fieldsPartsTypes); i++ {
-		fieldsParts := strings.Fields(fieldsPartsTypes[i])
-		if len(fieldsParts) != 2 {
-			return nil, errors.New(shared.InvalidSQLSyntax)
-		}
-		switch strings.ToUpper(fieldsParts[1]) {
-		case "INT":
-			fields[fieldsParts[0]] = shared.K3INT
-		case "FLOAT":
-			fields[fieldsParts[0]] = shared.K3FLOAT
-		case "TEXT":
-			fields[fieldsParts[0]] = shared.K3TEXT
-		default:
-			return nil, errors.New(fmt.Sprintf("Invalid type: %s", fieldsParts[i+1]))
-		}
-		queryFields[i] = fieldsParts[0]
-	}
-	query.Fields = fields
-	query.Table.Fields = queryFields
-	return query, nil
-}
-
-func ParseInsertQuery(queryStr, db string) (*shared.K3InsertQuery, error) {
-	parts := strings.Fields(queryStr)
-	query := new(shared.K3InsertQuery)
-	intoFlag := false
-	ValuesFlag := false
-	fieldsFlag := false
-	ValuesStr := ""
-	fieldsStr := ""
-	for _, part := range parts {
-		if strings.EqualFold(part, "into") {
-			intoFlag = true
-			continue
-		} else if strings.EqualFold(part, "values") {
-			fieldsFlag = false
-			ValuesFlag = true
-			continue
+	}
+	var err error
+	if stmt.Body, err = p.parseQuery(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) peekQuery() bool {
+	return p.peekKeyword("SELECT") || p.peekKeyword("WITH")
+}
+
+func (p *parser) parseQuery() (Statement, error) {
+	if p.peekKeyword("WITH") {
+		return p.parseWith()
+	}
+	stmt, err := p.parseQueryTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("UNION") || p.peekKeyword("EXCEPT") {
+		setOp := &SetOpStmt{Pos: p.position(), Op: strings.ToUpper(p.advance().value), Left: stmt}
+		if setOp.All = p.acceptKeyword("ALL"); !setOp.All {
+			p.acceptKeyword("DISTINCT")
 		}
-		if intoFlag {
-			table, ok := shared.K3Tables[db+"."+part]
-			if !ok {
-				filePath := shared.K3DataPath + db + "/" + part + shared.Extension
-				_, err := os.Open(filePath)
-				if err == nil {
-					tableFile := &shared.K3Table{Name: part, Database: db, Mu: new(sync.RWMutex), LU: time.Now()}
-					err = storage.AddFieldsTableFile(tableFile)
-					if err == nil {
-						shared.K3Tables[tableFile.Database+"."+tableFile.Name] = tableFile
-						table = tableFile
-					} else {
-						return nil, err
-					}
-				} else {
-					return nil, errors.New(shared.TableNotExists)
-				}
-			}
-			table.LU = time.Now()
-			query.Table = table
-			intoFlag = false
-			fieldsFlag = true
-			continue
+		if setOp.Right, err = p.parseQueryTerm(); err != nil {
+			return nil, err
 		}
-		if ValuesFlag {
-			ValuesStr += part
+		stmt = setOp
+	}
+	tail := &SetOpStmt{}
+	if err := p.parseQueryTail(tail); err != nil {
+		return nil, err
+	}
+	if tail.OrderBy == nil && tail.Limit == nil && tail.Offset == nil {
+		return stmt, nil
+	}
+	switch stmt := stmt.(type) {
+	case *SetOpStmt:
+		stmt.OrderBy, stmt.Limit, stmt.Offset = tail.OrderBy, tail.Limit, tail.Offset
+	case *SelectStmt:
+		if stmt.OrderBy != nil || stmt.Limit != nil || stmt.Offset != nil {
+			return nil, p.unexpected("end of query")
 		}
-		if fieldsFlag {
-			fieldsStr += part
+		stmt.OrderBy, stmt.Limit, stmt.Offset = tail.OrderBy, tail.Limit, tail.Offset
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseQueryTerm() (Statement, error) {
+	stmt, err := p.parseQueryPrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("INTERSECT") {
+		setOp := &SetOpStmt{Pos: p.position(), Op: strings.ToUpper(p.advance().value), Left: stmt}
+		if setOp.All = p.acceptKeyword("ALL"); !setOp.All {
+			p.acceptKeyword("DISTINCT")
 		}
+		if setOp.Right, err = p.parseQueryPrimary(); err != nil {
+			return nil, err
+		}
+		stmt = setOp
 	}
-	fieldsStr = strings.TrimSuffix(fieldsStr, ")")
-	fieldsStr = strings.TrimPrefix(fieldsStr, "(")
-	fieldsSlice := strings.Split(fieldsStr, ",")
-	ValuesSlice := make([]string, strings.Count(ValuesStr, "("))
-	str := ""
-	cnt := 0
-	flag := false
-	for _, el := range ValuesStr {
-		if el == '(' {
-			flag = true
-			continue
+	return stmt, nil
+}
+
+func (p *parser) parseQueryPrimary() (Statement, error) {
+	if !p.acceptSymbol("(") {
+		return p.parseSelect()
+	}
+	stmt, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseQueryTail(stmt *SetOpStmt) error {
+	var err error
+	if p.acceptKeyword("ORDER") {
+		if stmt.OrderBy, err = p.parseOrderBy(); err != nil {
+			return err
 		}
-		if el == ')' {
-			ValuesSlice[cnt] = str
-			str = ""
-			cnt++
-			flag = false
-			continue
+	}
+	if p.acceptKeyword("LIMIT") {
+		if stmt.Limit, err = p.parseExpr(); err != nil {
+			return err
+		}
+		if p.acceptSymbol(",") {
+			stmt.Offset = stmt.Limit
+			if stmt.Limit, err = p.parseExpr(); err != nil {
+				return err
+			}
 		}
-		if flag {
-			str += string(el)
+	}
+	if stmt.Offset == nil && p.acceptKeyword("OFFSET") {
+		if stmt.Offset, err = p.parseExpr(); err != nil {
+			return err
 		}
 	}
-	tmpMap := make([]map[string]string, len(ValuesSlice))
-	cnt = 0
-	for _, value := range ValuesSlice {
-		tmpMap[cnt] = make(map[string]string, len(fieldsSlice))
-		valueParts := strings.Split(value, ",")
-		if len(fieldsSlice) != len(valueParts) {
-			return nil, errors.New(shared.InvalidSQLSyntax)
+	return nil
+}
+
+func (p *parser) parseOrderBy() ([]*OrderItem, error) {
+	if err := p.expectKeyword("BY"); err != nil {
+		return nil, err
+	}
+	var items []*OrderItem
+	for {
+		item := &OrderItem{}
+		var err error
+		if item.Expr, err = p.parseExpr(); err != nil {
+			return nil, err
 		}
-		for i := 0; i < len(fieldsSlice); i++ {
-			tmpMap[cnt][fieldsSlice[i]] = valueParts[i]
+		if p.acceptKeyword("DESC") {
+			item.Desc = true
+		} else {
+			p.acceptKeyword("ASC")
+		}
+		if p.acceptKeyword("NULLS", "FIRST") {
+			item.Nulls = "FIRST"
+		} else if p.acceptKeyword("NULLS", "LAST") {
+			item.Nulls = "LAST"
+		}
+		items = append(items, item)
+		if !p.acceptSymbol(",") {
+			return items, nil
 		}
-		cnt++
 	}
-	query.Values = tmpMap
-	return query, nil
 }
 
-func ParseUpdateQuery(queryStr, db string) (*shared.K3UpdateQuery, error) {
-	parts := strings.Fields(queryStr)
-	query := &shared.K3UpdateQuery{
-		SetValues:  make(map[string]string),
-		Conditions: make([]shared.K3Condition, 0),
+func (p *parser) parseSelectItems() ([]*SelectItem, error) {
+	var items []*SelectItem
+	for {
+		item, err := p.parseSelectItem()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if !p.acceptSymbol(",") {
+			return items, nil
+		}
 	}
+}
 
-	updateFlag := true
-	setFlag := false
-	whereFlag := false
-	var whereParts []string
-	var setParts []string
+func (p *parser) parseReturning() ([]*SelectItem, error) {
+	if !p.acceptKeyword("RETURNING") {
+		return nil, nil
+	}
+	return p.parseSelectItems()
+}
 
-	for i := 0; i < len(parts); i++ {
-		part := strings.TrimSuffix(parts[i], ",")
-		upperPart := strings.ToUpper(part)
+func (p *parser) parseSelectItem() (*SelectItem, error) {
+	item := &SelectItem{Pos: p.position()}
+	if p.acceptSymbol("*") {
+		item.Star = true
+		return item, nil
+	}
+	if p.isIdentifier(p.peek()) && p.peekAt(1).kind == tokSymbol && p.peekAt(1).value == "." &&
+		p.peekAt(2).kind == tokSymbol && p.peekAt(2).value == "*" {
+		item.Star = true
+		item.StarTable = identifierName(p.advance())
+		p.pos += 2
+		return item, nil
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	item.Expr = expr
+	if p.acceptKeyword("AS") {
+		if item.Alias, err = p.parseIdentifier("column alias"); err != nil {
+			return nil, err
+		}
+	} else if p.isIdentifier(p.peek()) {
+		item.Alias = identifierName(p.advance())
+	}
+	return item, nil
+}
 
-		switch upperPart {
-		case "UPDATE":
-			continue
-		case "SET":
-			updateFlag = false
-			setFlag = true
-			whereFlag = false
-		case "WHERE":
-			setFlag = false
-			whereFlag = true
-			updateFlag = false
-		default:
-			if updateFlag {
-				table, ok := shared.K3Tables[db+"."+part]
-				if !ok {
-					filePath := shared.K3DataPath + db + "/" + part + shared.Extension
-					_, err := os.Open(filePath)
-					if err == nil {
-						tableFile := &shared.K3Table{Name: part, Database: db, Mu: new(sync.RWMutex), LU: time.Now()}
-						err = storage.AddFieldsTableFile(tableFile)
-						if err == nil {
-							shared.K3Tables[tableFile.Database+"."+tableFile.Name] = tableFile
-							table = tableFile
-						} else {
-							return nil, err
-						}
-					} else {
-						return nil, errors.New(shared.TableNotExists)
-					}
-				}
-				table.LU = time.Now()
-				query.Table = table
-			} else if setFlag {
-				setParts = append(setParts, part)
-			} else if whereFlag {
-				whereParts = append(whereParts, part)
-			}
+func (p *parser) parseTableRef(allowAlias bool) (*TableRef, error) {
+	ref := &TableRef{Pos: p.position()}
+	name, err := p.parseIdentifier("table name")
+	if err != nil {
+		return nil, err
+	}
+	ref.Name = name
+	if !allowAlias {
+		return ref, nil
+	}
+	if p.acceptKeyword("AS") {
+		if ref.Alias, err = p.parseIdentifier("table alias"); err != nil {
+			return nil, err
 		}
+	} else if p.isIdentifier(p.peek()) {
+		ref.Alias = identifierName(p.advance())
 	}
-	setClause := strings.Join(setParts, " ")
-	setPairs := strings.Split(setClause, ",")
-	for _, pair := range setPairs {
-		pair = strings.TrimSpace(pair)
-		if eqIdx := strings.Index(pair, "="); eqIdx > 0 {
-			column := strings.TrimSpace(pair[:eqIdx])
-			value := strings.TrimSpace(pair[eqIdx+1:])
-			if len(value) > 0 && (value[0] == '\'' || value[0] == '"') {
-				value = strings.Trim(value, "'\"")
-			}
-			query.SetValues[column] = value
+	return ref, nil
+}
+
+func (p *parser) parseJoin() (*JoinClause, error) {
+	join := &JoinClause{Pos: p.position()}
+	switch {
+	case p.acceptKeyword("JOIN"), p.acceptKeyword("INNER", "JOIN"):
+		join.Type = "INNER"
+	case p.acceptKeyword("CROSS", "JOIN"), p.acceptSymbol(","):
+		join.Type = "CROSS"
+	case p.peekKeyword("LEFT"), p.peekKeyword("RIGHT"), p.peekKeyword("FULL"):
+		join.Type = strings.ToUpper(p.advance().value)
+		p.acceptKeyword("OUTER")
+		if err := p.expectKeyword("JOIN"); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+	table, err := p.parseTableRef(true)
+	if err != nil {
+		return nil, err
+	}
+	join.Table = table
+	if join.Type != "CROSS" {
+		if err := p.expectKeyword("ON"); err != nil {
+			return nil, err
+		}
+		if join.On, err = p.parseExpr(); err != nil {
+			return nil, err
 		}
 	}
-	if len(whereParts) > 0 {
-		conditions, err := parseWhereClause(strings.Join(whereParts, " "))
+	return join, nil
+}
+
+func (p *parser) parseInsert() (*InsertStmt, error) {
+	if err := p.expectKeyword("INSERT"); err != nil {
+		return nil, err
+	}
+	stmt := new(InsertStmt)
+	stmt.Ignore = p.acceptKeyword("IGNORE")
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	table, err := p.parseTableRef(false)
+	if err != nil {
+		return nil, err
+	}
+	stmt.Table = table
+	if !p.peekQuery() {
+		if stmt.Columns, err = p.parseIdentifierList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peekQuery() {
+		if stmt.Select, err = p.parseQuery(); err != nil {
+			return nil, err
+		}
+	} else if stmt.Rows, err = p.parseValues(); err != nil {
+		return nil, err
+	}
+	if p.peekKeyword("ON") {
+		if stmt.Conflict, err = p.parseConflict(); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.Returning, err = p.parseReturning(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseValues() ([][]Expr, error) {
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	var rows [][]Expr
+	for {
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		row, err := p.parseExprList()
 		if err != nil {
 			return nil, err
 		}
-		query.Conditions = conditions
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+		if !p.acceptSymbol(",") {
+			return rows, nil
+		}
 	}
+}
 
-	return query, nil
+func (p *parser) parseUpdate() (*UpdateStmt, error) {
+	if err := p.expectKeyword("UPDATE"); err != nil {
+		return nil, err
+	}
+	stmt := new(UpdateStmt)
+	table, err := p.parseTableRef(false)
+	if err != nil {
+		return nil, err
+	}
+	stmt.Table = table
+	if stmt.Set, err = p.parseAssignments(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.Returning, err = p.parseReturning(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
 }
 
-func ParseDropQuery(queryStr, db string) (*shared.K3Table, error) {
-	parts := strings.Fields(queryStr)
-	tableFlag := false
-	ifFlag := false
-	for _, part := range parts {
-		if strings.EqualFold(part, "drop") {
-			continue
-		} else if strings.EqualFold(part, "table") {
-			tableFlag = true
-			continue
-		} else if strings.EqualFold(part, "if") {
-			if tableFlag {
-				ifFlag = true
-				tableFlag = false
-			} else {
-				return nil, errors.New(shared.InvalidSQLSyntax)
+func (p *parser) parseAssignments() ([]*Assignment, error) {
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	var assignments []*Assignment
+	for {
+		assignment := &Assignment{Pos: p.position()}
+		var err error
+		if assignment.Column, err = p.parseIdentifier("column name"); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		if assignment.Value, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, assignment)
+		if !p.acceptSymbol(",") {
+			return assignments, nil
+		}
+	}
+}
+
+func (p *parser) parseConflict() (*ConflictClause, error) {
+	conflict := &ConflictClause{Pos: p.position()}
+	if err := p.expectKeyword("ON", "CONFLICT"); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.peekSymbol("(") {
+		if conflict.Columns, err = p.parseIdentifierList(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("DO"); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("NOTHING") {
+		return conflict, nil
+	}
+	if err := p.expectKeyword("UPDATE"); err != nil {
+		return nil, err
+	}
+	if conflict.Set, err = p.parseAssignments(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("WHERE") {
+		if conflict.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return conflict, nil
+}
+
+func (p *parser) parseDelete() (*DeleteStmt, error) {
+	if err := p.expectKeyword("DELETE", "FROM"); err != nil {
+		return nil, err
+	}
+	stmt := new(DeleteStmt)
+	table, err := p.parseTableRef(false)
+	if err != nil {
+		return nil, err
+	}
+	stmt.Table = table
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.Returning, err = p.parseReturning(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseCreate() (Statement, error) {
+	if err := p.expectKeyword("CREATE"); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("DATABASE") || p.acceptKeyword("SCHEMA") {
+		stmt := new(CreateDatabaseStmt)
+		stmt.IfNotExists = p.acceptKeyword("IF", "NOT", "EXISTS")
+		name, err := p.parseIdentifier("database name")
+		if err != nil {
+			return nil, err
+		}
+		stmt.Name = name
+		return stmt, nil
+	}
+	stmt := new(CreateTableStmt)
+	stmt.Temporary = p.acceptKeyword("TEMPORARY")
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	stmt.IfNotExists = p.acceptKeyword("IF", "NOT", "EXISTS")
+	table, err := p.parseTableRef(false)
+	if err != nil {
+		return nil, err
+	}
+	stmt.Table = table
+	if p.acceptKeyword("AS") {
+		if stmt.AsSelect, err = p.parseQuery(); err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	}
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		if p.peekKeyword("CONSTRAINT") || p.peekKeyword("PRIMARY") || p.peekKeyword("UNIQUE") ||
+			p.peekKeyword("CHECK") || p.peekKeyword("FOREIGN") {
+			constraint, err := p.parseConstraint(nil)
+			if err != nil {
+				return nil, err
 			}
-			continue
-		} else if strings.EqualFold(part, "not") {
-			if ifFlag {
-				return nil, errors.New(shared.InvalidSQLLogic)
+			stmt.Constraints = append(stmt.Constraints, constraint)
+			if !p.acceptSymbol(",") {
+				break
 			}
 			continue
-		} else if strings.EqualFold(part, "exists") {
-			if ifFlag {
-				ifFlag = false
-				tableFlag = true
-			}
+		}
+		column := &ColumnDef{Pos: p.position()}
+		if column.Name, err = p.parseIdentifier("column name"); err != nil {
+			return nil, err
+		}
+		if column.Type, err = p.parseTypeName("column type"); err != nil {
+			return nil, err
+		}
+		stmt.Columns = append(stmt.Columns, column)
+		constraints, err := p.parseColumnConstraints(column)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Constraints = append(stmt.Constraints, constraints...)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseColumnConstraints(column *ColumnDef) ([]*ConstraintDef, error) {
+	var constraints []*ConstraintDef
+	for {
+		if p.acceptKeyword("NULL") {
 			continue
 		}
-		if tableFlag {
-			table, ok := shared.K3Tables[db+"."+part]
-			if !ok {
-				filePath := shared.K3DataPath + db + "/" + part + shared.Extension
-				_, err := os.Open(filePath)
-				if err == nil {
-					tableFile := &shared.K3Table{Name: part, Database: db, Mu: new(sync.RWMutex), LU: time.Now()}
-					err = storage.AddFieldsTableFile(tableFile)
-					if err == nil {
-						shared.K3Tables[tableFile.Database+"."+tableFile.Name] = tableFile
-						table = tableFile
-					} else {
-						return nil, err
-					}
-				} else {
-					return nil, errors.New(shared.TableNotExists)
-				}
-			}
-			return table, nil
-		}
-	}
-	return nil, errors.New(shared.InvalidSQLSyntax)
-}
-
-func ParseSelectQuery(queryStr, db string) (*shared.K3SelectQuery, error) {
-	parts := strings.Fields(queryStr)
-	query := new(shared.K3SelectQuery)
-	query.Values = make([]string, 0)
-	query.Conditions = make([]shared.K3Condition, 0)
-
-	selectCond := false
-	fromCond := false
-	whereCond := false
-	var whereParts []string
-
-	for _, part := range parts {
-		part = strings.TrimSuffix(part, ",")
-		upperPart := strings.ToUpper(part)
-
-		switch upperPart {
-		case "SELECT":
-			selectCond = true
-			fromCond = false
-			whereCond = false
-		case "FROM":
-			fromCond = true
-			selectCond = false
-			whereCond = false
-		case "WHERE":
-			whereCond = true
-			fromCond = false
-			selectCond = false
+		if !p.peekKeyword("CONSTRAINT") && !p.peekKeyword("PRIMARY") && !p.peekKeyword("UNIQUE") &&
+			!p.peekKeyword("NOT") && !p.peekKeyword("DEFAULT") && !p.peekKeyword("CHECK") && !p.peekKeyword("REFERENCES") {
+			return constraints, nil
+		}
+		constraint, err := p.parseConstraint(column)
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, constraint)
+	}
+}
+
+func (p *parser) parseConstraint(column *ColumnDef) (*ConstraintDef, error) {
+	constraint := &ConstraintDef{Pos: p.position()}
+	if p.acceptKeyword("CONSTRAINT") {
+		name, err := p.parseIdentifier("constraint name")
+		if err != nil {
+			return nil, err
+		}
+		constraint.Name = name
+	}
+	var err error
+	switch {
+	case p.acceptKeyword("PRIMARY", "KEY"):
+		constraint.Kind = "PRIMARY KEY"
+	case p.acceptKeyword("UNIQUE"):
+		constraint.Kind = "UNIQUE"
+	case p.acceptKeyword("CHECK"):
+		constraint.Kind = "CHECK"
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		if constraint.Expr, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return constraint, nil
+	case column != nil && p.peekKeyword("REFERENCES"):
+		constraint.Kind = "FOREIGN KEY"
+		constraint.Columns = []string{column.Name}
+		return constraint, p.parseReferences(constraint)
+	case column == nil && p.acceptKeyword("FOREIGN", "KEY"):
+		constraint.Kind = "FOREIGN KEY"
+		if constraint.Columns, err = p.parseIdentifierList(); err != nil {
+			return nil, err
+		}
+		return constraint, p.parseReferences(constraint)
+	case column != nil && p.acceptKeyword("NOT", "NULL"):
+		constraint.Kind = "NOT NULL"
+	case column != nil && p.acceptKeyword("DEFAULT"):
+		constraint.Kind = "DEFAULT"
+		if constraint.Expr, err = p.parseAdditive(); err != nil {
+			return nil, err
+		}
+	case column != nil:
+		return nil, p.unexpected("PRIMARY KEY, UNIQUE, NOT NULL, DEFAULT, CHECK or REFERENCES")
+	default:
+		return nil, p.unexpected("PRIMARY KEY, UNIQUE, CHECK or FOREIGN KEY")
+	}
+	if column != nil {
+		constraint.Columns = []string{column.Name}
+		return constraint, nil
+	}
+	columns, err := p.parseIdentifierList()
+	if err != nil {
+		return nil, err
+	}
+	constraint.Columns = columns
+	return constraint, nil
+}
+
+func (p *parser) parseReferences(constraint *ConstraintDef) error {
+	if err := p.expectKeyword("REFERENCES"); err != nil {
+		return err
+	}
+	var err error
+	if constraint.RefTable, err = p.parseIdentifier("table name"); err != nil {
+		return err
+	}
+	if p.peekSymbol("(") {
+		if constraint.RefColumns, err = p.parseIdentifierList(); err != nil {
+			return err
+		}
+	}
+	constraint.OnDelete = "NO ACTION"
+	for p.acceptKeyword("ON") {
+		pos := p.position()
+		event := "UPDATE"
+		if p.acceptKeyword("DELETE") {
+			event = "DELETE"
+		} else if err := p.expectKeyword("UPDATE"); err != nil {
+			return err
+		}
+		var action string
+		switch {
+		case p.acceptKeyword("CASCADE"):
+			action = "CASCADE"
+		case p.acceptKeyword("RESTRICT"):
+			action = "RESTRICT"
+		case p.acceptKeyword("NO", "ACTION"):
+			action = "NO ACTION"
+		case p.acceptKeyword("SET", "NULL"):
+			action = "SET NULL"
+		case p.acceptKeyword("SET", "DEFAULT"):
+			return notSupported(pos, "ON "+event+" SET DEFAULT")
 		default:
-			if selectCond {
-				query.Values = append(query.Values, part)
-			} else if fromCond {
-				table, ok := shared.K3Tables[db+"."+part]
-				if !ok {
-					filePath := shared.K3DataPath + db + "/" + part + shared.Extension
-					_, err := os.Open(filePath)
-					if err == nil {
-						tableFile := &shared.K3Table{Name: part, Database: db, Mu: new(sync.RWMutex), LU: time.Now()}
-						err = storage.AddFieldsTableFile(tableFile)
-						if err == nil {
-							shared.K3Tables[tableFile.Database+"."+tableFile.Name] = tableFile
-							table = tableFile
-						} else {
-							return nil, err
-						}
-					} else {
-						return nil, errors.New(shared.TableNotExists)
-					}
-				}
-				table.LU = time.Now()
-				query.Table = table
-			} else if whereCond {
-				whereParts = append(whereParts, part)
-			}
+			return p.unexpected("CASCADE, RESTRICT, NO ACTION or SET NULL")
 		}
+		if event == "DELETE" {
+			constraint.OnDelete = action
+		} else if action != "RESTRICT" && action != "NO ACTION" {
+			return notSupported(pos, "ON UPDATE "+action)
+		}
+	}
+	return nil
+}
+
+// parseTypeName reads a type name. DECIMAL may be followed by a precision and
+// scale, which are checked but not kept: DECIMAL values hold every digit they
+// are given.
+func (p *parser) parseTypeName(what string) (string, error) {
+	tok := p.peek()
+	if tok.kind != tokWord {
+		return "", p.unexpected(what)
 	}
-	if len(whereParts) > 0 {
-		conditions, err := parseWhereClause(strings.Join(whereParts, " "))
+	name := strings.ToUpper(p.advance().value)
+	if name != "DECIMAL" || !p.acceptSymbol("(") {
+		return name, nil
+	}
+	pos := p.position()
+	precision, err := p.parseTypeModifier("precision")
+	if err != nil {
+		return "", err
+	}
+	if precision < 1 || precision > shared.MaxDecimalPrecision {
+		return "", queryError(shared.InvalidSQLLogic, pos, "DECIMAL precision %d must be between 1 and %d", precision, shared.MaxDecimalPrecision)
+	}
+	if p.acceptSymbol(",") {
+		pos = p.position()
+		scale, err := p.parseTypeModifier("scale")
 		if err != nil {
+			return "", err
+		}
+		if scale > precision {
+			return "", queryError(shared.InvalidSQLLogic, pos, "DECIMAL scale %d must be between 0 and precision %d", scale, precision)
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func (p *parser) parseTypeModifier(what string) (int, error) {
+	tok := p.peek()
+	if tok.kind != tokNumber {
+		return 0, p.unexpected(what)
+	}
+	n, err := strconv.Atoi(tok.value)
+	if err != nil {
+		return 0, p.unexpected("integer " + what)
+	}
+	p.advance()
+	return n, nil
+}
+
+func (p *parser) parseAlter() (*AlterTableStmt, error) {
+	if err := p.expectKeyword("ALTER", "TABLE"); err != nil {
+		return nil, err
+	}
+	table, err := p.parseTableRef(false)
+	if err != nil {
+		return nil, err
+	}
+	stmt := &AlterTableStmt{Pos: p.position(), Table: table, Column: &ColumnDef{}}
+	switch {
+	case p.acceptKeyword("ADD"):
+		stmt.Action = "ADD COLUMN"
+		p.acceptKeyword("COLUMN")
+		stmt.Column.Pos = p.position()
+		if stmt.Column.Name, err = p.parseIdentifier("column name"); err != nil {
 			return nil, err
 		}
-		query.Conditions = conditions
+		if stmt.Column.Type, err = p.parseTypeName("column type"); err != nil {
+			return nil, err
+		}
+		if stmt.Constraints, err = p.parseColumnConstraints(stmt.Column); err != nil {
+			return nil, err
+		}
+	case p.acceptKeyword("DROP"):
+		stmt.Action = "DROP COLUMN"
+		p.acceptKeyword("COLUMN")
+		stmt.Column.Pos = p.position()
+		if stmt.Column.Name, err = p.parseIdentifier("column name"); err != nil {
+			return nil, err
+		}
+	case p.acceptKeyword("RENAME"):
+		if p.acceptKeyword("TO") {
+			stmt.Action = "RENAME TO"
+			if stmt.NewName, err = p.parseIdentifier("table name"); err != nil {
+				return nil, err
+			}
+			break
+		}
+		stmt.Action = "RENAME COLUMN"
+		p.acceptKeyword("COLUMN")
+		stmt.Column.Pos = p.position()
+		if stmt.Column.Name, err = p.parseIdentifier("column name"); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("TO"); err != nil {
+			return nil, err
+		}
+		if stmt.NewName, err = p.parseIdentifier("column name"); err != nil {
+			return nil, err
+		}
+	case p.acceptKeyword("ALTER"):
+		stmt.Action = "ALTER COLUMN TYPE"
+		p.acceptKeyword("COLUMN")
+		stmt.Column.Pos = p.position()
+		if stmt.Column.Name, err = p.parseIdentifier("column name"); err != nil {
+			return nil, err
+		}
+		p.acceptKeyword("SET", "DATA")
+		if err := p.expectKeyword("TYPE"); err != nil {
+			return nil, err
+		}
+		if stmt.Column.Type, err = p.parseTypeName("column type"); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, p.unexpected("ADD, DROP, RENAME or ALTER")
 	}
+	return stmt, nil
+}
 
-	return query, nil
+func (p *parser) parseDrop() (*DropStmt, error) {
+	if err := p.expectKeyword("DROP"); err != nil {
+		return nil, err
+	}
+	stmt := &DropStmt{Pos: p.position()}
+	tok := p.peek()
+	if tok.kind != tokWord {
+		return nil, p.unexpected("TABLE or DATABASE")
+	}
+	stmt.Object = strings.ToUpper(p.advance().value)
+	stmt.IfExists = p.acceptKeyword("IF", "EXISTS")
+	name, err := p.parseIdentifier(strings.ToLower(stmt.Object) + " name")
+	if err != nil {
+		return nil, err
+	}
+	stmt.Name = name
+	return stmt, nil
 }
 
-func ParseDeleteQuery(queryStr, db string) (*shared.K3DeleteQuery, error) {
-	parts := strings.Fields(queryStr)
-	query := new(shared.K3DeleteQuery)
-	query.Conditions = make([]shared.K3Condition, 0)
+func (p *parser) parseUser() (*UserStmt, error) {
+	if err := p.expectKeyword("USER"); err != nil {
+		return nil, err
+	}
+	stmt := new(UserStmt)
+	switch {
+	case p.acceptKeyword("NEW"):
+		stmt.Action = shared.K3CREATE
+	case p.acceptKeyword("DELETE"):
+		stmt.Action = shared.K3DELETE
+	default:
+		return nil, p.unexpected("NEW or DELETE")
+	}
+	name, err := p.parseIdentifier("user name")
+	if err != nil {
+		return nil, err
+	}
+	stmt.Username = name
+	tok := p.peek()
+	if tok.kind == tokEOF || (tok.kind == tokSymbol && tok.value == ";") {
+		return nil, p.unexpected("password")
+	}
+	stmt.Password = p.advance().value
+	return stmt, nil
+}
 
-	fromFlag := false
-	whereFlag := false
-	var whereParts []string
+func (p *parser) parseExprList() ([]Expr, error) {
+	var exprs []Expr
+	for {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if !p.acceptSymbol(",") {
+			return exprs, nil
+		}
+	}
+}
 
-	for _, part := range parts {
-		part = strings.TrimSuffix(part, ",")
-		upperPart := strings.ToUpper(part)
+func (p *parser) parseExpr() (Expr, error) {
+	return p.parseOr()
+}
 
-		switch upperPart {
-		case "DELETE":
-			continue
-		case "FROM":
-			fromFlag = true
-			whereFlag = false
-		case "WHERE":
-			whereFlag = true
-			fromFlag = false
-		default:
-			if fromFlag {
-				table, ok := shared.K3Tables[db+"."+part]
-				if !ok {
-					return nil, errors.New(shared.TableNotExists)
-				}
-				query.Table = table
-				fromFlag = false
-			} else if whereFlag {
-				whereParts = append(whereParts, part)
-			}
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("OR") {
+		pos := p.position()
+		p.advance()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
 		}
+		left = &BinaryExpr{Pos: pos, Op: "OR", Left: left, Right: right}
 	}
+	return left, nil
+}
 
-	if len(whereParts) > 0 {
-		conditions, err := parseWhereClause(strings.Join(whereParts, " "))
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("AND") {
+		pos := p.position()
+		p.advance()
+		right, err := p.parseNot()
 		if err != nil {
 			return nil, err
 		}
-		query.Conditions = conditions
+		left = &BinaryExpr{Pos: pos, Op: "AND", Left: left, Right: right}
 	}
+	return left, nil
+}
 
-	return query, nil
+func (p *parser) parseNot() (Expr, error) {
+	if p.peekKeyword("NOT") {
+		pos := p.position()
+		p.advance()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Pos: pos, Op: "NOT", Operand: operand}, nil
+	}
+	return p.parseComparison()
 }
 
-func parseWhereClause(whereClause string) ([]shared.K3Condition, error) {
-	var conditions []shared.K3Condition
-	andParts := strings.Split(whereClause, "and")
-	for _, part := range andParts {
-		part = strings.TrimSpace(part)
-		if part == "" {
-			continue
+func (p *parser) parseComparison() (Expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	pos := p.position()
+	tok := p.peek()
+	if tok.kind == tokSymbol {
+		switch tok.value {
+		case "=", "!=", "<>", "<", "<=", ">", ">=":
+			p.advance()
+			right, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			op := tok.value
+			if op == "<>" {
+				op = "!="
+			}
+			return &BinaryExpr{Pos: pos, Op: op, Left: left, Right: right}, nil
+		}
+	}
+	if p.acceptKeyword("IS") {
+		not := p.acceptKeyword("NOT")
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
 		}
-		cond, err := parseSingleCondition(part)
+		return &IsNullExpr{Pos: pos, Not: not, Expr: left}, nil
+	}
+	not := p.acceptKeyword("NOT")
+	switch {
+	case p.acceptKeyword("LIKE"):
+		pattern, err := p.parseAdditive()
 		if err != nil {
 			return nil, err
 		}
-		conditions = append(conditions, cond)
+		return &LikeExpr{Pos: pos, Not: not, Expr: left, Pattern: pattern}, nil
+	case p.acceptKeyword("IN"):
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		in := &InExpr{Pos: pos, Not: not, Expr: left}
+		var err error
+		if p.peekQuery() {
+			in.Subquery, err = p.parseQuery()
+		} else {
+			in.List, err = p.parseExprList()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return in, nil
+	case p.acceptKeyword("BETWEEN"):
+		low, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &BetweenExpr{Pos: pos, Not: not, Expr: left, Low: low, High: high}, nil
 	}
+	if not {
+		return nil, p.unexpected("LIKE, IN or BETWEEN")
+	}
+	return left, nil
+}
 
-	return conditions, nil
+func (p *parser) parseAdditive() (Expr, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekSymbol("+") || p.peekSymbol("-") || p.peekSymbol("||") {
+		pos := p.position()
+		op := p.advance().value
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Pos: pos, Op: op, Left: left, Right: right}
+	}
+	return left, nil
 }
 
-func parseSingleCondition(condStr string) (shared.K3Condition, error) {
-	if likeIdx := strings.Index(strings.ToUpper(condStr), "LIKE "); likeIdx >= 0 {
-		column := strings.TrimSpace(condStr[:likeIdx])
-		value := strings.TrimSpace(condStr[likeIdx+5:])
+func (p *parser) parseMultiplicative() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekSymbol("*") || p.peekSymbol("/") || p.peekSymbol("%") {
+		pos := p.position()
+		op := p.advance().value
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Pos: pos, Op: op, Left: left, Right: right}
+	}
+	return left, nil
+}
 
-		if len(value) > 0 && (value[0] == '\'' || value[0] == '"') {
-			value = strings.Trim(value, "'\"")
+func (p *parser) parseUnary() (Expr, error) {
+	if p.peekSymbol("-") || p.peekSymbol("+") {
+		pos := p.position()
+		op := p.advance().value
+		if next := p.peek(); next.kind == tokNumber {
+			p.advance()
+			value := next.value
+			if op == "-" {
+				value = "-" + value
+			}
+			return &Literal{Pos: pos, Kind: LitNumber, Value: value}, nil
 		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "+" {
+			return operand, nil
+		}
+		return &UnaryExpr{Pos: pos, Op: op, Operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
 
-		return shared.K3Condition{
-			Column:   column,
-			Operator: "LIKE",
-			Value:    value,
-		}, nil
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.peek()
+	pos := Pos{Line: tok.line, Col: tok.col}
+	switch tok.kind {
+	case tokString:
+		p.advance()
+		return &Literal{Pos: pos, Kind: LitString, Value: tok.value}, nil
+	case tokNumber:
+		p.advance()
+		return &Literal{Pos: pos, Kind: LitNumber, Value: tok.value}, nil
+	case tokSymbol:
+		switch tok.value {
+		case "(":
+			p.advance()
+			if p.peekQuery() {
+				return p.parseSubquery(pos)
+			}
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+			return expr, nil
+		}
+	case tokWord:
+		switch strings.ToUpper(tok.value) {
+		case "NULL":
+			p.advance()
+			return &Literal{Pos: pos, Kind: LitNull, Value: "NULL"}, nil
+		case "TRUE", "FALSE":
+			p.advance()
+			return &Literal{Pos: pos, Kind: LitBool, Value: strings.ToUpper(tok.value)}, nil
+		case "CASE":
+			return p.parseCase()
+		case "CURRENT_TIMESTAMP", "CURRENT_DATE":
+			p.advance()
+			return &FuncCall{Pos: pos, Name: strings.ToLower(tok.value)}, nil
+		case "EXISTS":
+			p.advance()
+			if err := p.expectSymbol("("); err != nil {
+				return nil, err
+			}
+			subquery, err := p.parseSubquery(pos)
+			if err != nil {
+				return nil, err
+			}
+			return &ExistsExpr{Pos: pos, Select: subquery.Select}, nil
+		}
 	}
+	if p.isIdentifier(tok) {
+		p.advance()
+		if tok.kind == tokWord && p.peekSymbol("(") {
+			if strings.EqualFold(tok.value, "CAST") {
+				return p.parseCast(pos)
+			}
+			return p.parseFuncCall(pos, strings.ToLower(tok.value))
+		}
+		ident := &Ident{Pos: pos, Name: identifierName(tok)}
+		if p.acceptSymbol(".") {
+			name, err := p.parseIdentifier("column name")
+			if err != nil {
+				return nil, err
+			}
+			ident.Table = ident.Name
+			ident.Name = name
+		}
+		return ident, nil
+	}
+	return nil, p.unexpected("expression")
+}
 
-	operators := []string{">=", "<=", "=", "!=", ">", "<"}
+func (p *parser) parseSubquery(pos Pos) (*SubqueryExpr, error) {
+	stmt, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return &SubqueryExpr{Pos: pos, Select: stmt}, nil
+}
+
+func (p *parser) parseCase() (*CaseExpr, error) {
+	expr := &CaseExpr{Pos: p.position()}
+	if err := p.expectKeyword("CASE"); err != nil {
+		return nil, err
+	}
+	var err error
+	if !p.peekKeyword("WHEN") {
+		if expr.Operand, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	for p.acceptKeyword("WHEN") {
+		when := &WhenClause{}
+		if when.Cond, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("THEN"); err != nil {
+			return nil, err
+		}
+		if when.Result, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		expr.Whens = append(expr.Whens, when)
+	}
+	if len(expr.Whens) == 0 {
+		return nil, p.unexpected("WHEN")
+	}
+	if p.acceptKeyword("ELSE") {
+		if expr.Else, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("END"); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
 
-	for _, op := range operators {
-		if opIdx := strings.Index(condStr, op); opIdx >= 0 {
-			column := strings.TrimSpace(condStr[:opIdx])
-			value := strings.TrimSpace(condStr[opIdx+len(op):])
+func (p *parser) parseCast(pos Pos) (*CastExpr, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	expr := &CastExpr{Pos: pos}
+	var err error
+	if expr.Expr, err = p.parseExpr(); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("AS"); err != nil {
+		return nil, err
+	}
+	if expr.Type, err = p.parseTypeName("type name"); err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
 
-			if len(value) > 0 && (value[0] == '\'' || value[0] == '"') {
-				value = strings.Trim(value, "'\"")
+func (p *parser) parseFuncCall(pos Pos, name string) (*FuncCall, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	call := &FuncCall{Pos: pos, Name: name}
+	if !p.acceptSymbol(")") {
+		if p.acceptSymbol("*") {
+			call.Star = true
+		} else {
+			call.Distinct = p.acceptKeyword("DISTINCT")
+			args, err := p.parseExprList()
+			if err != nil {
+				return nil, err
 			}
-
-			return shared.K3Condition{
-				Column:   column,
-				Operator: op,
-				Value:    value,
-			}, nil
+			call.Args = args
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
 		}
 	}
+	if p.isKeyword(p.peek(), "OVER") && p.peekAt(1).kind == tokSymbol && p.peekAt(1).value == "(" {
+		p.pos += 2
+		window, err := p.parseWindow()
+		if err != nil {
+			return nil, err
+		}
+		call.Over = window
+	}
+	return call, nil
+}
 
-	return shared.K3Condition{}, errors.New(shared.InvalidSQLSyntax)
+func (p *parser) parseWindow() (*WindowSpec, error) {
+	window := &WindowSpec{}
+	var err error
+	if p.acceptKeyword("PARTITION") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if window.PartitionBy, err = p.parseExprList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("ORDER") {
+		if window.OrderBy, err = p.parseOrderBy(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peekKeyword("ROWS") || p.peekKeyword("RANGE") {
+		return nil, notSupported(p.position(), "window frame clauses")
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return window, nil
 }
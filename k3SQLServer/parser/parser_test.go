@@ -0,0 +1,175 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseStatementKinds(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want Statement
+	}{
+		{"SELECT * FROM t", &SelectStmt{}},
+		{"select a, b from t where a = 1 order by b limit 2;", &SelectStmt{}},
+		{"(SELECT a FROM t)", &SelectStmt{}},
+		{"SELECT a FROM t UNION ALL SELECT a FROM u", &SetOpStmt{}},
+		{"WITH c AS (SELECT a FROM t) SELECT a FROM c", &WithStmt{}},
+		{"INSERT INTO t (a, b) VALUES (1, 'x'), (2, NULL)", &InsertStmt{}},
+		{"INSERT INTO t (a) SELECT a FROM u", &InsertStmt{}},
+		{"INSERT IGNORE INTO t (a) VALUES (1)", &InsertStmt{}},
+		{"INSERT INTO t (a) VALUES (1) ON CONFLICT (a) DO UPDATE SET a = 2", &InsertStmt{}},
+		{"UPDATE t SET a = a + 1 WHERE b IS NULL", &UpdateStmt{}},
+		{"DELETE FROM t WHERE a IN (1, 2)", &DeleteStmt{}},
+		{"CREATE TABLE t (a INT PRIMARY KEY, b TEXT NOT NULL)", &CreateTableStmt{}},
+		{"CREATE TABLE IF NOT EXISTS t AS SELECT a FROM u", &CreateTableStmt{}},
+		{"CREATE DATABASE d", &CreateDatabaseStmt{}},
+		{"ALTER TABLE t ADD COLUMN c FLOAT", &AlterTableStmt{}},
+		{"ALTER TABLE t RENAME COLUMN a TO z", &AlterTableStmt{}},
+		{"DROP TABLE IF EXISTS t", &DropStmt{}},
+		{"DROP DATABASE d", &DropStmt{}},
+		{"USER NEW bob secret", &UserStmt{}},
+		{"USER DELETE bob x", &UserStmt{}},
+	}
+	for _, tt := range tests {
+		stmt, err := Parse(tt.sql)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.sql, err)
+			continue
+		}
+		if reflect.TypeOf(stmt) != reflect.TypeOf(tt.want) {
+			t.Errorf("Parse(%q) = %T, want %T", tt.sql, stmt, tt.want)
+		}
+	}
+}
+
+func TestParseLiterals(t *testing.T) {
+	tests := []struct {
+		expr  string
+		kind  int
+		value string
+	}{
+		{"42", LitNumber, "42"},
+		{"-42", LitNumber, "-42"},
+		{"+42", LitNumber, "42"},
+		{"-3.5e2", LitNumber, "-3.5e2"},
+		{"'it''s'", LitString, "it's"},
+		{"''", LitString, ""},
+		{"NULL", LitNull, "NULL"},
+		{"true", LitBool, "TRUE"},
+		{"False", LitBool, "FALSE"},
+	}
+	for _, tt := range tests {
+		stmt, err := Parse("SELECT " + tt.expr + " FROM t")
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		lit, ok := stmt.(*SelectStmt).Columns[0].Expr.(*Literal)
+		if !ok {
+			t.Errorf("%s parsed as %T, want *Literal", tt.expr, stmt.(*SelectStmt).Columns[0].Expr)
+			continue
+		}
+		if lit.Kind != tt.kind || lit.Value != tt.value {
+			t.Errorf("%s = literal %d %q, want %d %q", tt.expr, lit.Kind, lit.Value, tt.kind, tt.value)
+		}
+	}
+}
+
+func TestParseNegatedExpression(t *testing.T) {
+	stmt, err := Parse("SELECT -a, - -1 FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := stmt.(*SelectStmt).Columns
+	if unary, ok := columns[0].Expr.(*UnaryExpr); !ok || unary.Op != "-" {
+		t.Errorf("-a parsed as %#v, want a negation", columns[0].Expr)
+	}
+	unary, ok := columns[1].Expr.(*UnaryExpr)
+	if !ok {
+		t.Fatalf("- -1 parsed as %#v, want a negation", columns[1].Expr)
+	}
+	if lit, ok := unary.Operand.(*Literal); !ok || lit.Value != "-1" {
+		t.Errorf("- -1 operand = %#v, want literal -1", unary.Operand)
+	}
+}
+
+func TestParseQuotedIdentifiers(t *testing.T) {
+	stmt, err := Parse(`SELECT "Select", ` + "`my col`" + ` FROM "Order Items"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel := stmt.(*SelectStmt)
+	var names []string
+	for _, column := range sel.Columns {
+		names = append(names, column.Expr.(*Ident).Name)
+	}
+	if want := []string{"Select", "my col"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("columns = %q, want %q", names, want)
+	}
+	if sel.From.Name != "Order Items" {
+		t.Errorf("table = %q, want %q", sel.From.Name, "Order Items")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"", "line 1, column 1: expected statement, found end of query"},
+		{"SELEC * FROM t", "line 1, column 1: expected statement, found 'SELEC'"},
+		{"SELECT * t", "line 1, column 10: expected FROM, found 't'"},
+		{"SELECT a FROM t WHERE", "line 1, column 22: expected expression, found end of query"},
+		{"SELECT a\nFROM t\nWHERE a = = 1", "line 3, column 11: expected expression, found '='"},
+		{"INSERT INTO t (a VALUES (1)", "line 1, column 18: expected ')', found 'VALUES'"},
+		{"SELECT a FROM t; SELECT", "line 1, column 18: expected end of query, found 'SELECT'"},
+		{"SELECT 'abc FROM t", "line 1, column 8: unterminated quoted string"},
+		{"USER NEW bob", "line 1, column 13: expected password, found end of query"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.sql)
+		if err == nil || !strings.HasSuffix(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want suffix %q", tt.sql, err, tt.want)
+		}
+	}
+}
+
+func TestParseDecimalType(t *testing.T) {
+	for _, sql := range []string{
+		"CREATE TABLE t (a DECIMAL)",
+		"CREATE TABLE t (a DECIMAL(10))",
+		"CREATE TABLE t (a decimal(10, 2) NOT NULL)",
+		"CREATE TABLE t (a DECIMAL(5,5))",
+		"ALTER TABLE t ADD COLUMN a DECIMAL(1000, 0)",
+		"SELECT CAST(a AS DECIMAL(10,2)) FROM t",
+	} {
+		stmt, err := Parse(sql)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", sql, err)
+			continue
+		}
+		if create, ok := stmt.(*CreateTableStmt); ok && create.Columns[0].Type != "DECIMAL" {
+			t.Errorf("Parse(%q) column type = %q, want DECIMAL", sql, create.Columns[0].Type)
+		}
+	}
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"CREATE TABLE t (a DECIMAL(0))", "column 27: DECIMAL precision 0 must be between 1 and 1000"},
+		{"CREATE TABLE t (a DECIMAL(1001, 2))", "column 27: DECIMAL precision 1001 must be between 1 and 1000"},
+		{"CREATE TABLE t (a DECIMAL(4, 5))", "column 30: DECIMAL scale 5 must be between 0 and precision 4"},
+		{"CREATE TABLE t (a DECIMAL(10, -1))", "column 31: expected scale, found '-'"},
+		{"CREATE TABLE t (a DECIMAL(10.5))", "column 27: expected integer precision, found '10.5'"},
+		{"CREATE TABLE t (a DECIMAL(10, 2)", "column 33: expected ')', found end of query"},
+		{"CREATE TABLE t (a DECIMAL())", "column 27: expected precision, found ')'"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.sql)
+		if err == nil || !strings.HasSuffix(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want suffix %q", tt.sql, err, tt.want)
+		}
+	}
+}
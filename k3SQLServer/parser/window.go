@@ -0,0 +1,115 @@
+package parser
+
+import (
+	"k3SQLServer/shared"
+	"strings"
+)
+
+var windowFunctions = map[string]bool{
+	"row_number": true, "rank": true, "dense_rank": true, "lag": true, "lead": true,
+}
+
+func buildWindow(call *FuncCall, scope *buildScope) (*shared.K3Expr, error) {
+	if !scope.windows {
+		return nil, queryError(shared.InvalidSQLLogic, call.Pos, "window function %s is not allowed here", call.Name)
+	}
+	if !windowFunctions[call.Name] && !aggregateFunctions[call.Name] {
+		return nil, queryError(shared.InvalidSQLLogic, call.Pos, "%s is not a window function", call.Name)
+	}
+	if call.Distinct {
+		return nil, notSupported(call.Pos, "DISTINCT in window functions")
+	}
+	inner := *scope
+	inner.windows = false
+	window := &shared.K3Expr{
+		Kind:     shared.K3ExprWindow,
+		Operator: strings.ToUpper(call.Name),
+		Column:   exprString(call),
+		Window:   &shared.K3Window{},
+	}
+	var err error
+	if window.Window.PartitionBy, err = buildExprs(call.Over.PartitionBy, &inner); err != nil {
+		return nil, err
+	}
+	for _, item := range call.Over.OrderBy {
+		expr, err := buildExpr(item.Expr, &inner)
+		if err != nil {
+			return nil, err
+		}
+		order := orderBy(item)
+		order.Expr = expr
+		window.Window.OrderBy = append(window.Window.OrderBy, order)
+	}
+	if call.Star && call.Name != "count" {
+		return nil, queryError(shared.InvalidSQLLogic, call.Pos, "%s(*) is not allowed", call.Name)
+	}
+	if window.Args, err = buildExprs(call.Args, &inner); err != nil {
+		return nil, err
+	}
+	switch call.Name {
+	case "row_number", "rank", "dense_rank":
+		if len(window.Args) != 0 {
+			return nil, queryError(shared.InvalidSQLLogic, call.Pos, "wrong number of arguments to %s", call.Name)
+		}
+		window.Type = shared.K3INT
+	case "lag", "lead":
+		if len(window.Args) < 1 || len(window.Args) > 3 {
+			return nil, queryError(shared.InvalidSQLLogic, call.Pos, "wrong number of arguments to %s", call.Name)
+		}
+		if len(window.Args) > 1 && window.Args[1].Type != shared.K3INT && window.Args[1].Kind != shared.K3ExprNull {
+			return nil, queryError(shared.InvalidSQLLogic, call.Pos, "function %s does not accept %s as argument %d", call.Name, typeName(window.Args[1]), 2)
+		}
+		results := []*shared.K3Expr{window.Args[0]}
+		if len(window.Args) > 2 {
+			results = append(results, window.Args[2])
+		}
+		if window.Type, err = commonType(call.Name, call.Pos, results); err != nil {
+			return nil, err
+		}
+	default:
+		if !call.Star && len(window.Args) != 1 {
+			return nil, queryError(shared.InvalidSQLLogic, call.Pos, "%s expects exactly one argument", call.Name)
+		}
+		if (call.Name == "sum" || call.Name == "avg") && !isNumeric(window.Args[0]) {
+			return nil, queryError(shared.InvalidSQLLogic, call.Pos, "%s requires a numeric argument", call.Name)
+		}
+		window.Type = aggregateType(window)
+	}
+	for _, arg := range window.Args {
+		if isCondition(arg) {
+			return nil, notSupported(call.Pos, "conditions as window function arguments")
+		}
+	}
+	for _, known := range scope.query.Windows {
+		if known.Column == window.Column {
+			return known, nil
+		}
+	}
+	scope.query.Windows = append(scope.query.Windows, window)
+	return window, nil
+}
+
+func windowString(window *WindowSpec) string {
+	var parts []string
+	if len(window.PartitionBy) > 0 {
+		exprs := make([]string, len(window.PartitionBy))
+		for i, expr := range window.PartitionBy {
+			exprs[i] = exprString(expr)
+		}
+		parts = append(parts, "partition by "+strings.Join(exprs, ", "))
+	}
+	if len(window.OrderBy) > 0 {
+		items := make([]string, len(window.OrderBy))
+		for i, item := range window.OrderBy {
+			items[i] = exprString(item.Expr)
+			if item.Desc {
+				items[i] += " desc"
+			}
+			if item.Nulls != "" {
+				items[i] += " nulls " + strings.ToLower(item.Nulls)
+			}
+		}
+		parts = append(parts, "order by "+strings.Join(items, ", "))
+	}
+	return strings.Join(parts, " ")
+}
@@ -19,12 +19,12 @@ type k3QueryRequest struct {
 }
 
 type k3QueryResponse struct {
-	RespType    string              `json:"resp_type"`
-	Status      bool                `json:"status"`
-	Message     string              `json:"message"`
-	TableFields []string            `json:"table_fields"`
-	Fields      []map[string]string `json:"fields"`
-	Error       string              `json:"error"`
+	RespType    string               `json:"resp_type"`
+	Status      bool                 `json:"status"`
+	Message     string               `json:"message"`
+	TableFields []string             `json:"table_fields"`
+	Fields      []map[string]*string `json:"fields"`
+	Error       string               `json:"error"`
 }
 
 func handleConnection(conn net.Conn) {
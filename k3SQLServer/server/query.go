@@ -5,76 +5,26 @@ import (
 	"k3SQLServer/core"
 	"k3SQLServer/parser"
 	"k3SQLServer/shared"
-	"regexp"
-	"strings"
 )
 
-func checkQuery(queryStr string) bool {
-	parts := strings.Fields(queryStr)
-	if len(parts) > 0 {
-		part := parts[0]
-		switch strings.ToLower(part) {
-		case "select":
-			return checkSelectQuery(queryStr)
-		case "create":
-			return checkCreateQuery(queryStr)
-		case "drop":
-			return checkDropQuery(queryStr)
-		case "insert":
-			return checkInsertQuery(queryStr)
-		case "update":
-			return checkUpdateQuery(queryStr)
-		case "delete":
-			return checkDeleteQuery(queryStr)
-		case "alter":
-			return checkAlterQuery(queryStr)
-		case "explain":
-			return checkQuery(queryStr[len(part):])
-		case "user":
-			return checkUserQuery(queryStr)
-		default:
-			return false
+// responseFields turns rows into response fields, where a column missing
+// from a row is a JSON null.
+func responseFields(columns []string, rows []map[string]string) []map[string]*string {
+	if rows == nil {
+		return nil
+	}
+	fields := make([]map[string]*string, len(rows))
+	for i, row := range rows {
+		fields[i] = make(map[string]*string, len(columns))
+		for _, column := range columns {
+			if value, ok := row[column]; ok {
+				fields[i][column] = &value
+			} else {
+				fields[i][column] = nil
+			}
 		}
 	}
-	return false
-}
-
-func checkSelectQuery(query string) bool {
-	selectRegex := regexp.MustCompile(`(?is)^\s*SELECT\s+(?:(?:DISTINCT|ALL)\s+)?(?:[\w*]+(?:\s*,\s*[\w*]+)*|\*)\s+FROM\s+\w+(?:\s+(?:AS\s+)?\w+)?(?:\s+(?:INNER\s+|LEFT\s+|RIGHT\s+|FULL\s+)?JOIN\s+\w+(?:\s+(?:AS\s+)?\w+)?\s+ON\s+[^;]+)?(?:\s+WHERE\s+[^;]+)?(?:\s+GROUP\s+BY\s+[^;]+)?(?:\s+HAVING\s+[^;]+)?(?:\s+ORDER\s+BY\s+[^;]+)?(?:\s+(?:LIMIT\s+\d+(?:\s*,\s*\d+|\s+OFFSET\s+\d+)?)?)?\s*;?\s*$`)
-	return selectRegex.MatchString(query)
-}
-
-func checkCreateQuery(query string) bool {
-	createRegex := regexp.MustCompile(`(?i)^\s*CREATE\s+(TEMPORARY\s+)?(TABLE\s+(IF\s+NOT\s+EXISTS\s+)?([` + "`" + `"]?\w+[` + "`" + `"]?\.)?[` + "`" + `"]?\w+[` + "`" + `"]?\s*\(.*\)|(DATABASE|SCHEMA)\s+(IF\s+NOT\s+EXISTS\s+)?[` + "`" + `"]?\w+[` + "`" + `"]?)\s*(;)?\s*$`)
-	return createRegex.MatchString(query)
-}
-
-func checkDropQuery(query string) bool {
-	dropRegex := regexp.MustCompile(`(?i)^\s*DROP\s+(TABLE|DATABASE|SCHEMA|INDEX|VIEW|TRIGGER|PROCEDURE|FUNCTION)\s+(IF\s+EXISTS\s+)?([` + "`" + `"]?\w+[` + "`" + `"]?\.)?[` + "`" + `"]?\w+[` + "`" + `"]?\s*(;)?\s*$`)
-	return dropRegex.MatchString(query)
-}
-
-func checkInsertQuery(query string) bool {
-	insertRegex := regexp.MustCompile(`(?is)^\s*INSERT\s+(?:IGNORE\s+)?INTO\s+\w+\s*\(\s*\w+(?:\s*,\s*\w+)*\s*\)\s*VALUES\s*\([^)]+\)(?:\s*,\s*\([^)]+\))*\s*;?\s*$`)
-	return insertRegex.MatchString(query)
-}
-
-func checkUpdateQuery(query string) bool {
-	updateRegex := regexp.MustCompile(`(?is)^\s*UPDATE\s+\w+\s+SET\s+[^;]+(?:\s+WHERE\s+[^;]+)?\s*;?\s*$`)
-	return updateRegex.MatchString(query)
-}
-
-func checkDeleteQuery(query string) bool {
-	deleteRegex := regexp.MustCompile(`(?is)^\s*DELETE\s+FROM\s+\w+(?:\s+WHERE\s+[^;]+)?\s*;?\s*$`)
-	return deleteRegex.MatchString(query)
-}
-
-func checkAlterQuery(query string) bool {
-	return true
-}
-
-func checkUserQuery(query string) bool {
-	return true
+	return fields
 }
 
 func querySQL(queryString, user string, dbSlice ...string) *k3QueryResponse {
@@ -82,25 +32,28 @@ func querySQL(queryString, user string, dbSlice ...string) *k3QueryResponse {
 	if len(dbSlice) > 0 {
 		db = dbSlice[0]
 	}
-	queryString = strings.ToLower(queryString)
 	response := &k3QueryResponse{}
 	response.RespType = "query"
 	response.Status = false
-	if !checkQuery(queryString) {
-		response.Error = shared.InvalidSQLSyntax
+	stmt, err := parser.Parse(queryString)
+	if err != nil {
+		response.Error = err.Error()
 		return response
 	}
-	queryParts := strings.Fields(queryString)
-	switch strings.ToLower(queryParts[0]) {
-	case "select":
-		query, err := parser.ParseSelectQuery(queryString, db)
+	switch stmt := stmt.(type) {
+	case *parser.SelectStmt, *parser.SetOpStmt, *parser.WithStmt:
+		query, err := parser.BuildSelectQuery(stmt, db)
 		if err == nil {
 			resp, rows, err := core.SelectTable(query, user)
-			response.Fields = resp
+			response.Fields = responseFields(query.Columns, resp)
 			if err == nil {
 				response.Status = true
-				response.TableFields = query.Table.Fields
-				response.Message = fmt.Sprintf("%d rows found", rows)
+				response.TableFields = query.Columns
+				if len(query.GroupBy) > 0 || len(query.Aggregates) > 0 {
+					response.Message = fmt.Sprintf("%d groups found", rows)
+				} else {
+					response.Message = fmt.Sprintf("%d rows found", rows)
+				}
 			} else {
 				response.Error = err.Error()
 			}
@@ -108,37 +61,46 @@ func querySQL(queryString, user string, dbSlice ...string) *k3QueryResponse {
 			response.Error = err.Error()
 		}
 		return response
-	case "create":
-		query, err := parser.ParseCreateQuery(queryString, db)
+	case *parser.CreateTableStmt:
+		query, err := parser.BuildCreateQuery(stmt, db)
 		if err == nil {
-			if len(query.Table.Name) > 0 {
-				err = core.CreateTable(query)
-				if err == nil {
-					response.Status = true
-					response.Message = "done"
-				} else {
-					response.Error = err.Error()
-				}
+			query.User = user
+			err = core.CreateTable(query)
+			if err == nil {
+				response.Status = true
+				response.Message = "done"
 			} else {
-				err = core.CreateDatabase(query.Table.Database)
-				if err == nil {
-					response.Status = true
-					response.Message = "done"
-				} else {
-					response.Error = err.Error()
-				}
+				response.Error = err.Error()
+			}
+		} else {
+			response.Error = err.Error()
+		}
+		return response
+	case *parser.CreateDatabaseStmt:
+		query, err := parser.BuildCreateDatabaseQuery(stmt)
+		if err == nil {
+			err = core.CreateDatabase(query.Table.Database)
+			if err == nil {
+				response.Status = true
+				response.Message = "done"
+			} else {
+				response.Error = err.Error()
 			}
 		} else {
 			response.Error = err.Error()
 		}
 		return response
-	case "insert":
-		query, err := parser.ParseInsertQuery(queryString, db)
+	case *parser.InsertStmt:
+		query, err := parser.BuildInsertQuery(stmt, db)
 		if err == nil {
 			err = core.InsertTable(query, user)
 			if err == nil {
 				response.Status = true
 				response.Message = "done"
+				if query.Returning != nil {
+					response.TableFields = query.Returning.Columns
+					response.Fields = responseFields(query.Returning.Columns, query.Returned)
+				}
 			} else {
 				response.Error = err.Error()
 			}
@@ -146,13 +108,17 @@ func querySQL(queryString, user string, dbSlice ...string) *k3QueryResponse {
 			response.Error = err.Error()
 		}
 		return response
-	case "update":
-		query, err := parser.ParseUpdateQuery(queryString, db)
+	case *parser.UpdateStmt:
+		query, err := parser.BuildUpdateQuery(stmt, db)
 		if err == nil {
 			count, err := core.UpdateTable(query, user)
 			if err == nil {
 				response.Status = true
 				response.Message = fmt.Sprintf("%d rows updated", count)
+				if query.Returning != nil {
+					response.TableFields = query.Returning.Columns
+					response.Fields = responseFields(query.Returning.Columns, query.Returned)
+				}
 			} else {
 				response.Error = err.Error()
 			}
@@ -160,8 +126,22 @@ func querySQL(queryString, user string, dbSlice ...string) *k3QueryResponse {
 			response.Error = err.Error()
 		}
 		return response
-	case "drop":
-		table, err := parser.ParseDropQuery(queryString, db)
+	case *parser.AlterTableStmt:
+		query, err := parser.BuildAlterQuery(stmt, db)
+		if err == nil {
+			err = core.AlterTable(query, user)
+			if err == nil {
+				response.Status = true
+				response.Message = "done"
+			} else {
+				response.Error = err.Error()
+			}
+		} else {
+			response.Error = err.Error()
+		}
+		return response
+	case *parser.DropStmt:
+		table, err := parser.BuildDropQuery(stmt, db)
 		if err == nil {
 			err = core.DropTable(table, user)
 			if err == nil {
@@ -174,13 +154,17 @@ func querySQL(queryString, user string, dbSlice ...string) *k3QueryResponse {
 			response.Error = err.Error()
 		}
 		return response
-	case "delete":
-		query, err := parser.ParseDeleteQuery(queryString, db)
+	case *parser.DeleteStmt:
+		query, err := parser.BuildDeleteQuery(stmt, db)
 		if err == nil {
 			count, err := core.DeleteTable(query, user)
 			if err == nil {
 				response.Status = true
 				response.Message = fmt.Sprintf("%d rows deleted", count)
+				if query.Returning != nil {
+					response.TableFields = query.Returning.Columns
+					response.Fields = responseFields(query.Returning.Columns, query.Returned)
+				}
 			} else {
 				response.Error = err.Error()
 			}
@@ -188,8 +172,8 @@ func querySQL(queryString, user string, dbSlice ...string) *k3QueryResponse {
 			response.Error = err.Error()
 		}
 		return response
-	case "user":
-		query, err := parser.ParseUserQuery(queryString, db)
+	case *parser.UserStmt:
+		query, err := parser.BuildUserQuery(stmt, db)
 		if err == nil {
 			err = core.ProcessUser(query)
 			if err == nil {
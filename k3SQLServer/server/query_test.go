@@ -0,0 +1,948 @@
+package server
+
+import (
+	"fmt"
+	"k3SQLServer/core"
+	"k3SQLServer/shared"
+	"k3SQLServer/storage"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDatabase creates an empty database for one test and removes it, with
+// its cached tables, when the test ends.
+func testDatabase(t *testing.T) string {
+	t.Helper()
+	db := fmt.Sprintf("k3test_%d", time.Now().UnixNano())
+	if err := core.CreateDatabase(db); err != nil {
+		t.Skipf("cannot create a test database in %s: %v", shared.K3FilesPath, err)
+	}
+	// The service only loads system tables at start up, so load the new ones.
+	for _, name := range []string{shared.K3UsersTable, shared.K3TablesTable, shared.K3PermissionsTable} {
+		table := &shared.K3Table{Name: name, Database: db, Mu: new(sync.RWMutex), LU: time.Now()}
+		if err := storage.AddFieldsTableFile(table); err != nil {
+			t.Fatal(err)
+		}
+		shared.K3Tables[db+"."+name] = table
+	}
+	t.Cleanup(func() {
+		for key := range shared.K3Tables {
+			if strings.HasPrefix(key, db+".") {
+				delete(shared.K3Tables, key)
+			}
+		}
+		os.RemoveAll(shared.K3DataPath + db)
+	})
+	return db
+}
+
+// exec runs every statement and fails the test if one of them fails.
+func exec(t *testing.T, db string, statements ...string) {
+	t.Helper()
+	for _, statement := range statements {
+		if response := querySQL(statement, shared.CoreUser, db); !response.Status {
+			t.Fatalf("%s: %s", statement, response.Error)
+		}
+	}
+}
+
+// queryRows runs a query and returns its rows in column order, with NULL
+// written as "NULL".
+func queryRows(t *testing.T, db, query string) [][]string {
+	t.Helper()
+	response := querySQL(query, shared.CoreUser, db)
+	if !response.Status {
+		t.Fatalf("%s: %s", query, response.Error)
+	}
+	rows := [][]string{}
+	for _, fields := range response.Fields {
+		row := make([]string, len(response.TableFields))
+		for i, column := range response.TableFields {
+			if value := fields[column]; value != nil {
+				row[i] = *value
+			} else {
+				row[i] = "NULL"
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// queryError runs a statement that must fail and returns its error.
+func queryError(t *testing.T, db, statement string) string {
+	t.Helper()
+	response := querySQL(statement, shared.CoreUser, db)
+	if response.Status {
+		t.Fatalf("%s succeeded, want an error", statement)
+	}
+	return response.Error
+}
+
+func checkRows(t *testing.T, db, query string, want [][]string) {
+	t.Helper()
+	if got := queryRows(t, db, query); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %q, want %q", query, got, want)
+	}
+}
+
+func TestInsertColumnList(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db, "CREATE TABLE t (id INT, name TEXT)")
+	tests := []struct {
+		statement string
+		want      string
+	}{
+		{"INSERT INTO t (id, name, nope) VALUES (1, 'a', 'b')", shared.ColumnNotExists},
+		{"INSERT INTO t (id, nope) VALUES (1, 'a')", shared.ColumnNotExists},
+		{"INSERT INTO t (id, id) VALUES (1, 2)", "column id specified more than once"},
+		{"INSERT INTO t (id, nope) SELECT id, name FROM t", shared.ColumnNotExists},
+	}
+	for _, tt := range tests {
+		if err := queryError(t, db, tt.statement); !strings.Contains(err, tt.want) {
+			t.Errorf("%s: error %q, want %q", tt.statement, err, tt.want)
+		}
+	}
+	checkRows(t, db, "SELECT id, name FROM t", [][]string{})
+	exec(t, db, "INSERT INTO t (name, id) VALUES ('a', 1)")
+	checkRows(t, db, "SELECT id, name FROM t", [][]string{{"1", "a"}})
+}
+
+func TestGroupByLimit(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE t (g INT, v INT)",
+		"INSERT INTO t (g, v) VALUES (1, 10), (2, 20), (2, 21), (3, 30), (4, 40), (4, 41)",
+	)
+	checkRows(t, db, "SELECT g, count(*) FROM t GROUP BY g LIMIT 1", [][]string{{"1", "1"}})
+	checkRows(t, db, "SELECT g, count(*) FROM t GROUP BY g LIMIT 2 OFFSET 1", [][]string{{"2", "2"}, {"3", "1"}})
+	checkRows(t, db, "SELECT g FROM t GROUP BY g HAVING count(*) > 1 LIMIT 1", [][]string{{"2"}})
+	checkRows(t, db, "SELECT g FROM t GROUP BY g ORDER BY g DESC LIMIT 2", [][]string{{"4"}, {"3"}})
+	checkRows(t, db, "SELECT count(*) FROM t LIMIT 1 OFFSET 1", [][]string{})
+}
+
+func TestWindowLimit(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE t (id INT, g INT)",
+		"INSERT INTO t (id, g) VALUES (1, 1), (2, 1), (3, 2), (4, 2), (5, 2), (6, 3)",
+	)
+	checkRows(t, db, "SELECT id, row_number() OVER (ORDER BY id) FROM t LIMIT 2", [][]string{{"1", "1"}, {"2", "2"}})
+	checkRows(t, db, "SELECT id, count(*) OVER (PARTITION BY g) FROM t LIMIT 2 OFFSET 2", [][]string{{"3", "3"}, {"4", "3"}})
+	checkRows(t, db, "SELECT id, sum(id) OVER (ORDER BY id) FROM t ORDER BY id DESC LIMIT 1", [][]string{{"6", "21"}})
+}
+
+func TestInsertIgnore(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE plain (a INT, b TEXT)",
+		"INSERT IGNORE INTO plain (a, b) VALUES (1, 'x'), (1, 'x'), (2, 'y')",
+		"INSERT INTO plain (a, b) VALUES (1, 'x') ON CONFLICT DO NOTHING",
+		"CREATE TABLE keyed (id INT PRIMARY KEY, code TEXT UNIQUE, n INT)",
+		"INSERT INTO keyed (id, code, n) VALUES (1, 'a', 1), (2, 'b', 1)",
+		"INSERT IGNORE INTO keyed (id, code, n) VALUES (1, 'z', 2), (3, 'b', 2), (4, 'd', 2), (4, 'e', 2)",
+	)
+	checkRows(t, db, "SELECT a, b FROM plain", [][]string{{"1", "x"}, {"1", "x"}, {"2", "y"}, {"1", "x"}})
+	checkRows(t, db, "SELECT id, code, n FROM keyed", [][]string{{"1", "a", "1"}, {"2", "b", "1"}, {"4", "d", "2"}})
+	exec(t, db, "INSERT INTO keyed (id, code, n) VALUES (1, 'q', 3) ON CONFLICT (id) DO NOTHING")
+	if err := queryError(t, db, "INSERT INTO keyed (id, code, n) VALUES (5, 'a', 3) ON CONFLICT (id) DO NOTHING"); !strings.Contains(err, shared.UniqueViolation) {
+		t.Errorf("a conflict outside the target: error %q, want %q", err, shared.UniqueViolation)
+	}
+	checkRows(t, db, "SELECT id, code, n FROM keyed", [][]string{{"1", "a", "1"}, {"2", "b", "1"}, {"4", "d", "2"}})
+}
+
+func TestOnConflictDoUpdate(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE t (a INT, b INT, n INT, note TEXT, PRIMARY KEY (a, b))",
+		"INSERT INTO t (a, b, n) VALUES (1, 1, 1), (1, 2, 1)",
+		"INSERT INTO t (a, b, n) VALUES (1, 2, 5), (2, 1, 1) ON CONFLICT (b, a) DO UPDATE SET n = t.n + excluded.n",
+		"INSERT INTO t (a, b, n) VALUES (1, 1, 9) ON CONFLICT (a, b) DO UPDATE SET note = 'kept' WHERE t.n > 1",
+	)
+	checkRows(t, db, "SELECT a, b, n, note FROM t", [][]string{{"1", "1", "1", "NULL"}, {"1", "2", "6", "NULL"}, {"2", "1", "1", "NULL"}})
+	tests := []struct {
+		statement string
+		want      string
+	}{
+		{"INSERT INTO t (a, b, n) VALUES (3, 3, 1), (3, 3, 2) ON CONFLICT (a, b) DO UPDATE SET n = 0", "cannot affect a row a second time"},
+		{"INSERT INTO t (a, b, n) VALUES (1, 1, 1), (1, 1, 2) ON CONFLICT (a, b) DO UPDATE SET n = 0", "cannot affect a row a second time"},
+		{"INSERT INTO t (a, b, n) VALUES (1, 1, 1) ON CONFLICT (a) DO NOTHING", "no unique or exclusion constraint matching"},
+		{"INSERT INTO t (a, b, n) VALUES (1, 1, 1) ON CONFLICT (n) DO UPDATE SET n = 0", "no unique or exclusion constraint matching"},
+	}
+	for _, tt := range tests {
+		if err := queryError(t, db, tt.statement); !strings.Contains(err, tt.want) {
+			t.Errorf("%s: error %q, want %q", tt.statement, err, tt.want)
+		}
+	}
+	checkRows(t, db, "SELECT a, b, n FROM t", [][]string{{"1", "1", "1"}, {"1", "2", "6"}, {"2", "1", "1"}})
+}
+
+func TestRenameNullColumn(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE t (id INT, name TEXT, n INT)",
+		"INSERT INTO t (id, name, n) VALUES (1, NULL, NULL), (2, '', 3)",
+		"ALTER TABLE t RENAME COLUMN name TO label",
+		"ALTER TABLE t RENAME COLUMN n TO m",
+	)
+	checkRows(t, db, "SELECT id, label, m FROM t", [][]string{{"1", "NULL", "NULL"}, {"2", "", "3"}})
+	checkRows(t, db, "SELECT id FROM t WHERE label IS NULL", [][]string{{"1"}})
+	exec(t, db, "ALTER TABLE t RENAME COLUMN label TO name")
+	checkRows(t, db, "SELECT id, name FROM t", [][]string{{"1", "NULL"}, {"2", ""}})
+}
+
+func TestDeleteReferencedRows(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE p (id INT PRIMARY KEY)",
+		"CREATE TABLE c (id INT PRIMARY KEY, pid INT REFERENCES p (id) ON DELETE SET NULL, CHECK (pid IS NOT NULL))",
+		"CREATE TABLE d (id INT PRIMARY KEY, pid INT REFERENCES p (id) ON DELETE CASCADE)",
+		"CREATE TABLE e (id INT PRIMARY KEY, did INT REFERENCES d (id))",
+		"CREATE TABLE f (id INT PRIMARY KEY, a INT REFERENCES p (id) ON DELETE SET NULL, b INT REFERENCES d (id) ON DELETE SET NULL, CHECK (a IS NOT NULL OR b IS NOT NULL))",
+		"INSERT INTO p (id) VALUES (1), (2), (3), (4)",
+		"INSERT INTO c (id, pid) VALUES (10, 1)",
+		"INSERT INTO d (id, pid) VALUES (20, 2), (30, 3), (40, 4)",
+		"INSERT INTO e (id, did) VALUES (21, 20)",
+		"INSERT INTO f (id, a, b) VALUES (31, 3, 30), (41, 4, 20)",
+	)
+	tests := []struct {
+		statement string
+		want      string
+	}{
+		{"DELETE FROM p WHERE id = 1", shared.CheckViolation},
+		{"DELETE FROM p WHERE id = 2", shared.ForeignKeyViolation},
+		{"DELETE FROM p WHERE id = 3", shared.CheckViolation},
+	}
+	for _, tt := range tests {
+		if err := queryError(t, db, tt.statement); !strings.Contains(err, tt.want) {
+			t.Errorf("%s: error %q, want %q", tt.statement, err, tt.want)
+		}
+	}
+	checkRows(t, db, "SELECT id FROM p", [][]string{{"1"}, {"2"}, {"3"}, {"4"}})
+	checkRows(t, db, "SELECT id, pid FROM c", [][]string{{"10", "1"}})
+	checkRows(t, db, "SELECT id, pid FROM d", [][]string{{"20", "2"}, {"30", "3"}, {"40", "4"}})
+	exec(t, db, "DELETE FROM p WHERE id = 4")
+	checkRows(t, db, "SELECT id FROM p", [][]string{{"1"}, {"2"}, {"3"}})
+	checkRows(t, db, "SELECT id, pid FROM d", [][]string{{"20", "2"}, {"30", "3"}})
+	checkRows(t, db, "SELECT id, a, b FROM f", [][]string{{"31", "3", "30"}, {"41", "NULL", "20"}})
+}
+
+func TestInsertReferences(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE p (id INT PRIMARY KEY)",
+		"CREATE TABLE c (id INT PRIMARY KEY, pid INT REFERENCES p (id))",
+		"INSERT INTO p (id) VALUES (1)",
+		"INSERT INTO c (id, pid) VALUES (1, 1), (2, NULL)",
+	)
+	tests := []struct {
+		statement string
+		want      string
+	}{
+		{"INSERT INTO c (id, pid) VALUES (3, 2)", shared.ForeignKeyViolation},
+		{"UPDATE c SET pid = 2 WHERE id = 2", shared.ForeignKeyViolation},
+		{"INSERT INTO c (id, pid) VALUES (1, 1) ON CONFLICT (id) DO UPDATE SET pid = excluded.pid", shared.NotSupported},
+	}
+	for _, tt := range tests {
+		if err := queryError(t, db, tt.statement); !strings.Contains(err, tt.want) {
+			t.Errorf("%s: error %q, want %q", tt.statement, err, tt.want)
+		}
+	}
+	// A failed check must not leave the parent locked.
+	exec(t, db, "DELETE FROM c WHERE id = 1", "DELETE FROM p WHERE id = 1")
+	checkRows(t, db, "SELECT id, pid FROM c", [][]string{{"2", "NULL"}})
+}
+
+func TestDistinctNumbers(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE t (i INT, f FLOAT)",
+		"INSERT INTO t (i, f) VALUES (1, 1.5), ('01', '1.50'), ('+1', 1.500), (2, 2), (2, 2.0)",
+	)
+	checkRows(t, db, "SELECT DISTINCT i FROM t", [][]string{{"1"}, {"2"}})
+	checkRows(t, db, "SELECT DISTINCT f FROM t", [][]string{{"1.5"}, {"2"}})
+	checkRows(t, db, "SELECT f, count(*) FROM t GROUP BY f", [][]string{{"1.5", "3"}, {"2", "2"}})
+	checkRows(t, db, "SELECT count(DISTINCT i), count(DISTINCT f) FROM t", [][]string{{"2", "2"}})
+	checkRows(t, db, "SELECT i FROM t WHERE f = 1.5", [][]string{{"1"}, {"1"}, {"1"}})
+}
+
+func TestWhereConditions(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE e (id INT, name TEXT, age INT)",
+		"INSERT INTO e (id, name, age) VALUES (1, 'ann', 30), (2, 'bob', 40), (3, 'cid', NULL), (4, 'dan', 25)",
+	)
+	tests := []struct {
+		where string
+		want  [][]string
+	}{
+		{"age > 26 OR name = 'dan'", [][]string{{"1"}, {"2"}, {"4"}}},
+		{"NOT (age > 26)", [][]string{{"4"}}},
+		{"(id = 1 OR id = 2) AND age >= 40", [][]string{{"2"}}},
+		{"id IN (1, 3, 5)", [][]string{{"1"}, {"3"}}},
+		{"id NOT IN (1, 3)", [][]string{{"2"}, {"4"}}},
+		{"age BETWEEN 25 AND 30", [][]string{{"1"}, {"4"}}},
+		{"age NOT BETWEEN 25 AND 30", [][]string{{"2"}}},
+		{"age IS NULL", [][]string{{"3"}}},
+		{"age IS NOT NULL AND NOT name = 'bob'", [][]string{{"1"}, {"4"}}},
+		{"id = 1 OR id = 2 AND age = 25", [][]string{{"1"}}},
+	}
+	for _, tt := range tests {
+		checkRows(t, db, "SELECT id FROM e WHERE "+tt.where, tt.want)
+	}
+	exec(t, db, "CREATE TABLE empty (id INT)")
+	for _, statement := range []string{
+		"SELECT id FROM empty WHERE 1",
+		"SELECT id FROM e WHERE name",
+		"SELECT id FROM e WHERE age + 1",
+		"SELECT age FROM e GROUP BY age HAVING count(*)",
+		"UPDATE e SET age = 1 WHERE id",
+		"DELETE FROM empty WHERE 'yes'",
+		"SELECT e.id FROM e JOIN empty ON e.id",
+	} {
+		if err := queryError(t, db, statement); !strings.Contains(err, "must be a condition") {
+			t.Errorf("%s: error %q, want a condition error", statement, err)
+		}
+	}
+	checkRows(t, db, "SELECT id FROM e WHERE NULL", [][]string{})
+}
+
+func TestOrderByLimit(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE t (id INT, name TEXT, score FLOAT)",
+		"INSERT INTO t (id, name, score) VALUES (1, 'b', 9.5), (2, 'a', 10), (3, 'b', 2.25), (10, 'c', NULL), (4, 'a', 10)",
+	)
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT id FROM t ORDER BY id", [][]string{{"1"}, {"2"}, {"3"}, {"4"}, {"10"}}},
+		{"SELECT id FROM t ORDER BY id DESC LIMIT 2", [][]string{{"10"}, {"4"}}},
+		{"SELECT id FROM t ORDER BY score", [][]string{{"3"}, {"1"}, {"2"}, {"4"}, {"10"}}},
+		{"SELECT id FROM t ORDER BY name DESC, id ASC", [][]string{{"10"}, {"1"}, {"3"}, {"2"}, {"4"}}},
+		{"SELECT id FROM t ORDER BY score DESC, id DESC LIMIT 3 OFFSET 1", [][]string{{"4"}, {"2"}, {"1"}}},
+		{"SELECT id FROM t LIMIT 2", [][]string{{"1"}, {"2"}}},
+		{"SELECT id FROM t LIMIT 2 OFFSET 4", [][]string{{"4"}}},
+		{"SELECT id FROM t ORDER BY id LIMIT 0", [][]string{}},
+		{"SELECT id FROM t ORDER BY id OFFSET 10", [][]string{}},
+	}
+	for _, tt := range tests {
+		checkRows(t, db, tt.query, tt.want)
+	}
+}
+
+// grant gives user permission on table, as CREATE TABLE does for k3user.
+func grant(t *testing.T, db, table, user string, permission int) {
+	t.Helper()
+	loaded, err := storage.LoadTable(db, table)
+	if err == nil {
+		err = core.GrantPermission(loaded, user, permission)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJoins(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE a (id INT, name TEXT)",
+		"CREATE TABLE b (id INT, aid INT, v FLOAT)",
+		"INSERT INTO a (id, name) VALUES (1, 'one'), (2, 'two'), (3, 'three')",
+		"INSERT INTO b (id, aid, v) VALUES (10, 1, 1.5), (11, 1, 2), (12, 2, 3), (13, 4, 4)",
+	)
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT a.name, b.id FROM a JOIN b ON a.id = b.aid ORDER BY b.id",
+			[][]string{{"one", "10"}, {"one", "11"}, {"two", "12"}}},
+		{"SELECT x.name, y.v FROM a AS x INNER JOIN b y ON y.aid = x.id AND y.v > 1.5 ORDER BY y.v",
+			[][]string{{"one", "2"}, {"two", "3"}}},
+		{"SELECT a.id, b.id FROM a LEFT JOIN b ON a.id = b.aid ORDER BY a.id, b.id",
+			[][]string{{"1", "10"}, {"1", "11"}, {"2", "12"}, {"3", "NULL"}}},
+		{"SELECT a.id, b.id FROM a RIGHT JOIN b ON a.id = b.aid ORDER BY b.id",
+			[][]string{{"1", "10"}, {"1", "11"}, {"2", "12"}, {"NULL", "13"}}},
+		{"SELECT a.id, b.id FROM a FULL JOIN b ON a.id = b.aid ORDER BY a.id, b.id",
+			[][]string{{"1", "10"}, {"1", "11"}, {"2", "12"}, {"3", "NULL"}, {"NULL", "13"}}},
+		{"SELECT a.id, b.id FROM a JOIN b ON b.aid < a.id ORDER BY a.id, b.id",
+			[][]string{{"2", "10"}, {"2", "11"}, {"3", "10"}, {"3", "11"}, {"3", "12"}}},
+		{"SELECT p.id, q.id FROM a p JOIN a q ON q.id = p.id + 1 ORDER BY p.id",
+			[][]string{{"1", "2"}, {"2", "3"}}},
+		{"SELECT a.name FROM a JOIN b ON a.id = b.aid WHERE b.v = 3",
+			[][]string{{"two"}}},
+	}
+	for _, tt := range tests {
+		checkRows(t, db, tt.query, tt.want)
+	}
+	for _, statement := range []string{
+		"SELECT id FROM a JOIN b ON a.id = b.aid",
+		"SELECT a.id FROM a JOIN b ON a.id = c.aid",
+		"SELECT a.id FROM a JOIN a ON a.id = a.id",
+	} {
+		queryError(t, db, statement)
+	}
+	// Every joined table needs the read permission.
+	grant(t, db, "a", "bob", shared.K3Read)
+	if response := querySQL("SELECT a.id FROM a JOIN b ON a.id = b.aid", "bob", db); response.Error != shared.AccessDenied {
+		t.Errorf("join without permission on b: error %q, want %q", response.Error, shared.AccessDenied)
+	}
+	grant(t, db, "b", "bob", shared.K3Read)
+	if response := querySQL("SELECT a.id FROM a JOIN b ON a.id = b.aid", "bob", db); !response.Status {
+		t.Errorf("join with permission on both tables: %s", response.Error)
+	}
+}
+
+func TestSelectExpressions(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE items (name TEXT, price FLOAT, qty INT)",
+		"INSERT INTO items (name, price, qty) VALUES ('pen', 1.5, 4), ('ink', 2.25, 2), ('pen', 1.5, 4), ('cap', 0.5, NULL)",
+	)
+	response := querySQL("SELECT name AS item, price * qty AS total, qty + 1, 'x' AS tag FROM items", shared.CoreUser, db)
+	if want := []string{"item", "total", "qty + 1", "tag"}; !reflect.DeepEqual(response.TableFields, want) {
+		t.Errorf("columns = %q, want %q", response.TableFields, want)
+	}
+	checkRows(t, db, "SELECT name AS item, price * qty AS total, qty + 1, 'x' AS tag FROM items",
+		[][]string{{"pen", "6", "5", "x"}, {"ink", "4.5", "3", "x"}, {"pen", "6", "5", "x"}, {"cap", "NULL", "NULL", "x"}})
+	checkRows(t, db, "SELECT DISTINCT name, price FROM items", [][]string{{"pen", "1.5"}, {"ink", "2.25"}, {"cap", "0.5"}})
+	checkRows(t, db, "SELECT DISTINCT qty FROM items ORDER BY qty", [][]string{{"2"}, {"4"}, {"NULL"}})
+	checkRows(t, db, "SELECT qty / 3, qty % 3, -qty FROM items WHERE name = 'ink'", [][]string{{"0", "2", "-2"}})
+	checkRows(t, db, "SELECT name FROM items WHERE price * qty > 5 ORDER BY name", [][]string{{"pen"}, {"pen"}})
+
+	exec(t, db,
+		"CREATE TABLE big (n INT)",
+		"INSERT INTO big (n) VALUES (9223372036854775807)",
+	)
+	checkRows(t, db, "SELECT n - 1, n / -1 FROM big", [][]string{{"9223372036854775806", "-9223372036854775807"}})
+	for _, statement := range []string{
+		"SELECT n + 1 FROM big",
+		"SELECT n * 2 FROM big",
+		"SELECT -n - 2 FROM big",
+		"SELECT (-n - 1) / -1 FROM big",
+		"SELECT abs(-n - 1) FROM big",
+		"SELECT 1 / (n - n) FROM big",
+	} {
+		if err := queryError(t, db, statement); !strings.Contains(err, shared.InvalidSQLLogic) {
+			t.Errorf("%s: error %q, want %q", statement, err, shared.InvalidSQLLogic)
+		}
+	}
+	exec(t, db, "INSERT INTO big (n) VALUES (1)")
+	if err := queryError(t, db, "SELECT sum(n) FROM big"); !strings.Contains(err, "integer out of range") {
+		t.Errorf("SUM overflow: error %q", err)
+	}
+}
+
+func TestScalarFunctions(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE t (id INT, name TEXT, x FLOAT)",
+		"INSERT INTO t (id, name, x) VALUES (1, '  Ann  ', -2.5), (2, 'bob', 3.45), (3, NULL, NULL)",
+	)
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT upper(name), lower(name), length(name) FROM t WHERE id = 2", [][]string{{"BOB", "bob", "3"}}},
+		{"SELECT trim(name), substr('abcdef', 2, 3), concat('a', id, 'b') FROM t WHERE id = 1", [][]string{{"Ann", "bcd", "a1b"}}},
+		{"SELECT replace('a-b-c', '-', '+'), abs(x), round(x), floor(x), ceil(x) FROM t WHERE id = 1",
+			[][]string{{"a+b+c", "2.5", "-3", "-3", "-2"}}},
+		{"SELECT round(x, 1) FROM t WHERE id = 2", [][]string{{"3.5"}}},
+		{"SELECT coalesce(name, 'none'), nullif(id, 3) FROM t WHERE id = 3", [][]string{{"none", "NULL"}}},
+		{"SELECT CASE WHEN id = 1 THEN 'one' WHEN id = 2 THEN 'two' ELSE 'many' END FROM t",
+			[][]string{{"one"}, {"two"}, {"many"}}},
+		{"SELECT CASE id WHEN 2 THEN 'b' END FROM t", [][]string{{"NULL"}, {"b"}, {"NULL"}}},
+		{"SELECT CAST(id AS TEXT), CAST('42' AS INT) + 1, CAST(x AS INT) FROM t WHERE id = 2", [][]string{{"2", "43", "3"}}},
+		{"SELECT id FROM t WHERE upper(trim(name)) = 'ANN'", [][]string{{"1"}}},
+		{"SELECT id FROM t ORDER BY length(coalesce(name, '')) DESC", [][]string{{"1"}, {"2"}, {"3"}}},
+	}
+	for _, tt := range tests {
+		checkRows(t, db, tt.query, tt.want)
+	}
+	exec(t, db, "UPDATE t SET name = upper(coalesce(name, 'z')) WHERE id > 1")
+	checkRows(t, db, "SELECT name FROM t WHERE id > 1", [][]string{{"BOB"}, {"Z"}})
+	for _, statement := range []string{
+		"SELECT upper(id) FROM t",
+		"SELECT abs(name) FROM t",
+		"SELECT nope(id) FROM t",
+		"SELECT substr(name) FROM t",
+	} {
+		queryError(t, db, statement)
+	}
+}
+
+func TestSubqueries(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE c (id INT, name TEXT)",
+		"CREATE TABLE o (id INT, cid INT, total INT)",
+		"INSERT INTO c (id, name) VALUES (1, 'ann'), (2, 'bob'), (3, 'cid')",
+		"INSERT INTO o (id, cid, total) VALUES (10, 1, 5), (11, 1, 7), (12, 2, 20)",
+	)
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT name FROM c WHERE id IN (SELECT cid FROM o)", [][]string{{"ann"}, {"bob"}}},
+		{"SELECT name FROM c WHERE id NOT IN (SELECT cid FROM o WHERE total > 10)", [][]string{{"ann"}, {"cid"}}},
+		{"SELECT name FROM c WHERE EXISTS (SELECT id FROM o WHERE o.cid = c.id AND o.total < 10)", [][]string{{"ann"}}},
+		{"SELECT name FROM c WHERE NOT EXISTS (SELECT id FROM o WHERE o.cid = c.id)", [][]string{{"cid"}}},
+		{"SELECT name, (SELECT max(total) FROM o WHERE o.cid = c.id) FROM c", [][]string{{"ann", "7"}, {"bob", "20"}, {"cid", "NULL"}}},
+		{"SELECT id FROM o WHERE total > (SELECT avg(total) FROM o)", [][]string{{"12"}}},
+		{"SELECT id FROM o WHERE total = (SELECT total FROM o WHERE id = 99)", [][]string{}},
+	}
+	for _, tt := range tests {
+		checkRows(t, db, tt.query, tt.want)
+	}
+	if err := queryError(t, db, "SELECT id FROM c WHERE id = (SELECT cid FROM o)"); !strings.Contains(err, "more than one row") {
+		t.Errorf("scalar subquery with many rows: error %q", err)
+	}
+	queryError(t, db, "SELECT id FROM c WHERE id IN (SELECT id, cid FROM o)")
+	exec(t, db, "DELETE FROM o WHERE cid IN (SELECT id FROM c WHERE name = 'ann')")
+	checkRows(t, db, "SELECT id FROM o", [][]string{{"12"}})
+}
+
+func TestSetOperations(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE a (n INT, s TEXT)",
+		"CREATE TABLE b (n INT)",
+		"INSERT INTO a (n, s) VALUES (1, 'x'), (2, 'y'), (2, 'y'), (3, NULL)",
+		"INSERT INTO b (n) VALUES (2), (3), (4), (4)",
+	)
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT n FROM a UNION SELECT n FROM b ORDER BY n", [][]string{{"1"}, {"2"}, {"3"}, {"4"}}},
+		{"SELECT n FROM a UNION ALL SELECT n FROM b ORDER BY n", [][]string{{"1"}, {"2"}, {"2"}, {"2"}, {"3"}, {"3"}, {"4"}, {"4"}}},
+		{"SELECT n FROM a INTERSECT SELECT n FROM b ORDER BY n", [][]string{{"2"}, {"3"}}},
+		{"SELECT n FROM a EXCEPT SELECT n FROM b", [][]string{{"1"}}},
+		{"SELECT n FROM b EXCEPT ALL SELECT n FROM a ORDER BY n", [][]string{{"4"}, {"4"}}},
+		{"SELECT n FROM a INTERSECT ALL SELECT n FROM a WHERE n = 2", [][]string{{"2"}, {"2"}}},
+		{"SELECT n FROM a UNION SELECT n FROM b ORDER BY n DESC LIMIT 2", [][]string{{"4"}, {"3"}}},
+		{"SELECT s FROM a WHERE s IS NULL UNION SELECT s FROM a WHERE n = 3", [][]string{{"NULL"}}},
+		{"SELECT n FROM a UNION SELECT n FROM b EXCEPT SELECT n FROM b WHERE n > 2 ORDER BY n", [][]string{{"1"}, {"2"}}},
+	}
+	for _, tt := range tests {
+		checkRows(t, db, tt.query, tt.want)
+	}
+	queryError(t, db, "SELECT n, s FROM a UNION SELECT n FROM b")
+	queryError(t, db, "SELECT s FROM a UNION SELECT n FROM b")
+}
+
+func TestRecursiveCTE(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE e (id INT, boss INT)",
+		"INSERT INTO e (id, boss) VALUES (1, NULL), (2, 1), (3, 2), (4, 1), (5, 5)",
+		"CREATE TABLE one (f FLOAT)",
+		"INSERT INTO one (f) VALUES (1e21)",
+	)
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"WITH low AS (SELECT id FROM e WHERE id < 3) SELECT id FROM low ORDER BY id", [][]string{{"1"}, {"2"}}},
+		{"WITH RECURSIVE n (x) AS (SELECT 1 FROM one UNION ALL SELECT x + 1 FROM n WHERE x < 4) SELECT x FROM n", [][]string{{"1"}, {"2"}, {"3"}, {"4"}}},
+		{"WITH RECURSIVE t (id) AS (SELECT id FROM e WHERE boss = 1 UNION SELECT e.id FROM e JOIN t ON e.boss = t.id) SELECT id FROM t ORDER BY id", [][]string{{"2"}, {"3"}, {"4"}}},
+		{"WITH RECURSIVE t (id) AS (SELECT 5 FROM one UNION SELECT e.id FROM e JOIN t ON e.boss = t.id) SELECT id FROM t", [][]string{{"5"}}},
+		{"WITH RECURSIVE f (x) AS (SELECT f FROM one UNION SELECT x * 1 FROM f) SELECT count(*) FROM f", [][]string{{"1"}}},
+	}
+	for _, tt := range tests {
+		checkRows(t, db, tt.query, tt.want)
+	}
+	if err := queryError(t, db, "WITH RECURSIVE n (x) AS (SELECT 1 FROM one UNION ALL SELECT x + 1 FROM n) SELECT x FROM n"); !strings.Contains(err, "iterations") {
+		t.Errorf("unbounded recursion: error %q", err)
+	}
+}
+
+func TestUpdateExpressions(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE acct (id INT, a INT, b INT, note TEXT)",
+		"INSERT INTO acct (id, a, b, note) VALUES (1, 10, 3, 'x'), (2, 20, 4, NULL)",
+		"UPDATE acct SET a = a - b WHERE id = 1",
+	)
+	checkRows(t, db, "SELECT a FROM acct ORDER BY id", [][]string{{"7"}, {"20"}})
+	exec(t, db, "UPDATE acct SET a = b, b = a")
+	checkRows(t, db, "SELECT a, b FROM acct ORDER BY id", [][]string{{"3", "7"}, {"4", "20"}})
+	exec(t, db, "UPDATE acct SET note = upper(note) || '!'")
+	checkRows(t, db, "SELECT note FROM acct ORDER BY id", [][]string{{"X!"}, {"NULL"}})
+	exec(t, db, "UPDATE acct SET b = b * 2 + id WHERE a > 3")
+	checkRows(t, db, "SELECT b FROM acct ORDER BY id", [][]string{{"7"}, {"42"}})
+	queryError(t, db, "UPDATE acct SET a = note")
+	checkRows(t, db, "SELECT a FROM acct ORDER BY id", [][]string{{"3"}, {"4"}})
+}
+
+func TestUpdateTypeValidation(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE v (id INT, n INT, f FLOAT, d DATE)",
+		"INSERT INTO v (id, n, f, d) VALUES (1, 5, 1.5, '2024-01-31')",
+	)
+	for _, stmt := range []string{
+		"UPDATE v SET n = 'abc'",
+		"UPDATE v SET n = 1.5",
+		"UPDATE v SET f = 'x'",
+		"UPDATE v SET d = '2024-02-30'",
+	} {
+		queryError(t, db, stmt)
+	}
+	if err := queryError(t, db, "UPDATE v SET missing = 1"); !strings.Contains(err, "missing") {
+		t.Errorf("unknown column: error %q", err)
+	}
+	checkRows(t, db, "SELECT id, n, f, d FROM v", [][]string{{"1", "5", "1.5", "2024-01-31"}})
+	exec(t, db, "UPDATE v SET n = '7', f = 2, d = '2024-02-29'")
+	checkRows(t, db, "SELECT n, f, d FROM v", [][]string{{"7", "2", "2024-02-29"}})
+}
+
+func TestInsertFromSelect(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE src (id INT, name TEXT)",
+		"INSERT INTO src (id, name) VALUES (1, 'ann'), (2, NULL), (3, 'cid')",
+		"CREATE TABLE dst (id INT, name TEXT)",
+		"INSERT INTO dst (name, id) SELECT name, id * 10 FROM src WHERE id > 1",
+	)
+	checkRows(t, db, "SELECT id, name FROM dst ORDER BY id", [][]string{{"20", "NULL"}, {"30", "cid"}})
+	queryError(t, db, "INSERT INTO dst (id) SELECT name FROM src")
+	queryError(t, db, "INSERT INTO dst (id, name) SELECT id FROM src")
+
+	if response := querySQL("CREATE TABLE copy AS SELECT id, name FROM src WHERE id < 3", "k3user", db); !response.Status {
+		t.Fatalf("CREATE TABLE AS SELECT: %s", response.Error)
+	}
+	checkRows(t, db, "SELECT id, name FROM copy ORDER BY id", [][]string{{"1", "ann"}, {"2", "NULL"}})
+
+	// The rows are written as the requesting user, who must hold the write
+	// permission on the new table.
+	grant(t, db, "src", "bob", shared.K3Read)
+	if response := querySQL("CREATE TABLE stolen AS SELECT id FROM src", "bob", db); response.Error != shared.AccessDenied {
+		t.Errorf("CREATE TABLE AS SELECT without write permission: error %q, want %q", response.Error, shared.AccessDenied)
+	}
+	queryError(t, db, "SELECT id FROM stolen")
+}
+
+func TestReturning(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db, "CREATE TABLE r (id INT, n INT, s TEXT)")
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"INSERT INTO r (id, n) VALUES (1, 10), (2, 20) RETURNING *", [][]string{{"1", "10", "NULL"}, {"2", "20", "NULL"}}},
+		{"INSERT INTO r (id, n, s) VALUES (3, 30, 'c') RETURNING s, id", [][]string{{"c", "3"}}},
+		{"UPDATE r SET n = n + 1 WHERE id < 3 RETURNING id, n", [][]string{{"1", "11"}, {"2", "21"}}},
+		{"UPDATE r SET n = 0 WHERE id = 9 RETURNING *", [][]string{}},
+		{"DELETE FROM r WHERE n > 20 RETURNING id, s", [][]string{{"2", "NULL"}, {"3", "c"}}},
+	}
+	for _, tt := range tests {
+		checkRows(t, db, tt.query, tt.want)
+	}
+	checkRows(t, db, "SELECT id, n FROM r", [][]string{{"1", "11"}})
+	queryError(t, db, "DELETE FROM r RETURNING missing")
+	checkRows(t, db, "SELECT id FROM r", [][]string{{"1"}})
+}
+
+func TestAlterColumnType(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE a (id INT, v INT CHECK (v > 5), s TEXT DEFAULT 'abc', n TEXT)",
+		"INSERT INTO a (id, v, n) VALUES (1, 6, '10'), (2, 7, NULL)",
+	)
+	// The CHECK compares v with a number, which a TEXT column cannot do.
+	if err := queryError(t, db, "ALTER TABLE a ALTER COLUMN v TYPE TEXT"); !strings.Contains(err, "a_v_check") {
+		t.Errorf("CHECK that no longer fits: error %q", err)
+	}
+	queryError(t, db, "ALTER TABLE a ALTER COLUMN s TYPE INT")
+	exec(t, db,
+		"ALTER TABLE a ALTER COLUMN v TYPE FLOAT",
+		"ALTER TABLE a ALTER COLUMN n TYPE INT",
+	)
+	queryError(t, db, "INSERT INTO a (id, v) VALUES (3, 4.5)")
+	exec(t, db, "INSERT INTO a (id, v) VALUES (3, 6.25)")
+	checkRows(t, db, "SELECT id, v, s, n FROM a ORDER BY id", [][]string{{"1", "6", "abc", "10"}, {"2", "7", "abc", "NULL"}, {"3", "6.25", "abc", "NULL"}})
+	queryError(t, db, "SELECT id FROM a WHERE n = 'x'")
+
+	// Converted rows are checked against the constraints again.
+	exec(t, db,
+		"CREATE TABLE b (f FLOAT CHECK (f <> 2))",
+		"INSERT INTO b (f) VALUES (1.5), (2.5)",
+	)
+	if err := queryError(t, db, "ALTER TABLE b ALTER COLUMN f TYPE INT"); !strings.Contains(err, "b_f_check") {
+		t.Errorf("converted row that violates CHECK: error %q", err)
+	}
+	checkRows(t, db, "SELECT f FROM b ORDER BY f", [][]string{{"1.5"}, {"2.5"}})
+
+	exec(t, db,
+		"CREATE TABLE c (k INT CHECK (CASE WHEN k > 0 THEN k ELSE 0 END + abs(-k) IN (2, 4) AND NOT k BETWEEN 5 AND 9))",
+		"INSERT INTO c (k) VALUES (1), (NULL)",
+		"ALTER TABLE c ALTER COLUMN k TYPE DECIMAL",
+		"INSERT INTO c (k) VALUES (2.0)",
+	)
+	queryError(t, db, "INSERT INTO c (k) VALUES (1.5)")
+}
+
+func TestUniqueConstraints(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE u (id INT PRIMARY KEY, email TEXT UNIQUE, a INT, b INT, UNIQUE (a, b))",
+		"INSERT INTO u (id, email, a, b) VALUES (1, 'x@k3', 1, 1), (2, NULL, 1, 2), (3, NULL, 1, NULL), (4, NULL, 1, NULL)",
+	)
+	for _, stmt := range []string{
+		"INSERT INTO u (id, email) VALUES (1, 'y@k3')",
+		"INSERT INTO u (id, email) VALUES (5, 'x@k3')",
+		"INSERT INTO u (id, a, b) VALUES (5, 1, 2)",
+		"INSERT INTO u (id) VALUES (6), (6)",
+		"INSERT INTO u (id) VALUES (NULL)",
+		"UPDATE u SET email = 'x@k3' WHERE id = 2",
+		"UPDATE u SET id = 1 WHERE id = 2",
+		"UPDATE u SET id = 9",
+	} {
+		if err := queryError(t, db, stmt); !strings.HasPrefix(err, shared.UniqueViolation) && !strings.HasPrefix(err, shared.NotNullViolation) {
+			t.Errorf("%s: error %q", stmt, err)
+		}
+	}
+	checkRows(t, db, "SELECT id, email FROM u ORDER BY id", [][]string{{"1", "x@k3"}, {"2", "NULL"}, {"3", "NULL"}, {"4", "NULL"}})
+	exec(t, db,
+		"UPDATE u SET id = id + 10",
+		"UPDATE u SET email = 'y@k3' WHERE id = 11",
+		"INSERT INTO u (id, email) VALUES (1, 'x@k3')",
+	)
+	checkRows(t, db, "SELECT id, email FROM u ORDER BY id", [][]string{{"1", "x@k3"}, {"11", "y@k3"}, {"12", "NULL"}, {"13", "NULL"}, {"14", "NULL"}})
+	queryError(t, db, "CREATE TABLE two (a INT PRIMARY KEY, b INT PRIMARY KEY)")
+}
+
+func TestColumnConstraints(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE c (id INT NOT NULL, qty INT DEFAULT 1 CHECK (qty >= 0), note TEXT, at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, CHECK (qty < 100 OR note IS NOT NULL))",
+		"INSERT INTO c (id) VALUES (1)",
+		"INSERT INTO c (id, qty, note) VALUES (2, NULL, NULL), (3, 150, 'bulk')",
+	)
+	checkRows(t, db, "SELECT id, qty, note FROM c WHERE at IS NOT NULL ORDER BY id",
+		[][]string{{"1", "1", "NULL"}, {"2", "NULL", "NULL"}, {"3", "150", "bulk"}})
+	tests := []struct {
+		stmt string
+		code string
+	}{
+		{"INSERT INTO c (qty) VALUES (2)", shared.NotNullViolation},
+		{"INSERT INTO c (id, qty) VALUES (4, -1)", shared.CheckViolation},
+		{"INSERT INTO c (id, qty) VALUES (4, 100)", shared.CheckViolation},
+		{"UPDATE c SET id = NULL WHERE id = 1", shared.NotNullViolation},
+		{"UPDATE c SET qty = qty - 2 WHERE id = 1", shared.CheckViolation},
+		{"UPDATE c SET note = NULL WHERE id = 3", shared.CheckViolation},
+	}
+	for _, tt := range tests {
+		if err := queryError(t, db, tt.stmt); !strings.HasPrefix(err, tt.code) {
+			t.Errorf("%s: error %q, want %q", tt.stmt, err, tt.code)
+		}
+	}
+	checkRows(t, db, "SELECT id, qty, note FROM c ORDER BY id", [][]string{{"1", "1", "NULL"}, {"2", "NULL", "NULL"}, {"3", "150", "bulk"}})
+	for _, stmt := range []string{
+		"CREATE TABLE bad (n INT DEFAULT 'x')",
+		"CREATE TABLE bad (n INT CHECK (n + 1))",
+		"CREATE TABLE bad (n INT CHECK (m > 0))",
+		"CREATE TABLE bad (n INT DEFAULT 1 DEFAULT 2)",
+	} {
+		queryError(t, db, stmt)
+	}
+}
+
+func TestReferencedKeyChanges(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE p (id INT PRIMARY KEY, name TEXT)",
+		"CREATE TABLE c (id INT PRIMARY KEY, pid INT REFERENCES p (id) ON DELETE CASCADE)",
+		"CREATE TABLE t (id INT PRIMARY KEY, boss INT REFERENCES t (id) ON DELETE CASCADE)",
+		"INSERT INTO p (id, name) VALUES (1, 'a'), (2, 'b'), (3, 'c')",
+		"INSERT INTO c (id, pid) VALUES (10, 1), (11, 1), (20, 2)",
+		"INSERT INTO t (id, boss) VALUES (1, NULL), (2, 1), (3, 2), (4, NULL)",
+	)
+	if err := queryError(t, db, "UPDATE p SET id = id + 100"); !strings.HasPrefix(err, shared.ForeignKeyViolation) {
+		t.Errorf("update of referenced keys: error %q", err)
+	}
+	if err := queryError(t, db, "UPDATE c SET pid = 4 WHERE id = 10"); !strings.HasPrefix(err, shared.ForeignKeyViolation) {
+		t.Errorf("update to a missing key: error %q", err)
+	}
+	checkRows(t, db, "SELECT id FROM p ORDER BY id", [][]string{{"1"}, {"2"}, {"3"}})
+	exec(t, db,
+		"UPDATE p SET id = 4, name = 'd' WHERE id = 3",
+		"UPDATE c SET pid = 4 WHERE id = 20",
+		"UPDATE t SET id = 5, boss = 5 WHERE id = 4",
+	)
+	checkRows(t, db, "SELECT id, name FROM p ORDER BY id", [][]string{{"1", "a"}, {"2", "b"}, {"4", "d"}})
+	checkRows(t, db, "SELECT id, boss FROM t WHERE id > 3", [][]string{{"5", "5"}})
+
+	// The condition reads the child table, which the delete locks afterwards.
+	checkRows(t, db, "DELETE FROM p WHERE NOT EXISTS (SELECT id FROM c WHERE c.pid = p.id) RETURNING id", [][]string{{"2"}})
+	exec(t, db, "DELETE FROM p WHERE id = 1", "DELETE FROM t WHERE id = 1")
+	checkRows(t, db, "SELECT id, pid FROM c", [][]string{{"20", "4"}})
+	checkRows(t, db, "SELECT id, boss FROM t", [][]string{{"5", "5"}})
+}
+
+// A delete is written as a whole or not at all, even when the rows it
+// reaches through several foreign keys only fail a constraint together.
+func TestDeleteReferencedRowsAtomic(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE p (id INT PRIMARY KEY)",
+		"CREATE TABLE q (id INT PRIMARY KEY, pid INT REFERENCES p (id) ON DELETE CASCADE)",
+		"CREATE TABLE r (id INT PRIMARY KEY, pid INT REFERENCES p (id) ON DELETE SET NULL, qid INT REFERENCES q (id) ON DELETE SET NULL, CHECK (pid IS NOT NULL OR qid IS NOT NULL))",
+		"INSERT INTO p (id) VALUES (1), (2)",
+		"INSERT INTO q (id, pid) VALUES (10, 1), (20, 2)",
+		"INSERT INTO r (id, pid, qid) VALUES (100, 1, 10), (200, 1, 20)",
+	)
+	if err := queryError(t, db, "DELETE FROM p WHERE id = 1"); !strings.HasPrefix(err, shared.CheckViolation) {
+		t.Errorf("delete leaving a row without both keys: error %q", err)
+	}
+	checkRows(t, db, "SELECT id FROM p ORDER BY id", [][]string{{"1"}, {"2"}})
+	checkRows(t, db, "SELECT id FROM q ORDER BY id", [][]string{{"10"}, {"20"}})
+	checkRows(t, db, "SELECT id, pid, qid FROM r ORDER BY id", [][]string{{"100", "1", "10"}, {"200", "1", "20"}})
+	exec(t, db, "DELETE FROM r WHERE id = 100", "DELETE FROM p WHERE id = 1")
+	checkRows(t, db, "SELECT id FROM q", [][]string{{"20"}})
+	checkRows(t, db, "SELECT id, pid, qid FROM r", [][]string{{"200", "NULL", "20"}})
+}
+
+// Children inserted while their parents are deleted either fail or keep a
+// parent, never end up referencing a deleted row.
+func TestConcurrentReferences(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE p (id INT PRIMARY KEY)",
+		"CREATE TABLE c (id INT PRIMARY KEY, pid INT REFERENCES p (id) ON DELETE CASCADE)",
+	)
+	const n = 50
+	for i := range n {
+		exec(t, db, fmt.Sprintf("INSERT INTO p (id) VALUES (%d)", i))
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := range n {
+			querySQL(fmt.Sprintf("DELETE FROM p WHERE id = %d", i), shared.CoreUser, db)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := range n {
+			querySQL(fmt.Sprintf("INSERT INTO c (id, pid) VALUES (%d, %d)", i, i), shared.CoreUser, db)
+		}
+	}()
+	wg.Wait()
+	checkRows(t, db, "SELECT id FROM c WHERE pid NOT IN (SELECT id FROM p)", [][]string{})
+}
+
+func TestNullLogic(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE n (id INT, v INT, s TEXT)",
+		"INSERT INTO n (id, v, s) VALUES (1, 1, 'a'), (2, NULL, ''), (3, 3, NULL)",
+	)
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT id FROM n WHERE v = NULL", [][]string{}},
+		{"SELECT id FROM n WHERE v <> 1", [][]string{{"3"}}},
+		{"SELECT id FROM n WHERE NOT (v = 1)", [][]string{{"3"}}},
+		{"SELECT id FROM n WHERE v IS NULL", [][]string{{"2"}}},
+		{"SELECT id FROM n WHERE s IS NOT NULL ORDER BY id", [][]string{{"1"}, {"2"}}},
+		{"SELECT id FROM n WHERE s = ''", [][]string{{"2"}}},
+		{"SELECT id FROM n WHERE v = 1 OR v IS NULL ORDER BY id", [][]string{{"1"}, {"2"}}},
+		{"SELECT id FROM n WHERE NOT (v = 1 AND s = 'a') ORDER BY id", [][]string{{"2"}, {"3"}}},
+		{"SELECT id FROM n WHERE NOT (v = 3 OR s = 'a')", [][]string{}},
+		{"SELECT id FROM n WHERE v IN (1, NULL)", [][]string{{"1"}}},
+		{"SELECT id FROM n WHERE v NOT IN (1, NULL)", [][]string{}},
+		{"SELECT id FROM n WHERE id NOT IN (SELECT v FROM n)", [][]string{}},
+		{"SELECT count(*), count(v), sum(v), avg(v), min(s), max(s) FROM n", [][]string{{"3", "2", "4", "2", "", "a"}}},
+		{"SELECT sum(v) FROM n WHERE v IS NULL", [][]string{{"NULL"}}},
+		{"SELECT v FROM n ORDER BY v", [][]string{{"1"}, {"3"}, {"NULL"}}},
+		{"SELECT v FROM n ORDER BY v DESC", [][]string{{"NULL"}, {"3"}, {"1"}}},
+		{"SELECT v + 1, s || 'x' FROM n WHERE id = 2", [][]string{{"NULL", "x"}}},
+		{"SELECT coalesce(v, 0) FROM n ORDER BY id", [][]string{{"1"}, {"0"}, {"3"}}},
+	}
+	for _, tt := range tests {
+		checkRows(t, db, tt.query, tt.want)
+	}
+}
+
+func TestFloatValues(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE f (id INT, x FLOAT, d DECIMAL)",
+		"INSERT INTO f (id, x, d) VALUES (1, 1e21, 2.5), (2, 0.5, 1.25)",
+	)
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT x FROM f WHERE id = 1", [][]string{{"1e+21"}}},
+		{"SELECT x * 1 FROM f WHERE id = 1", [][]string{{"1e+21"}}},
+		{"SELECT x FROM f WHERE id = 1 UNION SELECT x * 1 FROM f WHERE id = 1", [][]string{{"1e+21"}}},
+		{"SELECT sum(x), avg(x) FROM f WHERE id = 1", [][]string{{"1e+21", "1e+21"}}},
+		{"SELECT abs(-x), CAST(x AS TEXT) FROM f WHERE id = 1", [][]string{{"1e+21", "1e+21"}}},
+		{"SELECT round(x, 400), round(x, -400) FROM f WHERE id = 2", [][]string{{"0.5", "0"}}},
+		{"SELECT round(d, 100000000), round(d, -100000000) FROM f WHERE id = 2", [][]string{{"1.25", "0"}}},
+	}
+	for _, tt := range tests {
+		checkRows(t, db, tt.query, tt.want)
+	}
+	for _, stmt := range []string{
+		"INSERT INTO f (id, x) VALUES (3, 'NaN')",
+		"INSERT INTO f (id, x) VALUES (3, 'Infinity')",
+		"SELECT x * x FROM f WHERE id = 1 AND x * x * x * x * x * x * x * x * x * x * x * x * x * x * x > 0",
+		"SELECT CAST('NaN' AS FLOAT) FROM f",
+	} {
+		queryError(t, db, stmt)
+	}
+	exec(t, db, "INSERT INTO f (id, x) VALUES (3, 1e300), (4, 1e300)")
+	if err := queryError(t, db, "SELECT sum(x * 1e8) FROM f WHERE id > 2"); !strings.Contains(err, "out of range") {
+		t.Errorf("FLOAT sum overflow: error %q", err)
+	}
+}
+
+func TestTypeComparisons(t *testing.T) {
+	db := testDatabase(t)
+	exec(t, db,
+		"CREATE TABLE c (id INT, n INT, x FLOAT, s TEXT, d DATE, ts TIMESTAMP)",
+		"INSERT INTO c (id, n, x, s, d, ts) VALUES (1, 10, 9.5, '10', '2024-02-01', '2024-02-01 10:00:00'), (2, 9, 10.5, '9', '2023-12-31', '2024-02-01 09:30:00')",
+	)
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT id FROM c WHERE n > 9", [][]string{{"1"}}},
+		{"SELECT id FROM c WHERE n > '9'", [][]string{{"1"}}},
+		{"SELECT id FROM c WHERE n > x", [][]string{{"1"}}},
+		{"SELECT id FROM c WHERE x = 10.5", [][]string{{"2"}}},
+		{"SELECT id FROM c WHERE s > '9'", [][]string{}},
+		{"SELECT id FROM c WHERE s < '9'", [][]string{{"1"}}},
+		{"SELECT s FROM c ORDER BY s", [][]string{{"10"}, {"9"}}},
+		{"SELECT n FROM c ORDER BY n", [][]string{{"9"}, {"10"}}},
+		{"SELECT id FROM c WHERE d < '2024-01-01'", [][]string{{"2"}}},
+		{"SELECT id FROM c ORDER BY d", [][]string{{"2"}, {"1"}}},
+		{"SELECT id FROM c WHERE ts > '2024-02-01 09:45:00'", [][]string{{"1"}}},
+		{"SELECT id FROM c WHERE d BETWEEN '2024-01-01' AND '2024-12-31'", [][]string{{"1"}}},
+	}
+	for _, tt := range tests {
+		checkRows(t, db, tt.query, tt.want)
+	}
+	for _, stmt := range []string{
+		"SELECT id FROM c WHERE n = s",
+		"SELECT id FROM c WHERE d > n",
+		"SELECT id FROM c WHERE x IN (1, s)",
+	} {
+		if err := queryError(t, db, stmt); !strings.Contains(err, "cannot compare") {
+			t.Errorf("%s: error %q", stmt, err)
+		}
+	}
+	queryError(t, db, "SELECT id FROM c WHERE n = 'abc'")
+	queryError(t, db, "SELECT id FROM c WHERE d = '2024-13-01'")
+}
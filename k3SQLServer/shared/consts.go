@@ -12,6 +12,22 @@ const K3FilesPath = "/opt/k3SQL/"
 const K3ConfigurationPath = K3FilesPath + "config/"
 const K3DataPath = K3FilesPath + "data/"
 const Extension = ".k3"
+const SchemaExtension = ".k3s"
+const NullMarker = "\\N"
+
+// FormatVersion is the version of the table file format; version 1 stored
+// TEXT values unescaped.
+const FormatVersion = 2
+const FormatFile = K3ConfigurationPath + "format"
+
+// MigrationFile marks a migration whose converted table files are all written
+// and only wait to replace the old ones.
+const MigrationFile = K3ConfigurationPath + "migrating"
+
+// MaxDecimalPrecision is the largest precision a DECIMAL type may declare and
+// the most digits ROUND keeps or drops.
+const MaxDecimalPrecision = 1000
+
 const K3ServiceTablesPrefix = "k3_"
 const K3UsersTable = K3ServiceTablesPrefix + "users"
 const K3TablesTable = K3ServiceTablesPrefix + "tables"
@@ -25,6 +41,7 @@ const K3Read = 2
 // ERROR MESSAGES
 const TableNotExists = "table does not exists"
 const TableAlreadyExists = "table already exists"
+const ColumnNotExists = "column does not exists"
 const DatabaseNotExists = "database does not exists"
 const DatabaseAlreadyExists = "database already exists"
 const InvalidSQLSyntax = "SQL syntax error"
@@ -35,6 +52,11 @@ const UserNotFound = "user not found"
 const InvalidAuthFormat = "invalid auth format"
 const WrongPassword = "wrong password"
 const UnknownAction = "unknown action"
+const NotSupported = "not supported"
+const UniqueViolation = "unique constraint violation"
+const NotNullViolation = "not null constraint violation"
+const CheckViolation = "check constraint violation"
+const ForeignKeyViolation = "foreign key constraint violation"
 
 // DEFAULT DATABASE NAME
 const DatabaseDefaultName = "k3db"
@@ -43,6 +65,67 @@ const DatabaseDefaultName = "k3db"
 const K3INT = 1
 const K3FLOAT = 2
 const K3TEXT = 3
+const K3BOOLEAN = 4
+const K3DATE = 5
+const K3TIMESTAMP = 6
+const K3DECIMAL = 7
+const K3UUID = 8
+const K3BLOB = 9
+const K3JSON = 10
+
+// EXPRESSION KINDS
+const K3ExprColumn = 1
+const K3ExprLiteral = 2
+const K3ExprNull = 3
+const K3ExprAnd = 4
+const K3ExprOr = 5
+const K3ExprNot = 6
+const K3ExprCompare = 7
+const K3ExprLike = 8
+const K3ExprIn = 9
+const K3ExprBetween = 10
+const K3ExprIsNull = 11
+const K3ExprAggregate = 12
+const K3ExprArithmetic = 13
+const K3ExprConcat = 14
+const K3ExprNegate = 15
+const K3ExprFunction = 16
+const K3ExprCase = 17
+const K3ExprCast = 18
+const K3ExprExists = 19
+const K3ExprSubquery = 20
+const K3ExprWindow = 21
+
+// OUTER COLUMN REFERENCES
+const K3OuterPrefix = "^"
+
+// JOIN TYPES
+const K3InnerJoin = 0
+const K3LeftJoin = 1
+const K3RightJoin = 2
+const K3FullJoin = 3
+const K3CrossJoin = 4
+
+// ALTER ACTIONS
+const K3AlterAddColumn = 0
+const K3AlterDropColumn = 1
+const K3AlterRenameColumn = 2
+const K3AlterColumnType = 3
+const K3AlterRenameTable = 4
+
+// CONSTRAINT KINDS
+const K3PrimaryKey = 0
+const K3Unique = 1
+const K3NotNull = 2
+const K3Default = 3
+const K3Check = 4
+const K3ForeignKey = 5
+
+// REFERENTIAL ACTIONS
+const K3NoAction = 0
+const K3Restrict = 1
+const K3Cascade = 2
+const K3SetNull = 3
 
 // VALUES ACTION
 const K3DELETE = 1
@@ -50,48 +133,125 @@ const K3CREATE = 0
 
 type K3SelectQuery struct {
 	Table      *K3Table
-	Values     []string
-	Conditions []K3Condition
+	Alias      string
+	Joins      []K3Join
+	Distinct   bool
+	Values     []*K3Expr
+	Columns    []string
+	Where      *K3Expr
+	GroupBy    []*K3Expr
+	Having     *K3Expr
+	Aggregates []*K3Expr
+	Windows    []*K3Expr
+	OrderBy    []K3OrderBy
+	HasLimit   bool
+	Limit      int
+	Offset     int
+	Correlated bool
+	SetOp      *K3SetOperation
+	With       []*K3CommonTable
 	User       string
 }
 
+type K3SetOperation struct {
+	Operator string
+	All      bool
+	Left     *K3SelectQuery
+	Right    *K3SelectQuery
+}
+
+type K3OrderBy struct {
+	Expr       *K3Expr
+	Desc       bool
+	NullsFirst bool
+}
+
 type K3DeleteQuery struct {
-	Table      *K3Table
-	Conditions []K3Condition
-	User       string
+	Table     *K3Table
+	Where     *K3Expr
+	Returning *K3SelectQuery
+	Returned  []map[string]string
+	User      string
 }
 
 type K3UpdateQuery struct {
-	Table      *K3Table
-	SetValues  map[string]string
-	Conditions []K3Condition
-	User       string
+	Table     *K3Table
+	SetValues map[string]*K3Expr
+	Where     *K3Expr
+	Returning *K3SelectQuery
+	Returned  []map[string]string
+	User      string
 }
 
-type K3Condition struct {
-	Column   string
+type K3Expr struct {
+	Kind     int
+	Type     int
 	Operator string
+	Not      bool
+	Distinct bool
+	Table    string
+	Column   string
 	Value    string
+	Args     []*K3Expr
+	Subquery *K3SelectQuery
+	Window   *K3Window
 }
 
-type K3join struct {
-	src       string
-	dst       string
-	condition string
-	typeJoin  int
+type K3Window struct {
+	PartitionBy []*K3Expr
+	OrderBy     []K3OrderBy
+}
+
+type K3Join struct {
+	Table *K3Table
+	Alias string
+	Type  int
+	On    *K3Expr
 }
 
 type K3CreateQuery struct {
 	Table       *K3Table
 	Fields      map[string]int
-	Constraints map[string]string
+	Constraints []*K3Constraint
+	Select      *K3SelectQuery
 	User        string
 }
 
+type K3AlterQuery struct {
+	Table       *K3Table
+	Action      int
+	Column      string
+	NewName     string
+	Type        int
+	Constraints []*K3Constraint
+	User        string
+}
+
+type K3Constraint struct {
+	Name       string
+	Kind       int
+	Columns    []string
+	Expr       *K3Expr
+	RefTable   string
+	RefColumns []string
+	OnDelete   int
+}
+
 type K3InsertQuery struct {
-	Table  *K3Table
-	Values []map[string]string
-	User   string
+	Table     *K3Table
+	Values    []map[string]string
+	Columns   []string
+	Select    *K3SelectQuery
+	Conflict  *K3Conflict
+	Returning *K3SelectQuery
+	Returned  []map[string]string
+	User      string
+}
+
+type K3Conflict struct {
+	Columns []string
+	Update  map[string]*K3Expr
+	Where   *K3Expr
 }
 
 type K3UserQuery struct {
@@ -102,11 +262,23 @@ type K3UserQuery struct {
 }
 
 type K3Table struct {
-	Database string
-	Name     string
-	Fields   []string
-	Mu       *sync.RWMutex
-	LU       time.Time
+	Database    string
+	Name        string
+	Fields      []string
+	Types       map[string]int
+	Constraints []*K3Constraint
+	Indexes     map[string]map[string]bool
+	Mu          *sync.RWMutex
+	LU          time.Time
+	CTE         *K3CommonTable
+}
+
+type K3CommonTable struct {
+	Query        *K3SelectQuery
+	Recursive    *K3SelectQuery
+	All          bool
+	Rows         []map[string]string
+	Materialized bool
 }
 
 var K3Tables map[string]*K3Table
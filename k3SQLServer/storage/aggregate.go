@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"fmt"
+	"k3SQLServer/shared"
+	"math/big"
+	"strconv"
+)
+
+type aggregateState struct {
+	count    int64
+	sumInt   int64
+	sumFloat float64
+	sumExact *big.Rat
+	min      k3Value
+	max      k3Value
+	seen     map[string]bool
+}
+
+type groupState struct {
+	record     map[string]string
+	aggregates []*aggregateState
+}
+
+type grouper struct {
+	query  *shared.K3SelectQuery
+	groups map[string]*groupState
+	order  []*groupState
+}
+
+func newGrouper(query *shared.K3SelectQuery) *grouper {
+	g := &grouper{query: query, groups: make(map[string]*groupState)}
+	if len(query.GroupBy) == 0 {
+		g.group("")
+	}
+	return g
+}
+
+func (g *grouper) group(key string) *groupState {
+	state, ok := g.groups[key]
+	if !ok {
+		state = &groupState{record: make(map[string]string), aggregates: make([]*aggregateState, len(g.query.Aggregates))}
+		for i, aggregate := range g.query.Aggregates {
+			state.aggregates[i] = &aggregateState{min: k3Value{null: true}, max: k3Value{null: true}}
+			if aggregate.Distinct {
+				state.aggregates[i].seen = make(map[string]bool)
+			}
+		}
+		g.groups[key] = state
+		g.order = append(g.order, state)
+	}
+	return state
+}
+
+func (g *grouper) add(record map[string]string) error {
+	values := make([]k3Value, len(g.query.GroupBy))
+	for i, group := range g.query.GroupBy {
+		value, err := evalExpr(group, record)
+		if err != nil {
+			return err
+		}
+		values[i] = keyValue(group.Type, value)
+	}
+	state := g.group(valuesKey(values))
+	if len(state.record) == 0 {
+		for _, group := range g.query.GroupBy {
+			if value, ok := record[columnKey(group)]; ok {
+				state.record[columnKey(group)] = value
+			}
+		}
+	}
+	for i, aggregate := range g.query.Aggregates {
+		acc := state.aggregates[i]
+		if len(aggregate.Args) == 0 {
+			acc.count++
+			continue
+		}
+		value, err := evalExpr(aggregate.Args[0], record)
+		if err != nil {
+			return err
+		}
+		if value.null {
+			continue
+		}
+		if acc.seen != nil {
+			key := indexValue(aggregate.Args[0].Type, value.str)
+			if acc.seen[key] {
+				continue
+			}
+			acc.seen[key] = true
+		}
+		acc.count++
+		switch aggregate.Operator {
+		case "SUM", "AVG":
+			if aggregate.Type == shared.K3DECIMAL {
+				r, ok := parseDecimal(value.str)
+				if !ok {
+					return fmt.Errorf("%s: %q is not a number", shared.InvalidSQLLogic, value.str)
+				}
+				if acc.sumExact == nil {
+					acc.sumExact = new(big.Rat)
+				}
+				acc.sumExact.Add(acc.sumExact, r)
+				continue
+			}
+			if aggregate.Type == shared.K3INT {
+				n, err := strconv.ParseInt(value.str, 10, 64)
+				if err != nil {
+					return err
+				}
+				if acc.sumInt, err = intArithmetic("+", acc.sumInt, n); err != nil {
+					return err
+				}
+			}
+			f, err := strconv.ParseFloat(value.str, 64)
+			if err != nil {
+				return err
+			}
+			acc.sumFloat += f
+		case "MIN":
+			if acc.min.null || compareTyped(value, acc.min, aggregate.Type) < 0 {
+				acc.min = value
+			}
+		case "MAX":
+			if acc.max.null || compareTyped(value, acc.max, aggregate.Type) > 0 {
+				acc.max = value
+			}
+		}
+	}
+	return nil
+}
+
+func (g *grouper) results() ([]map[string]string, error) {
+	records := make([]map[string]string, len(g.order))
+	for i, state := range g.order {
+		for j, aggregate := range g.query.Aggregates {
+			acc := state.aggregates[j]
+			switch aggregate.Operator {
+			case "COUNT":
+				state.record[aggregate.Column] = strconv.FormatInt(acc.count, 10)
+			case "SUM":
+				if acc.count == 0 {
+					continue
+				}
+				if aggregate.Type == shared.K3DECIMAL {
+					state.record[aggregate.Column] = formatDecimal(acc.sumExact)
+				} else if aggregate.Type == shared.K3INT {
+					state.record[aggregate.Column] = strconv.FormatInt(acc.sumInt, 10)
+				} else {
+					sum, err := formatFloat(acc.sumFloat)
+					if err != nil {
+						return nil, err
+					}
+					state.record[aggregate.Column] = sum
+				}
+			case "AVG":
+				if acc.sumExact != nil {
+					state.record[aggregate.Column] = formatDecimal(decimalQuotient(acc.sumExact, big.NewRat(acc.count, 1)))
+				} else if acc.count > 0 {
+					avg, err := formatFloat(acc.sumFloat / float64(acc.count))
+					if err != nil {
+						return nil, err
+					}
+					state.record[aggregate.Column] = avg
+				}
+			case "MIN":
+				if !acc.min.null {
+					state.record[aggregate.Column] = acc.min.str
+				}
+			case "MAX":
+				if !acc.max.null {
+					state.record[aggregate.Column] = acc.max.str
+				}
+			}
+		}
+		records[i] = state.record
+	}
+	return records, nil
+}
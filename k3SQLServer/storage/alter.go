@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"k3SQLServer/shared"
+	"os"
+	"slices"
+	"strings"
+)
+
+func AlterTableFile(query *shared.K3AlterQuery) error {
+	table := query.Table
+	table.Mu.Lock()
+	defer table.Mu.Unlock()
+	filePath := shared.K3DataPath + table.Database + "/" + table.Name + shared.Extension
+	if query.Action == shared.K3AlterRenameTable {
+		newPath := shared.K3DataPath + table.Database + "/" + query.NewName + shared.Extension
+		if _, err := os.Stat(newPath); err == nil {
+			return errors.New(shared.TableAlreadyExists)
+		}
+		oldSchemaPath := schemaPath(table)
+		if err := os.Rename(filePath, newPath); err != nil {
+			return err
+		}
+		oldName := table.Name
+		table.Name = query.NewName
+		if !slices.ContainsFunc(table.Constraints, func(c *shared.K3Constraint) bool {
+			return c.Kind == shared.K3ForeignKey && c.RefTable == oldName
+		}) {
+			if len(table.Constraints) > 0 {
+				return os.Rename(oldSchemaPath, schemaPath(table))
+			}
+			return nil
+		}
+		constraints := make([]*shared.K3Constraint, len(table.Constraints))
+		for i, constraint := range table.Constraints {
+			copied := *constraint
+			if constraint.Kind == shared.K3ForeignKey && constraint.RefTable == oldName {
+				copied.RefTable = table.Name
+			}
+			constraints[i] = &copied
+		}
+		if err := writeSchema(table, constraints); err != nil {
+			return err
+		}
+		table.Constraints = constraints
+		return os.Remove(oldSchemaPath)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return errors.New(shared.FileFormatError)
+	}
+	fields, types, err := parseHeader(scanner.Text())
+	if err != nil {
+		return err
+	}
+	var records []map[string]string
+	for scanner.Scan() {
+		records = append(records, parseRecord(scanner.Text(), fields, types))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	constraints := table.Constraints
+	newFields := slices.Clone(fields)
+	newTypes := make(map[string]int, len(types)+1)
+	for field, fieldType := range types {
+		newTypes[field] = fieldType
+	}
+	switch query.Action {
+	case shared.K3AlterAddColumn:
+		if slices.Contains(fields, query.Column) {
+			return fmt.Errorf("%s: column %s already exists", shared.InvalidSQLLogic, query.Column)
+		}
+		newFields = append(newFields, query.Column)
+		newTypes[query.Column] = query.Type
+		constraints = query.Constraints
+		added := &shared.K3Table{Types: newTypes, Constraints: constraints[len(table.Constraints):]}
+		value, err := columnDefault(added, query.Column)
+		if err != nil {
+			return err
+		}
+		if !value.null {
+			if value.str, err = columnValue(query.Column, query.Type, value.str); err != nil {
+				return err
+			}
+		}
+		for _, record := range records {
+			if !value.null {
+				record[query.Column] = value.str
+			}
+			if err := CheckRecord(added, record); err != nil {
+				return err
+			}
+		}
+	case shared.K3AlterDropColumn:
+		i := slices.Index(newFields, query.Column)
+		if i < 0 {
+			return errors.New(shared.ColumnNotExists)
+		}
+		constraints = nil
+		for _, constraint := range table.Constraints {
+			if !slices.Contains(constraint.Columns, query.Column) {
+				constraints = append(constraints, constraint)
+			} else if constraint.Kind != shared.K3NotNull && constraint.Kind != shared.K3Default {
+				return fmt.Errorf("%s: column %s is used by constraint %s", shared.InvalidSQLLogic, query.Column, constraint.Name)
+			}
+		}
+		newFields = slices.Delete(newFields, i, i+1)
+		delete(newTypes, query.Column)
+	case shared.K3AlterRenameColumn:
+		i := slices.Index(newFields, query.Column)
+		if i < 0 {
+			return errors.New(shared.ColumnNotExists)
+		}
+		newFields[i] = query.NewName
+		newTypes[query.NewName] = newTypes[query.Column]
+		delete(newTypes, query.Column)
+		for _, record := range records {
+			if v, ok := record[query.Column]; ok {
+				record[query.NewName] = v
+			}
+			delete(record, query.Column)
+		}
+		constraints = renameConstraintColumn(table.Constraints, table.Name, query.Column, query.NewName)
+	case shared.K3AlterColumnType:
+		if !slices.Contains(fields, query.Column) {
+			return errors.New(shared.ColumnNotExists)
+		}
+		newTypes[query.Column] = query.Type
+		cast := &shared.K3Expr{Kind: shared.K3ExprCast, Type: query.Type, Args: []*shared.K3Expr{
+			{Kind: shared.K3ExprColumn, Type: types[query.Column], Column: query.Column},
+		}}
+		for _, record := range records {
+			if IsNull(record, query.Column) {
+				continue
+			}
+			value, err := evalCast(cast, record)
+			if err != nil {
+				return err
+			}
+			record[query.Column] = value.str
+		}
+		constraints = query.Constraints
+		retyped := &shared.K3Table{Types: newTypes, Constraints: constraints}
+		for _, record := range records {
+			if err := CheckRecord(retyped, record); err != nil {
+				return err
+			}
+		}
+	}
+
+	check := &shared.K3Table{Types: newTypes, Constraints: constraints}
+	indexes := newIndexes(check)
+	for _, record := range records {
+		if err := addKeys(check, indexes, nil, record); err != nil {
+			return err
+		}
+	}
+
+	tempFilePath := filePath + ".tmp"
+	tempFile, err := os.Create(tempFilePath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFilePath)
+	defer tempFile.Close()
+	writer := bufio.NewWriter(tempFile)
+	if _, err := writer.WriteString(formatHeader(newFields, newTypes) + "\n"); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if _, err := writer.WriteString(formatRecord(record, newFields) + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := os.Rename(tempFilePath, filePath); err != nil {
+		return err
+	}
+	table.Fields, table.Types = newFields, newTypes
+	table.Indexes = indexes
+	table.Constraints = constraints
+	return writeSchema(table, constraints)
+}
+
+func formatHeader(fields []string, types map[string]int) string {
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		parts[i] = fmt.Sprintf("%d %s", types[field], field)
+	}
+	return strings.Join(parts, "|")
+}
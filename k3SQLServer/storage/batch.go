@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"k3SQLServer/shared"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// referenceMu is held by every writer that locks more than one table: an
+// insert keeping the parents of its rows read locked, and a batch. As no two
+// of them can hold table locks at once, none waits on another.
+var referenceMu sync.Mutex
+
+// Batch keeps the rows of write locked tables in memory, so that a statement
+// and the changes that its foreign keys require are checked as a whole and
+// written all at once, or not at all.
+type Batch struct {
+	tables  []*shared.K3Table
+	headers map[*shared.K3Table]string
+	records map[*shared.K3Table][]map[string]string
+	changed map[*shared.K3Table]bool
+}
+
+// LockTables starts a batch with tables write locked. The batch has to be
+// released once it is committed or abandoned.
+func LockTables(tables ...*shared.K3Table) (*Batch, error) {
+	referenceMu.Lock()
+	b := &Batch{
+		headers: make(map[*shared.K3Table]string),
+		records: make(map[*shared.K3Table][]map[string]string),
+		changed: make(map[*shared.K3Table]bool),
+	}
+	if err := b.Lock(tables...); err != nil {
+		b.Release()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Lock write locks tables, in name order, and reads their rows into the
+// batch. Tables already in the batch are left as they are.
+func (b *Batch) Lock(tables ...*shared.K3Table) error {
+	tables = slices.Clone(tables)
+	slices.SortFunc(tables, func(a, c *shared.K3Table) int { return strings.Compare(a.Name, c.Name) })
+	for _, table := range tables {
+		if _, ok := b.headers[table]; ok {
+			continue
+		}
+		table.Mu.Lock()
+		b.tables = append(b.tables, table)
+		file, err := os.Open(shared.K3DataPath + table.Database + "/" + table.Name + shared.Extension)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(file)
+		if !scanner.Scan() {
+			file.Close()
+			return errors.New(shared.FileFormatError)
+		}
+		b.headers[table] = scanner.Text()
+		var records []map[string]string
+		for scanner.Scan() {
+			records = append(records, parseRecord(scanner.Text(), table.Fields, table.Types))
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		b.records[table] = records
+	}
+	return nil
+}
+
+// Release unlocks the tables of the batch, dropping the changes that were
+// not committed.
+func (b *Batch) Release() {
+	for _, table := range b.tables {
+		table.Mu.Unlock()
+	}
+	b.tables = nil
+	referenceMu.Unlock()
+}
+
+// Rows returns the rows of table that satisfy where, which must not be
+// changed by the caller.
+func (b *Batch) Rows(table *shared.K3Table, where *shared.K3Expr) ([]map[string]string, error) {
+	var rows []map[string]string
+	for _, record := range b.records[table] {
+		ok, err := satisfiesConditions(record, where)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rows = append(rows, record)
+		}
+	}
+	return rows, nil
+}
+
+// Delete removes the rows that query matches from its table and returns
+// them.
+func (b *Batch) Delete(query *shared.K3DeleteQuery) ([]map[string]string, error) {
+	var kept, deleted []map[string]string
+	for _, record := range b.records[query.Table] {
+		ok, err := satisfiesConditions(record, query.Where)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			deleted = append(deleted, record)
+		} else {
+			kept = append(kept, record)
+		}
+	}
+	if len(deleted) > 0 {
+		b.records[query.Table], b.changed[query.Table] = kept, true
+	}
+	var err error
+	query.Returned, err = returningRows(query.Returning, deleted)
+	return deleted, err
+}
+
+// Update applies query to the rows of its table and returns them as they
+// were and as they are now. Constraints are only checked on commit.
+func (b *Batch) Update(query *shared.K3UpdateQuery) ([]map[string]string, []map[string]string, error) {
+	for col := range query.SetValues {
+		if _, exists := query.Table.Types[col]; !exists {
+			return nil, nil, fmt.Errorf("%s: %s", shared.ColumnNotExists, col)
+		}
+	}
+	var rows, updated []map[string]string
+	records := b.records[query.Table]
+	for i, record := range records {
+		ok, err := satisfiesConditions(record, query.Where)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			continue
+		}
+		changed := maps.Clone(record)
+		if err := setValues(query, query.Table.Types, changed); err != nil {
+			return nil, nil, err
+		}
+		records[i] = changed
+		rows = append(rows, record)
+		updated = append(updated, changed)
+	}
+	if len(rows) > 0 {
+		b.changed[query.Table] = true
+	}
+	var err error
+	query.Returned, err = returningRows(query.Returning, updated)
+	return rows, updated, err
+}
+
+// CheckReferences verifies that the foreign keys of records, rows of table,
+// exist in the rows of their parent tables as the batch holds them. Parent
+// tables missing from the batch are locked first.
+func (b *Batch) CheckReferences(table *shared.K3Table, records []map[string]string) error {
+	for _, fk := range table.Constraints {
+		if fk.Kind != shared.K3ForeignKey {
+			continue
+		}
+		parent := table
+		if fk.RefTable != table.Name {
+			var err error
+			if parent, err = LoadTable(table.Database, fk.RefTable); err != nil {
+				return err
+			}
+			if err := b.Lock(parent); err != nil {
+				return err
+			}
+		}
+		key, columns := referencedKey(parent, fk)
+		if key == nil {
+			return fmt.Errorf("%s: referenced key of constraint %s is missing in table %s", shared.ForeignKeyViolation, fk.Name, parent.Name)
+		}
+		keys := make(map[string]bool)
+		for _, record := range b.records[parent] {
+			if !slices.ContainsFunc(key.Columns, func(column string) bool { return IsNull(record, column) }) {
+				keys[indexKey(record, key.Columns, parent.Types)] = true
+			}
+		}
+		if err := checkKeys(parent, fk, columns, table.Types, records, keys, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Commit checks every changed table against its constraints and writes it
+// next to its file. The files are only replaced once all of them are
+// written.
+func (b *Batch) Commit() error {
+	var changed []*shared.K3Table
+	indexes := make(map[*shared.K3Table]map[string]map[string]bool)
+	for _, table := range b.tables {
+		if !b.changed[table] {
+			continue
+		}
+		tempFilePath := shared.K3DataPath + table.Database + "/" + table.Name + shared.Extension + ".tmp"
+		defer os.Remove(tempFilePath)
+		indexes[table] = newIndexes(table)
+		lines := make([]string, 0, len(b.records[table])+1)
+		lines = append(lines, b.headers[table])
+		for _, record := range b.records[table] {
+			if err := CheckRecord(table, record); err != nil {
+				return err
+			}
+			if err := addKeys(table, indexes[table], nil, record); err != nil {
+				return err
+			}
+			lines = append(lines, formatRecord(record, table.Fields))
+		}
+		if err := os.WriteFile(tempFilePath, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			return err
+		}
+		changed = append(changed, table)
+	}
+	for _, table := range changed {
+		filePath := shared.K3DataPath + table.Database + "/" + table.Name + shared.Extension
+		if err := os.Rename(filePath+".tmp", filePath); err != nil {
+			return err
+		}
+		table.Indexes = indexes[table]
+	}
+	b.changed = make(map[*shared.K3Table]bool)
+	return nil
+}
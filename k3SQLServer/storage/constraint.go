@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"k3SQLServer/shared"
+	"os"
+	"slices"
+	"strings"
+)
+
+func schemaPath(table *shared.K3Table) string {
+	return shared.K3DataPath + table.Database + "/" + table.Name + shared.SchemaExtension
+}
+
+func readSchema(table *shared.K3Table) error {
+	data, err := os.ReadFile(schemaPath(table))
+	if errors.Is(err, os.ErrNotExist) {
+		table.Constraints = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var constraints []*shared.K3Constraint
+	if err := json.Unmarshal(data, &constraints); err != nil {
+		return errors.New(shared.FileFormatError)
+	}
+	table.Constraints = constraints
+	return nil
+}
+
+func writeSchema(table *shared.K3Table, constraints []*shared.K3Constraint) error {
+	path := schemaPath(table)
+	if len(constraints) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(constraints)
+	if err != nil {
+		return err
+	}
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+func isUnique(constraint *shared.K3Constraint) bool {
+	return constraint.Kind == shared.K3PrimaryKey || constraint.Kind == shared.K3Unique
+}
+
+func newIndexes(table *shared.K3Table) map[string]map[string]bool {
+	indexes := make(map[string]map[string]bool)
+	for _, constraint := range table.Constraints {
+		if isUnique(constraint) {
+			indexes[constraint.Name] = make(map[string]bool)
+		}
+	}
+	return indexes
+}
+
+// indexValue is the stored form of value, so that 01 and 1, or 1.0 and 1,
+// share a key; a value that is not valid for fieldType is its own key.
+func indexValue(fieldType int, value string) string {
+	if canonical, err := CanonicalValue(fieldType, value); err == nil {
+		return canonical
+	}
+	return value
+}
+
+// IsNull reports whether column is NULL in record; records leave NULL
+// columns out.
+func IsNull(record map[string]string, column string) bool {
+	_, ok := record[column]
+	return !ok
+}
+
+func columnDefault(table *shared.K3Table, column string) (k3Value, error) {
+	for _, constraint := range table.Constraints {
+		if constraint.Kind == shared.K3Default && constraint.Columns[0] == column {
+			return evalExpr(constraint.Expr, nil)
+		}
+	}
+	return k3Value{null: true}, nil
+}
+
+// CheckRecord enforces the NOT NULL and CHECK constraints of table on record.
+// A CHECK that is unknown because of a NULL passes.
+func CheckRecord(table *shared.K3Table, record map[string]string) error {
+	for _, constraint := range table.Constraints {
+		switch constraint.Kind {
+		case shared.K3PrimaryKey, shared.K3NotNull:
+			for _, column := range constraint.Columns {
+				if IsNull(record, column) {
+					return fmt.Errorf("%s: null value in column %s violates constraint %s", shared.NotNullViolation, column, constraint.Name)
+				}
+			}
+		case shared.K3Check:
+			result, err := evalCondition(record, constraint.Expr)
+			if err != nil {
+				return err
+			}
+			if result == k3False {
+				return fmt.Errorf("%s: row violates constraint %s", shared.CheckViolation, constraint.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func renameExprColumn(expr *shared.K3Expr, column, newName string) *shared.K3Expr {
+	if expr == nil {
+		return nil
+	}
+	renamed := *expr
+	if renamed.Kind == shared.K3ExprColumn && renamed.Column == column {
+		renamed.Column = newName
+	}
+	renamed.Args = make([]*shared.K3Expr, len(expr.Args))
+	for i, arg := range expr.Args {
+		renamed.Args[i] = renameExprColumn(arg, column, newName)
+	}
+	return &renamed
+}
+
+// renameConstraintColumn renames column of table in its constraints, including
+// the referenced columns of foreign keys to table itself.
+func renameConstraintColumn(constraints []*shared.K3Constraint, table, column, newName string) []*shared.K3Constraint {
+	renamed := make([]*shared.K3Constraint, len(constraints))
+	for i, constraint := range constraints {
+		copied := *constraint
+		copied.Columns = slices.Clone(constraint.Columns)
+		if j := slices.Index(copied.Columns, column); j >= 0 {
+			copied.Columns[j] = newName
+		}
+		copied.Expr = renameExprColumn(constraint.Expr, column, newName)
+		if constraint.Kind == shared.K3ForeignKey && constraint.RefTable == table {
+			copied.RefColumns = renameReference(constraint.RefColumns, column, newName)
+		}
+		renamed[i] = &copied
+	}
+	return renamed
+}
+
+func renameReference(columns []string, column, newName string) []string {
+	renamed := slices.Clone(columns)
+	if j := slices.Index(renamed, column); j >= 0 && column != "" {
+		renamed[j] = newName
+	}
+	return renamed
+}
+
+// RenameReferences points the foreign keys of children at the new names of
+// a renamed table or of one of its columns; column is empty for a table.
+func RenameReferences(children []*shared.K3Table, oldTable, newTable, column, newColumn string) error {
+	for _, child := range children {
+		if child.Name == oldTable || child.Name == newTable {
+			continue
+		}
+		constraints := make([]*shared.K3Constraint, len(child.Constraints))
+		for i, constraint := range child.Constraints {
+			copied := *constraint
+			if constraint.Kind == shared.K3ForeignKey && constraint.RefTable == oldTable {
+				copied.RefTable = newTable
+				copied.RefColumns = renameReference(constraint.RefColumns, column, newColumn)
+			}
+			constraints[i] = &copied
+		}
+		if err := SetConstraints(child, constraints); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexKey(record map[string]string, columns []string, types map[string]int) string {
+	values := make([]k3Value, len(columns))
+	for i, column := range columns {
+		values[i] = k3Value{str: indexValue(types[column], record[column])}
+	}
+	return valuesKey(values)
+}
+
+// addKeys records the unique keys of record in indexes, failing when a key
+// is already taken there or in existing.
+func addKeys(table *shared.K3Table, indexes, existing map[string]map[string]bool, record map[string]string) error {
+	for _, constraint := range table.Constraints {
+		if !isUnique(constraint) || slices.ContainsFunc(constraint.Columns, func(column string) bool {
+			return IsNull(record, column)
+		}) {
+			continue
+		}
+		key := indexKey(record, constraint.Columns, table.Types)
+		if indexes[constraint.Name][key] || existing[constraint.Name][key] {
+			values := make([]string, len(constraint.Columns))
+			for i, column := range constraint.Columns {
+				values[i] = record[column]
+			}
+			return fmt.Errorf("%s: duplicate key (%s)=(%s) violates constraint %s", shared.UniqueViolation,
+				strings.Join(constraint.Columns, ", "), strings.Join(values, ", "), constraint.Name)
+		}
+		indexes[constraint.Name][key] = true
+	}
+	return nil
+}
+
+// loadIndexes builds the unique indexes of table from its file the first
+// time they are needed; writers keep them current afterwards.
+func loadIndexes(table *shared.K3Table) error {
+	if table.Indexes != nil {
+		return nil
+	}
+	indexes := newIndexes(table)
+	if len(indexes) > 0 {
+		file, err := os.Open(shared.K3DataPath + table.Database + "/" + table.Name + shared.Extension)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		scanner.Scan()
+		for scanner.Scan() {
+			if err := addKeys(table, indexes, nil, parseRecord(scanner.Text(), table.Fields, table.Types)); err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	table.Indexes = indexes
+	return nil
+}
+
+func mergeIndexes(table *shared.K3Table, added map[string]map[string]bool) {
+	for name, keys := range added {
+		for key := range keys {
+			table.Indexes[name][key] = true
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"fmt"
+	"k3SQLServer/shared"
+)
+
+const maxRecursion = 10000
+
+func materializeCTE(table *shared.K3Table) ([]map[string]string, error) {
+	cte := table.CTE
+	if cte.Materialized {
+		return cte.Rows, nil
+	}
+	rows, err := selectRows(cte.Query, nil)
+	if err != nil {
+		return nil, err
+	}
+	rows = renameColumns(rows, cte.Query.Columns, table.Fields)
+	if cte.Recursive != nil {
+		seen := make(map[string]bool)
+		if !cte.All {
+			rows = distinctRows(rows, table, seen)
+		}
+		all := rows
+		working := rows
+		for i := 0; len(working) > 0; i++ {
+			if i == maxRecursion {
+				return nil, fmt.Errorf("%s: recursive query %s exceeded %d iterations", shared.InvalidSQLLogic, table.Name, maxRecursion)
+			}
+			cte.Rows, cte.Materialized = working, true
+			next, err := selectRows(cte.Recursive, nil)
+			if err != nil {
+				cte.Materialized = false
+				return nil, err
+			}
+			working = renameColumns(next, cte.Recursive.Columns, table.Fields)
+			if !cte.All {
+				working = distinctRows(working, table, seen)
+			}
+			all = append(all, working...)
+		}
+		rows = all
+	}
+	cte.Rows, cte.Materialized = rows, true
+	return rows, nil
+}
+
+func renameColumns(rows []map[string]string, from, to []string) []map[string]string {
+	renamed := make([]map[string]string, len(rows))
+	for i, row := range rows {
+		record := make(map[string]string, len(to))
+		for j, field := range to {
+			if value, ok := row[from[j]]; ok {
+				record[field] = value
+			}
+		}
+		renamed[i] = record
+	}
+	return renamed
+}
+
+func distinctRows(rows []map[string]string, table *shared.K3Table, seen map[string]bool) []map[string]string {
+	var distinct []map[string]string
+	for _, row := range rows {
+		values := make([]k3Value, len(table.Fields))
+		for i, field := range table.Fields {
+			value, ok := row[field]
+			values[i] = keyValue(table.Types[field], k3Value{str: value, null: !ok})
+		}
+		key := valuesKey(values)
+		if !seen[key] {
+			seen[key] = true
+			distinct = append(distinct, row)
+		}
+	}
+	return distinct
+}
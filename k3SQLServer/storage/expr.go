@@ -0,0 +1,332 @@
+package storage
+
+import (
+	"fmt"
+	"k3SQLServer/shared"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type k3Value struct {
+	str  string
+	null bool
+}
+
+func evalExpr(expr *shared.K3Expr, record map[string]string) (k3Value, error) {
+	switch expr.Kind {
+	case shared.K3ExprColumn, shared.K3ExprAggregate, shared.K3ExprWindow:
+		value, ok := record[columnKey(expr)]
+		if !ok {
+			return k3Value{null: true}, nil
+		}
+		return k3Value{str: value}, nil
+	case shared.K3ExprLiteral:
+		return k3Value{str: expr.Value}, nil
+	case shared.K3ExprNull:
+		return k3Value{null: true}, nil
+	case shared.K3ExprSubquery:
+		values, err := subqueryValues(expr, record)
+		if err != nil {
+			return k3Value{}, err
+		}
+		if len(values) > 1 {
+			return k3Value{}, fmt.Errorf("%s: more than one row returned by a subquery used as an expression", shared.InvalidSQLLogic)
+		}
+		if len(values) == 0 {
+			return k3Value{null: true}, nil
+		}
+		return values[0], nil
+	case shared.K3ExprFunction:
+		return evalFunction(expr, record)
+	case shared.K3ExprCase:
+		return evalCase(expr, record)
+	case shared.K3ExprCast:
+		return evalCast(expr, record)
+	case shared.K3ExprArithmetic, shared.K3ExprConcat, shared.K3ExprNegate:
+		values := make([]k3Value, len(expr.Args))
+		for i, arg := range expr.Args {
+			value, err := evalExpr(arg, record)
+			if err != nil || value.null {
+				return value, err
+			}
+			values[i] = value
+		}
+		switch expr.Kind {
+		case shared.K3ExprConcat:
+			return k3Value{str: values[0].str + values[1].str}, nil
+		case shared.K3ExprNegate:
+			return arithmetic("-", expr.Type, k3Value{str: "0"}, values[0])
+		}
+		return arithmetic(expr.Operator, expr.Type, values[0], values[1])
+	}
+	return k3Value{}, fmt.Errorf("%s: condition used as a value", shared.InvalidSQLLogic)
+}
+
+func arithmetic(operator string, valueType int, a, b k3Value) (k3Value, error) {
+	if valueType == shared.K3DECIMAL {
+		return decimalArithmetic(operator, a, b)
+	}
+	if valueType == shared.K3INT {
+		intA, errA := strconv.ParseInt(a.str, 10, 64)
+		intB, errB := strconv.ParseInt(b.str, 10, 64)
+		if errA != nil || errB != nil {
+			return k3Value{}, fmt.Errorf("%s: %q and %q are not numbers", shared.InvalidSQLLogic, a.str, b.str)
+		}
+		result, err := intArithmetic(operator, intA, intB)
+		if err != nil {
+			return k3Value{}, err
+		}
+		return k3Value{str: strconv.FormatInt(result, 10)}, nil
+	}
+	numA, errA := strconv.ParseFloat(a.str, 64)
+	numB, errB := strconv.ParseFloat(b.str, 64)
+	if errA != nil || errB != nil {
+		return k3Value{}, fmt.Errorf("%s: %q and %q are not numbers", shared.InvalidSQLLogic, a.str, b.str)
+	}
+	var result float64
+	switch operator {
+	case "+":
+		result = numA + numB
+	case "-":
+		result = numA - numB
+	case "*":
+		result = numA * numB
+	case "/", "%":
+		if numB == 0 {
+			return k3Value{}, fmt.Errorf("%s: division by zero", shared.InvalidSQLLogic)
+		}
+		if operator == "/" {
+			result = numA / numB
+		} else {
+			result = math.Mod(numA, numB)
+		}
+	}
+	text, err := formatFloat(result)
+	return k3Value{str: text}, err
+}
+
+// intArithmetic applies operator to two INTs, failing where the result does
+// not fit instead of wrapping around.
+func intArithmetic(operator string, a, b int64) (int64, error) {
+	var result int64
+	overflow := false
+	switch operator {
+	case "+":
+		result = a + b
+		overflow = (a > 0 && b > 0 && result < 0) || (a < 0 && b < 0 && result >= 0)
+	case "-":
+		result = a - b
+		overflow = (a >= 0 && b < 0 && result < 0) || (a < 0 && b > 0 && result >= 0)
+	case "*":
+		result = a * b
+		overflow = a != 0 && (result/a != b || (a == -1 && b == math.MinInt64))
+	case "/", "%":
+		if b == 0 {
+			return 0, fmt.Errorf("%s: division by zero", shared.InvalidSQLLogic)
+		}
+		if operator == "/" {
+			result = a / b
+			overflow = a == math.MinInt64 && b == -1
+		} else {
+			result = a % b
+		}
+	}
+	if overflow {
+		return 0, fmt.Errorf("%s: integer out of range", shared.InvalidSQLLogic)
+	}
+	return result, nil
+}
+
+func columnKey(expr *shared.K3Expr) string {
+	if expr.Table != "" {
+		return expr.Table + "." + expr.Column
+	}
+	return expr.Column
+}
+
+// k3Bool is the result of a condition under SQL's three-valued logic, where
+// comparing with NULL is neither true nor false.
+type k3Bool int
+
+const (
+	k3False k3Bool = iota
+	k3True
+	k3Unknown
+)
+
+func toK3Bool(ok bool) k3Bool {
+	if ok {
+		return k3True
+	}
+	return k3False
+}
+
+func (b k3Bool) not() k3Bool {
+	switch b {
+	case k3True:
+		return k3False
+	case k3False:
+		return k3True
+	}
+	return k3Unknown
+}
+
+func (b k3Bool) and(other k3Bool) k3Bool {
+	switch {
+	case b == k3False || other == k3False:
+		return k3False
+	case b == k3Unknown || other == k3Unknown:
+		return k3Unknown
+	}
+	return k3True
+}
+
+func (b k3Bool) or(other k3Bool) k3Bool {
+	return b.not().and(other.not()).not()
+}
+
+// satisfiesConditions reports whether where is true for record; rows for
+// which it is unknown are filtered out just like false ones.
+func satisfiesConditions(record map[string]string, where *shared.K3Expr) (bool, error) {
+	if where == nil {
+		return true, nil
+	}
+	result, err := evalCondition(record, where)
+	return result == k3True, err
+}
+
+func evalCondition(record map[string]string, where *shared.K3Expr) (k3Bool, error) {
+	if where.Type == shared.K3BOOLEAN {
+		value, err := evalExpr(where, record)
+		if err != nil {
+			return k3False, err
+		}
+		if value.null {
+			return k3Unknown, nil
+		}
+		return toK3Bool(value.str == "true"), nil
+	}
+	switch where.Kind {
+	case shared.K3ExprAnd, shared.K3ExprOr:
+		left, err := evalCondition(record, where.Args[0])
+		if err != nil {
+			return k3False, err
+		}
+		if where.Kind == shared.K3ExprAnd && left == k3False || where.Kind == shared.K3ExprOr && left == k3True {
+			return left, nil
+		}
+		right, err := evalCondition(record, where.Args[1])
+		if where.Kind == shared.K3ExprAnd {
+			return left.and(right), err
+		}
+		return left.or(right), err
+	case shared.K3ExprNot:
+		result, err := evalCondition(record, where.Args[0])
+		return result.not(), err
+	case shared.K3ExprIsNull:
+		value, err := evalExpr(where.Args[0], record)
+		if err != nil {
+			return k3False, err
+		}
+		return toK3Bool(value.null != where.Not), nil
+	case shared.K3ExprExists:
+		values, err := subqueryValues(where, record)
+		return toK3Bool(len(values) > 0), err
+	case shared.K3ExprNull:
+		return k3Unknown, nil
+	}
+	values := make([]k3Value, len(where.Args))
+	for i, arg := range where.Args {
+		value, err := evalExpr(arg, record)
+		if err != nil {
+			return k3False, err
+		}
+		values[i] = value
+	}
+	valueType := comparisonType(where)
+	switch where.Kind {
+	case shared.K3ExprCompare:
+		if values[0].null || values[1].null {
+			return k3Unknown, nil
+		}
+		return toK3Bool(matchesComparison(compareTyped(values[0], values[1], valueType), where.Operator)), nil
+	case shared.K3ExprLike:
+		if values[0].null || values[1].null {
+			return k3Unknown, nil
+		}
+		return toK3Bool(matchLike(values[0].str, values[1].str) != where.Not), nil
+	case shared.K3ExprIn:
+		if where.Subquery != nil {
+			list, err := subqueryValues(where, record)
+			if err != nil {
+				return k3False, err
+			}
+			values = append(values, list...)
+		}
+		// x IN (a, b) is x = a OR x = b
+		result := k3False
+		for _, value := range values[1:] {
+			switch {
+			case values[0].null || value.null:
+				result = result.or(k3Unknown)
+			case compareTyped(value, values[0], valueType) == 0:
+				result = k3True
+			}
+		}
+		if where.Not {
+			return result.not(), nil
+		}
+		return result, nil
+	case shared.K3ExprBetween:
+		atLeast := func(a, b k3Value) k3Bool {
+			if a.null || b.null {
+				return k3Unknown
+			}
+			return toK3Bool(compareTyped(a, b, valueType) >= 0)
+		}
+		low, high := atLeast(values[0], values[1]), atLeast(values[2], values[0])
+		if where.Not {
+			return low.and(high).not(), nil
+		}
+		return low.and(high), nil
+	}
+	return k3False, fmt.Errorf("%s: value used as a condition", shared.InvalidSQLLogic)
+}
+
+func matchesComparison(c int, operator string) bool {
+	switch operator {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">":
+		return c > 0
+	case "<":
+		return c < 0
+	case ">=":
+		return c >= 0
+	case "<=":
+		return c <= 0
+	}
+	return false
+}
+
+func matchLike(value, pattern string) bool {
+	var likePattern strings.Builder
+	likePattern.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			likePattern.WriteString(".*")
+		case '_':
+			likePattern.WriteString(".")
+		default:
+			likePattern.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	likePattern.WriteString("$")
+	matched, err := regexp.MatchString(likePattern.String(), value)
+	return err == nil && matched
+}
@@ -7,7 +7,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"k3SQLServer/shared"
 	"os"
-	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -54,7 +54,7 @@ func CheckCredentialsFiles(dbName, user, password string) (bool, error) {
 	}
 
 	for scanner.Scan() {
-		record := parseUserRecord(scanner.Text())
+		record := parseRecord(scanner.Text(), usersTable.Fields, usersTable.Types)
 		if record["name"] == user {
 			err = bcrypt.CompareHashAndPassword([]byte(password), []byte(record["password"]))
 			if err == nil {
@@ -68,14 +68,6 @@ func CheckCredentialsFiles(dbName, user, password string) (bool, error) {
 	return false, errors.New(shared.UserNotFound)
 }
 
-func parseUserRecord(line string) map[string]string {
-	parts := strings.Split(line, "|")
-	return map[string]string{
-		"name":     parts[0],
-		"password": parts[1],
-	}
-}
-
 func ExistsTable(Table *shared.K3Table) bool {
 	file, err := os.Open(shared.K3DataPath + Table.Database + "/" + Table.Name + shared.Extension)
 	defer file.Close()
@@ -93,23 +85,39 @@ func ExistsTable(Table *shared.K3Table) bool {
 	return false
 }
 
+func parseHeader(line string) ([]string, map[string]int, error) {
+	parts := strings.Split(line, "|")
+	fields := make([]string, len(parts))
+	types := make(map[string]int, len(parts))
+	for i, part := range parts {
+		typeStr, name, ok := strings.Cut(part, " ")
+		if !ok || name == "" {
+			return nil, nil, errors.New(shared.FileFormatError)
+		}
+		fieldType, err := strconv.Atoi(typeStr)
+		if err != nil {
+			return nil, nil, errors.New(shared.FileFormatError)
+		}
+		fields[i] = name
+		types[name] = fieldType
+	}
+	return fields, types, nil
+}
+
 func AddFieldsTableFile(Table *shared.K3Table) error {
 	file, err := os.Open(shared.K3DataPath + Table.Database + "/" + Table.Name + shared.Extension)
 	if err == nil {
 		defer file.Close()
 		scanner := bufio.NewScanner(file)
 		scanner.Scan()
-		dataStr := scanner.Text()
-		parts := strings.Split(dataStr, "|")
-		TableFields := make([]string, len(parts))
-		for i := 0; i < len(parts); i++ {
-			if len(parts[i]) > 2 {
-				TableFields[i] = parts[i][2:]
-			} else {
-				return errors.New(shared.FileFormatError)
-			}
+		TableFields, TableTypes, err := parseHeader(scanner.Text())
+		if err != nil {
+			return err
 		}
 		Table.Fields = TableFields
+		Table.Types = TableTypes
+		Table.Indexes = nil
+		return readSchema(Table)
 	}
 	return err
 }
@@ -131,11 +139,15 @@ func CreateTableFile(query *shared.K3CreateQuery) error {
 		if err != nil {
 			return err
 		}
+		return writeSchema(query.Table, query.Constraints)
 	}
 	return err
 }
 
 func InsertTableFile(query *shared.K3InsertQuery) error {
+	if err := materializeInsert(query); err != nil {
+		return err
+	}
 	query.Table.Mu.Lock()
 	defer query.Table.Mu.Unlock()
 	fileRead, err := os.Open(shared.K3DataPath + query.Table.Database + "/" + query.Table.Name + shared.Extension)
@@ -144,111 +156,120 @@ func InsertTableFile(query *shared.K3InsertQuery) error {
 		scanner.Scan()
 		dataStr := scanner.Text()
 		fileRead.Close()
-		parts := strings.Split(dataStr, "|")
-		TableTypes := make(map[string]int, len(parts))
-		for _, part := range parts {
-			TableType, err := strconv.Atoi(string(part[0]))
-			if err != nil {
-				return err
-			}
-			TableTypes[part[2:]] = TableType
-		}
-		file, err := os.OpenFile(shared.K3DataPath+query.Table.Database+"/"+query.Table.Name+shared.Extension, os.O_APPEND|os.O_WRONLY, 0644)
+		_, TableTypes, err := parseHeader(dataStr)
 		if err != nil {
 			return err
 		}
-		defer file.Close()
-		for _, value := range query.Values {
-			str := ""
+		records := make([]map[string]string, len(query.Values))
+		for i, value := range query.Values {
+			record := make(map[string]string, len(query.Table.Fields))
 			for _, k := range query.Table.Fields {
-				if TableTypes[k] == shared.K3INT {
-					v, ok := value[k]
-					if ok {
-						_, err := strconv.Atoi(v)
-						if err != nil {
-							return err
-						}
-						str += v + "|"
-					} else {
-						return errors.New(fmt.Sprintf("empty Column: %s", k))
-					}
-				} else if TableTypes[k] == shared.K3FLOAT {
-					v, ok := value[k]
-					if ok {
-						_, err := strconv.ParseFloat(v, 64)
-						if err != nil {
-							return err
-						}
-						str += v + "|"
-					} else {
-						return errors.New(fmt.Sprintf("empty Column: %s", k))
+				v, ok := value[k]
+				if !ok && !slices.Contains(query.Columns, k) {
+					def, err := columnDefault(query.Table, k)
+					if err != nil {
+						return err
 					}
-				} else if TableTypes[k] == shared.K3TEXT {
-					v, ok := value[k]
-					if ok {
-						str += v + "|"
-					} else {
-						return errors.New(fmt.Sprintf("empty Column: %s", k))
-					}
-				} else {
-					return errors.New("unknown type")
+					v, ok = def.str, !def.null
+				}
+				if !ok {
+					continue
 				}
+				if record[k], err = columnValue(k, TableTypes[k], v); err != nil {
+					return err
+				}
+			}
+			if err := CheckRecord(query.Table, record); err != nil {
+				return err
 			}
-			_, err = file.WriteString(strings.TrimSuffix(str, "|") + "\n")
-			if err != nil {
+			records[i] = record
+		}
+		if query.Conflict != nil {
+			return upsertRows(query, dataStr, TableTypes, records)
+		}
+		if err := loadIndexes(query.Table); err != nil {
+			return err
+		}
+		added := newIndexes(query.Table)
+		lines := make([]string, len(records))
+		for i, record := range records {
+			if err := addKeys(query.Table, added, query.Table.Indexes, record); err != nil {
 				return err
 			}
+			lines[i] = formatRecord(record, query.Table.Fields)
+		}
+		if query.Returned, err = returningRows(query.Returning, records); err != nil {
+			return err
+		}
+		if err := appendLines(query.Table, lines); err != nil {
+			return err
 		}
+		mergeIndexes(query.Table, added)
+		return nil
 	}
 	return err
 }
 
-func DropTableFile(Table *shared.K3Table) error {
-	Table.Mu.Lock()
-	defer Table.Mu.Unlock()
-	return os.Remove(shared.K3DataPath + Table.Database + "/" + Table.Name + shared.Extension)
+func materializeInsert(query *shared.K3InsertQuery) error {
+	if err := materializeReturning(query.Returning); err != nil {
+		return err
+	}
+	if query.Conflict != nil {
+		if err := materializeSubqueries(query.Conflict.Where); err != nil {
+			return err
+		}
+		for _, expr := range query.Conflict.Update {
+			if err := materializeSubqueries(expr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-func SelectTableFile(query *shared.K3SelectQuery) ([]map[string]string, int, error) {
-	query.Table.Mu.RLock()
-	defer query.Table.Mu.RUnlock()
-	fileRead, err := os.Open(shared.K3DataPath + query.Table.Database + "/" + query.Table.Name + shared.Extension)
+func appendLines(table *shared.K3Table, lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	file, err := os.OpenFile(shared.K3DataPath+table.Database+"/"+table.Name+shared.Extension, os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
-		return nil, 0, err
+		return err
 	}
-	defer fileRead.Close()
-	scanner := bufio.NewScanner(fileRead)
-	scanner.Scan()
-	var results []map[string]string
-	rows := 0
-	for scanner.Scan() {
-		line := scanner.Text()
-		record := parseRecord(line, query.Table.Fields)
-
-		if satisfiesConditions(record, query.Conditions) {
-			filteredRecord := make(map[string]string)
-			for _, field := range query.Values {
-				if field == "*" {
-					for k, v := range record {
-						filteredRecord[k] = v
-					}
-					break
-				} else {
-					if val, ok := record[field]; ok {
-						filteredRecord[field] = val
-					} else {
-						return nil, 0, fmt.Errorf("field %s not found", field)
-					}
-				}
-			}
-			results = append(results, filteredRecord)
-			rows++
-		}
+	defer file.Close()
+	_, err = file.WriteString(strings.Join(lines, "\n") + "\n")
+	return err
+}
+
+// columnValue validates value for column and returns its stored form.
+func columnValue(column string, fieldType int, value string) (string, error) {
+	canonical, err := CanonicalValue(fieldType, value)
+	if err != nil {
+		return "", fmt.Errorf("%s: invalid value %q for column %s", shared.InvalidSQLLogic, value, column)
 	}
-	return results, rows, nil
+	return canonical, nil
+}
+
+func DropTableFile(Table *shared.K3Table) error {
+	Table.Mu.Lock()
+	defer Table.Mu.Unlock()
+	if err := os.Remove(shared.K3DataPath + Table.Database + "/" + Table.Name + shared.Extension); err != nil {
+		return err
+	}
+	return writeSchema(Table, nil)
 }
 
 func UpdateTableFile(query *shared.K3UpdateQuery) (int, error) {
+	if err := materializeSubqueries(query.Where); err != nil {
+		return 0, err
+	}
+	for _, expr := range query.SetValues {
+		if err := materializeSubqueries(expr); err != nil {
+			return 0, err
+		}
+	}
+	if err := materializeReturning(query.Returning); err != nil {
+		return 0, err
+	}
 	query.Table.Mu.Lock()
 	defer query.Table.Mu.Unlock()
 
@@ -265,6 +286,7 @@ func UpdateTableFile(query *shared.K3UpdateQuery) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	defer os.Remove(tempFilePath)
 	defer tempFile.Close()
 
 	scanner := bufio.NewScanner(file)
@@ -273,48 +295,73 @@ func UpdateTableFile(query *shared.K3UpdateQuery) (int, error) {
 	if !scanner.Scan() {
 		return 0, scanner.Err()
 	}
+	_, types, err := parseHeader(scanner.Text())
+	if err != nil {
+		return 0, err
+	}
+	for col := range query.SetValues {
+		if _, exists := types[col]; !exists {
+			return 0, fmt.Errorf("%s: %s", shared.ColumnNotExists, col)
+		}
+	}
 	if _, err := writer.WriteString(scanner.Text() + "\n"); err != nil {
 		return 0, err
 	}
 
 	updatedCount := 0
+	var updated []map[string]string
+	indexes := newIndexes(query.Table)
 	for scanner.Scan() {
 		line := scanner.Text()
-		record := parseRecord(line, query.Table.Fields)
-		if len(query.Conditions) == 0 || satisfiesConditions(record, query.Conditions) {
-			for col, val := range query.SetValues {
-				if _, exists := record[col]; exists {
-					record[col] = val
-				}
+		record := parseRecord(line, query.Table.Fields, query.Table.Types)
+		ok, err := satisfiesConditions(record, query.Where)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			if err := setValues(query, types, record); err != nil {
+				return 0, err
+			}
+			if err := CheckRecord(query.Table, record); err != nil {
+				return 0, err
 			}
 			updatedCount++
-			var newLine strings.Builder
-			for i, field := range query.Table.Fields {
-				if i > 0 {
-					newLine.WriteString("|")
-				}
-				newLine.WriteString(record[field])
+			if query.Returning != nil {
+				updated = append(updated, record)
 			}
-			line = newLine.String()
+			line = formatRecord(record, query.Table.Fields)
+		}
+		if err := addKeys(query.Table, indexes, nil, record); err != nil {
+			return 0, err
 		}
 		if _, err := writer.WriteString(line + "\n"); err != nil {
-			return updatedCount, err
+			return 0, err
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		return updatedCount, err
+		return 0, err
+	}
+	if query.Returned, err = returningRows(query.Returning, updated); err != nil {
+		return 0, err
 	}
 	if err := writer.Flush(); err != nil {
-		return updatedCount, err
+		return 0, err
 	}
 	if err := os.Rename(tempFilePath, filePath); err != nil {
 		return updatedCount, err
 	}
+	query.Table.Indexes = indexes
 
 	return updatedCount, nil
 }
 
 func DeleteTableFile(query *shared.K3DeleteQuery) (int, error) {
+	if err := materializeSubqueries(query.Where); err != nil {
+		return 0, err
+	}
+	if err := materializeReturning(query.Returning); err != nil {
+		return 0, err
+	}
 	query.Table.Mu.Lock()
 	defer query.Table.Mu.Unlock()
 
@@ -330,6 +377,7 @@ func DeleteTableFile(query *shared.K3DeleteQuery) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	defer os.Remove(tempFilePath)
 	defer tempFile.Close()
 	scanner := bufio.NewScanner(file)
 	writer := bufio.NewWriter(tempFile)
@@ -340,100 +388,134 @@ func DeleteTableFile(query *shared.K3DeleteQuery) (int, error) {
 		return 0, err
 	}
 	deletedCount := 0
+	var deleted []map[string]string
+	indexes := newIndexes(query.Table)
 	for scanner.Scan() {
 		line := scanner.Text()
-		record := parseRecord(line, query.Table.Fields)
-		if len(query.Conditions) == 0 || !satisfiesConditions(record, query.Conditions) {
+		record := parseRecord(line, query.Table.Fields, query.Table.Types)
+		ok, err := satisfiesConditions(record, query.Where)
+		if err != nil {
+			return deletedCount, err
+		}
+		if !ok {
+			if err := addKeys(query.Table, indexes, nil, record); err != nil {
+				return deletedCount, err
+			}
 			if _, err := writer.WriteString(line + "\n"); err != nil {
 				return deletedCount, err
 			}
 		} else {
 			deletedCount++
+			if query.Returning != nil {
+				deleted = append(deleted, record)
+			}
 		}
 	}
 	if err := scanner.Err(); err != nil {
 		return deletedCount, err
 	}
+	if query.Returned, err = returningRows(query.Returning, deleted); err != nil {
+		return 0, err
+	}
 	if err := writer.Flush(); err != nil {
 		return deletedCount, err
 	}
 	if err := os.Rename(tempFilePath, filePath); err != nil {
 		return deletedCount, err
 	}
+	query.Table.Indexes = indexes
 
 	return deletedCount, nil
 }
 
-func parseRecord(line string, fields []string) map[string]string {
-	values := strings.Split(line, "|")
+// setValues assigns the SET values of query to record, in the stored form
+// of the column types.
+func setValues(query *shared.K3UpdateQuery, types map[string]int, record map[string]string) error {
+	values := make(map[string]k3Value, len(query.SetValues))
+	for col, expr := range query.SetValues {
+		val, err := evalExpr(expr, record)
+		if err != nil {
+			return err
+		}
+		if !val.null {
+			if val.str, err = columnValue(col, types[col], val.str); err != nil {
+				return err
+			}
+		}
+		values[col] = val
+	}
+	assignValues(record, values)
+	return nil
+}
+
+func assignValues(record map[string]string, values map[string]k3Value) {
+	for col, val := range values {
+		if val.null {
+			delete(record, col)
+		} else {
+			record[col] = val.str
+		}
+	}
+}
+
+// parseRecord reads a row, leaving out its NULL columns. Files written before
+// the NULL marker stored NULL as an empty field, which is still read as NULL
+// outside TEXT columns.
+func parseRecord(line string, fields []string, types map[string]int) map[string]string {
+	values := splitRecord(line)
 	record := make(map[string]string)
 	for i, field := range fields {
-		if i < len(values) {
-			record[field] = values[i]
+		if i < len(values) && values[i] != shared.NullMarker && (values[i] != "" || types[field] == shared.K3TEXT) {
+			record[field] = unescapeValue(values[i])
 		}
 	}
 	return record
 }
 
-func satisfiesConditions(record map[string]string, conditions []shared.K3Condition) bool {
-	for _, cond := range conditions {
-		recordValue, ok := record[cond.Column]
-		if !ok {
-			return false
-		}
+var valueEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", `\n`, "\r", `\r`)
 
-		switch cond.Operator {
-		case "LIKE":
-			likePattern := strings.ReplaceAll(cond.Value, "%", ".*")
-			likePattern = strings.ReplaceAll(likePattern, "_", ".")
-			likePattern = "^" + likePattern + "$"
+// escapeValue keeps the delimiter and line breaks of a value out of the file;
+// a backslash escapes the byte after it.
+func escapeValue(value string) string {
+	return valueEscaper.Replace(value)
+}
 
-			matched, err := regexp.MatchString(likePattern, recordValue)
-			if err != nil || !matched {
-				return false
-			}
-		case "=":
-			if recordValue != cond.Value {
-				return false
-			}
-		case "!=":
-			if recordValue == cond.Value {
-				return false
-			}
-		case ">":
-			if !compareValues(recordValue, cond.Value, false) {
-				return false
-			}
-		case "<":
-			if !compareValues(cond.Value, recordValue, false) {
-				return false
-			}
-		case ">=":
-			if !compareValues(recordValue, cond.Value, true) {
-				return false
-			}
-		case "<=":
-			if !compareValues(cond.Value, recordValue, true) {
-				return false
+func unescapeValue(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var unescaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+			switch value[i] {
+			case 'n':
+				unescaped.WriteByte('\n')
+			case 'r':
+				unescaped.WriteByte('\r')
+			default:
+				unescaped.WriteByte(value[i])
 			}
+			continue
 		}
+		unescaped.WriteByte(value[i])
 	}
-	return true
+	return unescaped.String()
 }
 
-func compareValues(a, b string, allowEqual bool) bool {
-	numA, errA := strconv.ParseFloat(a, 64)
-	numB, errB := strconv.ParseFloat(b, 64)
-
-	if errA == nil && errB == nil {
-		if allowEqual && numA == numB {
-			return true
+// splitRecord splits line on the delimiters that are not escaped, leaving
+// the values escaped.
+func splitRecord(line string) []string {
+	var values []string
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '|':
+			values = append(values, line[start:i])
+			start = i + 1
 		}
-		return numA > numB
-	}
-
-	if allowEqual && a == b {
-		return true
 	}
-	return a > b
+	return append(values, line[start:])
 }
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"k3SQLServer/shared"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEscapeRoundTrip(t *testing.T) {
+	values := []string{
+		"",
+		"plain",
+		"a|b",
+		"|",
+		`back\slash`,
+		`\`,
+		`ends with \`,
+		"line\nbreak",
+		"carriage\rreturn\r\n",
+		`\N`,
+		`\n`,
+		`\|`,
+		"mixed |\\\n\r\\N|",
+	}
+	escaped := make([]string, len(values))
+	for i, value := range values {
+		escaped[i] = escapeValue(value)
+		if strings.ContainsAny(escaped[i], "\n\r") {
+			t.Errorf("escapeValue(%q) = %q keeps a line break", value, escaped[i])
+		}
+		if escaped[i] == shared.NullMarker {
+			t.Errorf("escapeValue(%q) is the NULL marker", value)
+		}
+		if got := unescapeValue(escaped[i]); got != value {
+			t.Errorf("unescapeValue(escapeValue(%q)) = %q", value, got)
+		}
+	}
+	fields := splitRecord(strings.Join(escaped, "|"))
+	if !reflect.DeepEqual(fields, escaped) {
+		t.Fatalf("splitRecord split %q into %q", strings.Join(escaped, "|"), fields)
+	}
+}
+
+func TestParseRecord(t *testing.T) {
+	fields := []string{"id", "name", "note", "n"}
+	types := map[string]int{"id": shared.K3INT, "name": shared.K3TEXT, "note": shared.K3TEXT, "n": shared.K3INT}
+	tests := []struct {
+		line string
+		want map[string]string
+	}{
+		{`1|a\|b|\N|2`, map[string]string{"id": "1", "name": "a|b", "n": "2"}},
+		{`1|\\N||`, map[string]string{"id": "1", "name": `\N`, "note": ""}},
+		{`1|x\ny\\|\r|\N`, map[string]string{"id": "1", "name": "x\ny\\", "note": "\r"}},
+		{`1`, map[string]string{"id": "1"}},
+	}
+	for _, tt := range tests {
+		if got := parseRecord(tt.line, fields, types); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRecord(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+	record := map[string]string{"id": "7", "name": "a|b\\c\nd", "note": `\N`}
+	if got := parseRecord(formatRecord(record, fields), fields, types); !reflect.DeepEqual(got, record) {
+		t.Errorf("parseRecord(formatRecord(%q)) = %q", record, got)
+	}
+}
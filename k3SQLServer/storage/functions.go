@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"k3SQLServer/shared"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+func evalFunction(expr *shared.K3Expr, record map[string]string) (k3Value, error) {
+	args := make([]k3Value, len(expr.Args))
+	for i, arg := range expr.Args {
+		value, err := evalExpr(arg, record)
+		if err != nil {
+			return k3Value{}, err
+		}
+		args[i] = value
+	}
+	switch expr.Operator {
+	case "COALESCE":
+		for _, arg := range args {
+			if !arg.null {
+				return arg, nil
+			}
+		}
+		return k3Value{null: true}, nil
+	case "NULLIF":
+		if !args[0].null && !args[1].null && compareTyped(args[0], args[1], expr.Type) == 0 {
+			return k3Value{null: true}, nil
+		}
+		return args[0], nil
+	case "CONCAT":
+		var text strings.Builder
+		for _, arg := range args {
+			text.WriteString(arg.str)
+		}
+		return k3Value{str: text.String()}, nil
+	}
+	for _, arg := range args {
+		if arg.null {
+			return arg, nil
+		}
+	}
+	switch expr.Operator {
+	case "CURRENT_TIMESTAMP", "NOW":
+		return k3Value{str: time.Now().Format(time.DateTime)}, nil
+	case "CURRENT_DATE":
+		return k3Value{str: time.Now().Format(time.DateOnly)}, nil
+	case "GEN_RANDOM_UUID":
+		return randomUUID()
+	case "UPPER":
+		return k3Value{str: strings.ToUpper(args[0].str)}, nil
+	case "LOWER":
+		return k3Value{str: strings.ToLower(args[0].str)}, nil
+	case "TRIM":
+		return k3Value{str: strings.Trim(args[0].str, " ")}, nil
+	case "LENGTH":
+		return k3Value{str: strconv.Itoa(utf8.RuneCountInString(args[0].str))}, nil
+	case "SUBSTR":
+		return substr(args)
+	case "REPLACE":
+		if args[1].str == "" {
+			return args[0], nil
+		}
+		return k3Value{str: strings.ReplaceAll(args[0].str, args[1].str, args[2].str)}, nil
+	case "ABS", "ROUND", "FLOOR", "CEIL":
+		return numericFunction(expr, args)
+	}
+	return k3Value{}, fmt.Errorf("%s: unknown function %s", shared.InvalidSQLLogic, strings.ToLower(expr.Operator))
+}
+
+func substr(args []k3Value) (k3Value, error) {
+	runes := []rune(args[0].str)
+	start, err := strconv.Atoi(args[1].str)
+	if err != nil {
+		return k3Value{}, err
+	}
+	end := len(runes) + 1
+	if len(args) > 2 {
+		length, err := strconv.Atoi(args[2].str)
+		if err != nil {
+			return k3Value{}, err
+		}
+		if length < 0 {
+			return k3Value{}, fmt.Errorf("%s: negative substring length not allowed", shared.InvalidSQLLogic)
+		}
+		end = min(end, start+length)
+	}
+	start = max(start, 1)
+	if start >= end {
+		return k3Value{str: ""}, nil
+	}
+	return k3Value{str: string(runes[start-1 : end-1])}, nil
+}
+
+func randomUUID() (k3Value, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return k3Value{}, err
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	uuid, err := CanonicalValue(shared.K3UUID, hex.EncodeToString(id[:]))
+	return k3Value{str: uuid}, err
+}
+
+func numericFunction(expr *shared.K3Expr, args []k3Value) (k3Value, error) {
+	if expr.Type == shared.K3DECIMAL {
+		return decimalFunction(expr, args)
+	}
+	if expr.Type == shared.K3INT {
+		n, err := strconv.ParseInt(args[0].str, 10, 64)
+		if err != nil {
+			return k3Value{}, err
+		}
+		if expr.Operator == "ABS" && n < 0 {
+			if n, err = intArithmetic("-", 0, n); err != nil {
+				return k3Value{}, err
+			}
+		}
+		return k3Value{str: strconv.FormatInt(n, 10)}, nil
+	}
+	f, err := strconv.ParseFloat(args[0].str, 64)
+	if err != nil {
+		return k3Value{}, err
+	}
+	switch expr.Operator {
+	case "ABS":
+		f = math.Abs(f)
+	case "FLOOR":
+		f = math.Floor(f)
+	case "CEIL":
+		f = math.Ceil(f)
+	case "ROUND":
+		scale := 1.0
+		if len(args) > 1 {
+			digits, err := strconv.Atoi(args[1].str)
+			if err != nil {
+				return k3Value{}, err
+			}
+			scale = math.Pow(10, float64(digits))
+		}
+		// Past the range of a FLOAT, rounding keeps every digit or none.
+		switch {
+		case scale == 0:
+			f = 0
+		case !math.IsInf(f*scale, 0):
+			f = math.Round(f*scale) / scale
+		}
+	}
+	text, err := formatFloat(f)
+	return k3Value{str: text}, err
+}
+
+func evalCase(expr *shared.K3Expr, record map[string]string) (k3Value, error) {
+	for i := 0; i+1 < len(expr.Args); i += 2 {
+		ok, err := satisfiesConditions(record, expr.Args[i])
+		if err != nil {
+			return k3Value{}, err
+		}
+		if ok {
+			return evalExpr(expr.Args[i+1], record)
+		}
+	}
+	if len(expr.Args)%2 == 1 {
+		return evalExpr(expr.Args[len(expr.Args)-1], record)
+	}
+	return k3Value{null: true}, nil
+}
+
+func evalCast(expr *shared.K3Expr, record map[string]string) (k3Value, error) {
+	value, err := evalExpr(expr.Args[0], record)
+	if err != nil || value.null {
+		return value, err
+	}
+	source := expr.Args[0].Type
+	switch expr.Type {
+	case shared.K3INT:
+		text := strings.TrimSpace(value.str)
+		if _, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return k3Value{str: text}, nil
+		}
+		switch source {
+		case shared.K3FLOAT:
+			if f, err := strconv.ParseFloat(text, 64); err == nil && math.Abs(f) < math.MaxInt64 {
+				return k3Value{str: strconv.FormatInt(int64(math.Round(f)), 10)}, nil
+			}
+		case shared.K3DECIMAL:
+			if r, ok := parseDecimal(text); ok {
+				if n := roundDecimal(r, 0).Num(); n.IsInt64() {
+					return k3Value{str: n.String()}, nil
+				}
+			}
+		case shared.K3BOOLEAN:
+			if value.str == "true" {
+				return k3Value{str: "1"}, nil
+			}
+			return k3Value{str: "0"}, nil
+		}
+		return k3Value{}, fmt.Errorf("%s: invalid input for type INT: %q", shared.InvalidSQLLogic, value.str)
+	case shared.K3FLOAT:
+		text, err := CanonicalValue(shared.K3FLOAT, strings.TrimSpace(value.str))
+		if err != nil {
+			return k3Value{}, fmt.Errorf("%s: invalid input for type FLOAT: %q", shared.InvalidSQLLogic, value.str)
+		}
+		return k3Value{str: text}, nil
+	case shared.K3BOOLEAN:
+		if n, err := strconv.Atoi(value.str); err == nil && source == shared.K3INT {
+			return k3Value{str: strconv.FormatBool(n != 0)}, nil
+		}
+	case shared.K3DATE:
+		if source == shared.K3TIMESTAMP {
+			return k3Value{str: value.str[:len(time.DateOnly)]}, nil
+		}
+	case shared.K3TEXT:
+		return value, nil
+	}
+	canonical, err := CanonicalValue(expr.Type, value.str)
+	if err != nil {
+		return k3Value{}, fmt.Errorf("%s: %w", shared.InvalidSQLLogic, err)
+	}
+	return k3Value{str: canonical}, nil
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"k3SQLServer/shared"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// migratedExtension is added to the name of a converted table file until it
+// replaces the old one.
+const migratedExtension = ".migrated"
+
+// MigrateDataFiles brings the table files of every database up to the
+// current format version.
+func MigrateDataFiles() error {
+	return migrateDataFiles(shared.K3DataPath, shared.FormatFile, shared.MigrationFile)
+}
+
+// migrateDataFiles converts every file before it replaces any of them, so a
+// crash never leaves a mix of old and new files behind: until migrationFile
+// is written the old files are untouched and the conversion starts over, and
+// after that only the renames that are left are done again.
+func migrateDataFiles(dataPath, formatFile, migrationFile string) error {
+	version := 1
+	data, err := os.ReadFile(formatFile)
+	if err == nil {
+		if version, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil {
+			return errors.New(shared.FileFormatError)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if version >= shared.FormatVersion {
+		return nil
+	}
+	paths, err := filepath.Glob(dataPath + "*/*" + shared.Extension)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(migrationFile); errors.Is(err, os.ErrNotExist) {
+		for _, path := range paths {
+			if err := escapeTableFile(path, path+migratedExtension); err != nil {
+				return err
+			}
+		}
+		if err := writeFileAtomic(migrationFile, nil); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		err := os.Rename(path+migratedExtension, path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := writeFormatVersion(formatFile); err != nil {
+		return err
+	}
+	return os.Remove(migrationFile)
+}
+
+func WriteFormatVersion() error {
+	return writeFormatVersion(shared.FormatFile)
+}
+
+func writeFormatVersion(formatFile string) error {
+	return writeFileAtomic(formatFile, []byte(strconv.Itoa(shared.FormatVersion)+"\n"))
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	tempPath := path + ".tmp"
+	defer os.Remove(tempPath)
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// escapeTableFile writes a version 1 file, whose values were stored as they
+// are, to newPath with escaped values. Rows that a delimiter inside a value
+// has already split are kept as they read.
+func escapeTableFile(path, newPath string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	newFile, err := os.Create(newPath)
+	if err != nil {
+		return err
+	}
+	defer newFile.Close()
+	scanner := bufio.NewScanner(file)
+	writer := bufio.NewWriter(newFile)
+	if !scanner.Scan() {
+		return errors.New(shared.FileFormatError)
+	}
+	if _, err := writer.WriteString(scanner.Text() + "\n"); err != nil {
+		return err
+	}
+	for scanner.Scan() {
+		values := strings.Split(scanner.Text(), "|")
+		for i, value := range values {
+			if value != shared.NullMarker {
+				values[i] = escapeValue(value)
+			}
+		}
+		if _, err := writer.WriteString(strings.Join(values, "|") + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return newFile.Sync()
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"errors"
+	"k3SQLServer/shared"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+const v1Table = "int id|text name|text note\n" +
+	"1|a\\b|\\N\n" +
+	"2|plain|\n"
+
+const v2Table = "int id|text name|text note\n" +
+	"1|a\\\\b|\\N\n" +
+	"2|plain|\n"
+
+type migrationPaths struct {
+	data, format, migration, table string
+}
+
+func newMigrationPaths(t *testing.T) migrationPaths {
+	dir := t.TempDir()
+	paths := migrationPaths{
+		data:      dir + "/data/",
+		format:    dir + "/config/format",
+		migration: dir + "/config/migrating",
+	}
+	paths.table = paths.data + "db/t" + shared.Extension
+	if err := os.MkdirAll(filepath.Dir(paths.table), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(paths.table, []byte(v1Table), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func (p migrationPaths) migrate(t *testing.T) {
+	t.Helper()
+	if err := migrateDataFiles(p.data, p.format, p.migration); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (p migrationPaths) check(t *testing.T, want string) {
+	t.Helper()
+	data, err := os.ReadFile(p.table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("table file = %q, want %q", data, want)
+	}
+	version, err := os.ReadFile(p.format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strconv.Itoa(shared.FormatVersion) + "\n"; string(version) != want {
+		t.Errorf("format file = %q, want %q", version, want)
+	}
+	for _, path := range []string{p.migration, p.table + migratedExtension} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s is left behind", path)
+		}
+	}
+}
+
+func TestMigrateDataFiles(t *testing.T) {
+	paths := newMigrationPaths(t)
+	paths.migrate(t)
+	paths.check(t, v2Table)
+	// The format file now records the new version, so a second run must
+	// not escape the values again.
+	paths.migrate(t)
+	paths.check(t, v2Table)
+}
+
+func TestMigrateDataFilesAfterCrash(t *testing.T) {
+	// A crash while the files were being converted leaves the old ones as
+	// they were, so the conversion starts over.
+	paths := newMigrationPaths(t)
+	if err := os.WriteFile(paths.table+migratedExtension, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	paths.migrate(t)
+	paths.check(t, v2Table)
+
+	// A crash after some files were replaced only finishes the renames.
+	paths = newMigrationPaths(t)
+	if err := escapeTableFile(paths.table, paths.table+migratedExtension); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(paths.migration, nil); err != nil {
+		t.Fatal(err)
+	}
+	other := paths.data + "db/u" + shared.Extension
+	if err := os.WriteFile(other, []byte(v2Table), 0644); err != nil {
+		t.Fatal(err)
+	}
+	paths.migrate(t)
+	paths.check(t, v2Table)
+	if data, err := os.ReadFile(other); err != nil || string(data) != v2Table {
+		t.Errorf("replaced table file = %q (%v), want %q", data, err, v2Table)
+	}
+}
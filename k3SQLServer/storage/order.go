@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"cmp"
+	"container/heap"
+	"k3SQLServer/shared"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type sortedRow struct {
+	record map[string]string
+	keys   []k3Value
+	seq    int
+}
+
+type rowSorter struct {
+	orderBy []shared.K3OrderBy
+	keep    int
+	rows    []*sortedRow
+	seq     int
+}
+
+func newRowSorter(orderBy []shared.K3OrderBy, keep int) *rowSorter {
+	return &rowSorter{orderBy: orderBy, keep: keep}
+}
+
+func (s *rowSorter) full() bool {
+	return len(s.orderBy) == 0 && s.keep >= 0 && len(s.rows) >= s.keep
+}
+
+func (s *rowSorter) add(record map[string]string) error {
+	row := &sortedRow{record: record, keys: make([]k3Value, len(s.orderBy)), seq: s.seq}
+	s.seq++
+	for i, order := range s.orderBy {
+		key, err := evalExpr(order.Expr, record)
+		if err != nil {
+			return err
+		}
+		row.keys[i] = key
+	}
+	if len(s.orderBy) == 0 || s.keep < 0 {
+		s.rows = append(s.rows, row)
+		return nil
+	}
+	heap.Push(s, row)
+	if len(s.rows) > s.keep {
+		heap.Pop(s)
+	}
+	return nil
+}
+
+func (s *rowSorter) sorted() []map[string]string {
+	if len(s.orderBy) > 0 {
+		sort.Slice(s.rows, func(i, j int) bool {
+			return s.less(s.rows[i], s.rows[j])
+		})
+	}
+	records := make([]map[string]string, len(s.rows))
+	for i, row := range s.rows {
+		records[i] = row.record
+	}
+	return records
+}
+
+func (s *rowSorter) less(a, b *sortedRow) bool {
+	for i, order := range s.orderBy {
+		if a.keys[i].null != b.keys[i].null {
+			return a.keys[i].null == order.NullsFirst
+		}
+		c := compareTyped(a.keys[i], b.keys[i], order.Expr.Type)
+		if c != 0 {
+			if order.Desc {
+				return c > 0
+			}
+			return c < 0
+		}
+	}
+	return a.seq < b.seq
+}
+
+// The heap keeps the rows that sort last on top, so a LIMIT only ever holds
+// OFFSET+LIMIT rows in memory.
+func (s *rowSorter) Len() int           { return len(s.rows) }
+func (s *rowSorter) Less(i, j int) bool { return s.less(s.rows[j], s.rows[i]) }
+func (s *rowSorter) Swap(i, j int)      { s.rows[i], s.rows[j] = s.rows[j], s.rows[i] }
+func (s *rowSorter) Push(x any)         { s.rows = append(s.rows, x.(*sortedRow)) }
+func (s *rowSorter) Pop() any {
+	row := s.rows[len(s.rows)-1]
+	s.rows = s.rows[:len(s.rows)-1]
+	return row
+}
+
+func compareTyped(a, b k3Value, valueType int) int {
+	switch {
+	case a.null && b.null:
+		return 0
+	case a.null:
+		return 1
+	case b.null:
+		return -1
+	}
+	switch valueType {
+	case shared.K3INT:
+		intA, errA := strconv.ParseInt(a.str, 10, 64)
+		intB, errB := strconv.ParseInt(b.str, 10, 64)
+		if errA == nil && errB == nil {
+			return cmp.Compare(intA, intB)
+		}
+		fallthrough
+	case shared.K3FLOAT:
+		numA, errA := strconv.ParseFloat(a.str, 64)
+		numB, errB := strconv.ParseFloat(b.str, 64)
+		if errA == nil && errB == nil {
+			return cmp.Compare(numA, numB)
+		}
+	case shared.K3DECIMAL:
+		if c, ok := compareDecimals(a.str, b.str); ok {
+			return c
+		}
+	case shared.K3TIMESTAMP:
+		// a DATE compared with a TIMESTAMP stands for its midnight
+		return strings.Compare(timestampValue(a.str), timestampValue(b.str))
+	}
+	return strings.Compare(a.str, b.str)
+}
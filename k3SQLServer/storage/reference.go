@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"k3SQLServer/shared"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoadTable returns the cached table, reading its definition from disk on
+// first use.
+func LoadTable(db, name string) (*shared.K3Table, error) {
+	table, ok := shared.K3Tables[db+"."+name]
+	if !ok {
+		filePath := shared.K3DataPath + db + "/" + name + shared.Extension
+		if _, err := os.Stat(filePath); err != nil {
+			return nil, errors.New(shared.TableNotExists)
+		}
+		tableFile := &shared.K3Table{Name: name, Database: db, Mu: new(sync.RWMutex), LU: time.Now()}
+		if err := AddFieldsTableFile(tableFile); err != nil {
+			return nil, err
+		}
+		shared.K3Tables[tableFile.Database+"."+tableFile.Name] = tableFile
+		table = tableFile
+	}
+	table.LU = time.Now()
+	return table, nil
+}
+
+// ReferencingTables lists the tables of the database with a foreign key to
+// table, including table itself when it references its own keys.
+func ReferencingTables(table *shared.K3Table) ([]*shared.K3Table, error) {
+	paths, err := filepath.Glob(shared.K3DataPath + table.Database + "/*" + shared.SchemaExtension)
+	if err != nil {
+		return nil, err
+	}
+	var tables []*shared.K3Table
+	for _, path := range paths {
+		child, err := LoadTable(table.Database, strings.TrimSuffix(filepath.Base(path), shared.SchemaExtension))
+		if err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(child.Constraints, func(c *shared.K3Constraint) bool {
+			return c.Kind == shared.K3ForeignKey && c.RefTable == table.Name
+		}) {
+			tables = append(tables, child)
+		}
+	}
+	return tables, nil
+}
+
+// PrepareInsert fills in the defaults of the columns missing from the rows
+// of query, so that they can be checked before the table is locked, and runs
+// its subqueries before the parent tables are.
+func PrepareInsert(query *shared.K3InsertQuery) error {
+	for _, row := range query.Values {
+		for _, field := range query.Table.Fields {
+			if _, ok := row[field]; ok || slices.Contains(query.Columns, field) {
+				continue
+			}
+			value, err := columnDefault(query.Table, field)
+			if err != nil {
+				return err
+			}
+			if !value.null {
+				row[field] = value.str
+			}
+		}
+	}
+	query.Columns = query.Table.Fields
+	return materializeInsert(query)
+}
+
+// referencedKey finds the unique constraint of parent that fk points to and
+// returns it with the child columns in the order of its index key.
+func referencedKey(parent *shared.K3Table, fk *shared.K3Constraint) (*shared.K3Constraint, []string) {
+	for _, constraint := range parent.Constraints {
+		if !isUnique(constraint) || len(constraint.Columns) != len(fk.RefColumns) {
+			continue
+		}
+		columns := make([]string, len(constraint.Columns))
+		for i, column := range constraint.Columns {
+			j := slices.Index(fk.RefColumns, column)
+			if j < 0 {
+				columns = nil
+				break
+			}
+			columns[i] = fk.Columns[j]
+		}
+		if columns != nil {
+			return constraint, columns
+		}
+	}
+	return nil, nil
+}
+
+// CheckReferences verifies that the foreign keys of records exist in their
+// parent tables. A key with a NULL column references nothing. Keys of a self
+// referencing table may also be provided by records themselves. The parent
+// tables stay read locked until release is called, so that the keys cannot
+// be deleted before records are written; a self referencing table is only
+// locked while it is checked.
+func CheckReferences(table *shared.K3Table, records []map[string]string) (release func(), err error) {
+	referenceMu.Lock()
+	parents, err := lockParents(table)
+	if err != nil {
+		referenceMu.Unlock()
+		return nil, err
+	}
+	release = func() {
+		for _, parent := range parents {
+			parent.Mu.RUnlock()
+		}
+		referenceMu.Unlock()
+	}
+	for _, fk := range table.Constraints {
+		if fk.Kind != shared.K3ForeignKey {
+			continue
+		}
+		parent := table
+		if fk.RefTable != table.Name {
+			parent = parents[fk.RefTable]
+		}
+		key, columns := referencedKey(parent, fk)
+		if key == nil {
+			release()
+			return nil, fmt.Errorf("%s: referenced key of constraint %s is missing in table %s", shared.ForeignKeyViolation, fk.Name, parent.Name)
+		}
+		batch := make(map[string]bool)
+		if parent == table {
+			for _, record := range records {
+				if !slices.ContainsFunc(key.Columns, func(column string) bool { return IsNull(record, column) }) {
+					batch[indexKey(record, key.Columns, table.Types)] = true
+				}
+			}
+			table.Mu.Lock()
+			err = loadIndexes(table)
+			if err == nil {
+				err = checkKeys(table, fk, columns, table.Types, records, table.Indexes[key.Name], batch)
+			}
+			table.Mu.Unlock()
+		} else {
+			err = checkKeys(parent, fk, columns, table.Types, records, parent.Indexes[key.Name], batch)
+		}
+		if err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// lockParents read locks the tables that the foreign keys of table point to,
+// other than table itself, once their unique indexes are loaded.
+func lockParents(table *shared.K3Table) (map[string]*shared.K3Table, error) {
+	parents := make(map[string]*shared.K3Table)
+	for _, fk := range table.Constraints {
+		if fk.Kind != shared.K3ForeignKey || fk.RefTable == table.Name || parents[fk.RefTable] != nil {
+			continue
+		}
+		parent, err := LoadTable(table.Database, fk.RefTable)
+		if err != nil {
+			return nil, err
+		}
+		parents[fk.RefTable] = parent
+	}
+	names := slices.Sorted(maps.Keys(parents))
+	for i, name := range names {
+		parent := parents[name]
+		parent.Mu.Lock()
+		err := loadIndexes(parent)
+		parent.Mu.Unlock()
+		if err != nil {
+			for _, locked := range names[:i] {
+				parents[locked].Mu.RUnlock()
+			}
+			return nil, err
+		}
+		parent.Mu.RLock()
+	}
+	return parents, nil
+}
+
+// checkKeys verifies that every complete foreign key of records, taken from
+// columns, is one of the keys of parent, found in keys or batch.
+func checkKeys(parent *shared.K3Table, fk *shared.K3Constraint, columns []string, types map[string]int, records []map[string]string, keys, batch map[string]bool) error {
+	for _, record := range records {
+		if slices.ContainsFunc(columns, func(column string) bool { return IsNull(record, column) }) {
+			continue
+		}
+		k := indexKey(record, columns, types)
+		if keys[k] || batch[k] {
+			continue
+		}
+		values := make([]string, len(fk.Columns))
+		for i, column := range fk.Columns {
+			values[i] = record[column]
+		}
+		return fmt.Errorf("%s: key (%s)=(%s) is not present in table %s (constraint %s)", shared.ForeignKeyViolation,
+			strings.Join(fk.Columns, ", "), strings.Join(values, ", "), parent.Name, fk.Name)
+	}
+	return nil
+}
+
+// SetConstraints replaces the constraints of table, used when a table it
+// references is renamed.
+func SetConstraints(table *shared.K3Table, constraints []*shared.K3Constraint) error {
+	table.Mu.Lock()
+	defer table.Mu.Unlock()
+	if err := writeSchema(table, constraints); err != nil {
+		return err
+	}
+	table.Constraints = constraints
+	return nil
+}
+
+// PrepareUpdate runs the subqueries of query that do not depend on the
+// updated rows, before its table is locked.
+func PrepareUpdate(query *shared.K3UpdateQuery) error {
+	if err := materializeReturning(query.Returning); err != nil {
+		return err
+	}
+	for _, expr := range query.SetValues {
+		if err := materializeSubqueries(expr); err != nil {
+			return err
+		}
+	}
+	return materializeSubqueries(query.Where)
+}
+
+// PrepareDelete runs the subqueries of query that do not depend on the
+// deleted rows, before its table is locked.
+func PrepareDelete(query *shared.K3DeleteQuery) error {
+	if err := materializeReturning(query.Returning); err != nil {
+		return err
+	}
+	return materializeSubqueries(query.Where)
+}
@@ -0,0 +1,25 @@
+package storage
+
+import "k3SQLServer/shared"
+
+func materializeReturning(returning *shared.K3SelectQuery) error {
+	if returning == nil {
+		return nil
+	}
+	return materializeSubqueries(QueryExprs(returning)...)
+}
+
+func returningRows(returning *shared.K3SelectQuery, records []map[string]string) ([]map[string]string, error) {
+	if returning == nil {
+		return nil, nil
+	}
+	var rows []map[string]string
+	for _, record := range records {
+		row, err := projectRecord(record, returning.Values, returning.Columns)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
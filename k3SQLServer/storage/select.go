@@ -0,0 +1,435 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"k3SQLServer/shared"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type selectPipeline struct {
+	query   *shared.K3SelectQuery
+	groups  *grouper
+	sorter  *rowSorter
+	seen    map[string]bool
+	outer   map[string]string
+	pending []map[string]string
+}
+
+func SelectTableFile(query *shared.K3SelectQuery) ([]map[string]string, int, error) {
+	results, err := selectRows(query, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return results, len(results), nil
+}
+
+func selectRows(query *shared.K3SelectQuery, outer map[string]string) ([]map[string]string, error) {
+	if err := materializeSubqueries(QueryExprs(query)...); err != nil {
+		return nil, err
+	}
+	pipeline := newSelectPipeline(query)
+	pipeline.outer = outer
+	var err error
+	if query.SetOp != nil {
+		err = combineRows(query, outer, pipeline.add)
+	} else if len(query.Joins) == 0 {
+		err = scanTable(query.Table, "", pipeline.add)
+	} else {
+		err = joinTables(query, pipeline.add)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pipeline.results()
+}
+
+func newSelectPipeline(query *shared.K3SelectQuery) *selectPipeline {
+	pipeline := &selectPipeline{query: query}
+	if len(query.GroupBy) > 0 || len(query.Aggregates) > 0 || query.Having != nil {
+		pipeline.groups = newGrouper(query)
+	}
+	if query.Distinct {
+		pipeline.seen = make(map[string]bool)
+	}
+	keep := -1
+	if query.HasLimit {
+		keep = query.Offset + query.Limit
+	}
+	pipeline.sorter = newRowSorter(query.OrderBy, keep)
+	return pipeline
+}
+
+func (p *selectPipeline) add(record map[string]string) (bool, error) {
+	if p.groups == nil && p.sorter.full() {
+		return false, nil
+	}
+	for k, v := range p.outer {
+		record[k] = v
+	}
+	ok, err := satisfiesConditions(record, p.query.Where)
+	if err != nil || !ok {
+		return err == nil, err
+	}
+	if p.groups != nil {
+		return true, p.groups.add(record)
+	}
+	if err := p.output(record); err != nil {
+		return false, err
+	}
+	return !p.sorter.full(), nil
+}
+
+func (p *selectPipeline) output(record map[string]string) error {
+	if len(p.query.Windows) > 0 {
+		p.pending = append(p.pending, record)
+		return nil
+	}
+	return p.emit(record)
+}
+
+func (p *selectPipeline) emit(record map[string]string) error {
+	if p.seen != nil {
+		values := make([]k3Value, len(p.query.Values))
+		for i, value := range p.query.Values {
+			result, err := evalExpr(value, record)
+			if err != nil {
+				return err
+			}
+			values[i] = keyValue(value.Type, result)
+		}
+		key := valuesKey(values)
+		if p.seen[key] {
+			return nil
+		}
+		p.seen[key] = true
+	}
+	return p.sorter.add(record)
+}
+
+// keyValue is value in the form that every value of valueType equal to it
+// shares, for keys that tell values apart.
+func keyValue(valueType int, value k3Value) k3Value {
+	if !value.null {
+		value.str = indexValue(valueType, value.str)
+	}
+	return value
+}
+
+func valuesKey(values []k3Value) string {
+	var key strings.Builder
+	for _, value := range values {
+		if value.null {
+			key.WriteString("N;")
+		} else {
+			key.WriteString(strconv.Itoa(len(value.str)) + ":" + value.str)
+		}
+	}
+	return key.String()
+}
+
+func (p *selectPipeline) results() ([]map[string]string, error) {
+	if p.groups != nil {
+		records, err := p.groups.results()
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			for k, v := range p.outer {
+				record[k] = v
+			}
+			ok, err := satisfiesConditions(record, p.query.Having)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				if err := p.output(record); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if len(p.query.Windows) > 0 {
+		if err := computeWindows(p.query.Windows, p.pending); err != nil {
+			return nil, err
+		}
+		for _, record := range p.pending {
+			if err := p.emit(record); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// The sorter only stops early for plain scans; grouped and windowed rows
+	// all reach it, so OFFSET and LIMIT are applied here in every case.
+	records := p.sorter.sorted()
+	records = records[min(p.query.Offset, len(records)):]
+	if p.query.HasLimit && len(records) > p.query.Limit {
+		records = records[:p.query.Limit]
+	}
+	var results []map[string]string
+	for _, record := range records {
+		filteredRecord, err := projectRecord(record, p.query.Values, p.query.Columns)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, filteredRecord)
+	}
+	return results, nil
+}
+
+func projectRecord(record map[string]string, values []*shared.K3Expr, columns []string) (map[string]string, error) {
+	filteredRecord := make(map[string]string, len(values))
+	for i, value := range values {
+		result, err := evalExpr(value, record)
+		if err != nil {
+			return nil, err
+		}
+		if !result.null {
+			filteredRecord[columns[i]] = result.str
+		}
+	}
+	return filteredRecord, nil
+}
+
+func scanTable(table *shared.K3Table, qualifier string, fn func(map[string]string) (bool, error)) error {
+	if table.CTE != nil {
+		return scanCTE(table, qualifier, fn)
+	}
+	table.Mu.RLock()
+	defer table.Mu.RUnlock()
+	file, err := os.Open(shared.K3DataPath + table.Database + "/" + table.Name + shared.Extension)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return errors.New(shared.FileFormatError)
+	}
+	for scanner.Scan() {
+		record := parseRecord(scanner.Text(), table.Fields, table.Types)
+		if qualifier != "" {
+			qualified := make(map[string]string, len(record))
+			for k, v := range record {
+				qualified[qualifier+"."+k] = v
+			}
+			record = qualified
+		}
+		next, err := fn(record)
+		if err != nil {
+			return err
+		}
+		if !next {
+			break
+		}
+	}
+	return scanner.Err()
+}
+
+func scanCTE(table *shared.K3Table, qualifier string, fn func(map[string]string) (bool, error)) error {
+	rows, err := materializeCTE(table)
+	if err != nil {
+		return err
+	}
+	if qualifier != "" {
+		qualifier += "."
+	}
+	for _, row := range rows {
+		record := make(map[string]string, len(row))
+		for k, v := range row {
+			record[qualifier+k] = v
+		}
+		next, err := fn(record)
+		if err != nil {
+			return err
+		}
+		if !next {
+			break
+		}
+	}
+	return nil
+}
+
+func loadTable(table *shared.K3Table, qualifier string) ([]map[string]string, error) {
+	var records []map[string]string
+	err := scanTable(table, qualifier, func(record map[string]string) (bool, error) {
+		records = append(records, record)
+		return true, nil
+	})
+	return records, err
+}
+
+func joinTables(query *shared.K3SelectQuery, fn func(map[string]string) (bool, error)) error {
+	rows, err := loadTable(query.Table, query.Alias)
+	if err != nil {
+		return err
+	}
+	for _, join := range query.Joins {
+		right, err := loadTable(join.Table, join.Alias)
+		if err != nil {
+			return err
+		}
+		if rows, err = joinRows(rows, right, join); err != nil {
+			return err
+		}
+	}
+	for _, row := range rows {
+		next, err := fn(row)
+		if err != nil {
+			return err
+		}
+		if !next {
+			break
+		}
+	}
+	return nil
+}
+
+func joinRows(left, right []map[string]string, join shared.K3Join) ([]map[string]string, error) {
+	candidates := func(map[string]string) ([]int, error) {
+		all := make([]int, len(right))
+		for i := range right {
+			all[i] = i
+		}
+		return all, nil
+	}
+	leftKeys, rightKeys := equiJoinKeys(join.On, join.Alias)
+	if len(leftKeys) > 0 {
+		keyTypes := make([]int, len(leftKeys))
+		for i := range leftKeys {
+			keyTypes[i], _ = ComparisonType(leftKeys[i].Type, rightKeys[i].Type)
+		}
+		index := make(map[string][]int)
+		for i, row := range right {
+			key, null, err := joinKey(rightKeys, keyTypes, row)
+			if err != nil {
+				return nil, err
+			}
+			if !null {
+				index[key] = append(index[key], i)
+			}
+		}
+		candidates = func(row map[string]string) ([]int, error) {
+			key, null, err := joinKey(leftKeys, keyTypes, row)
+			if err != nil || null {
+				return nil, err
+			}
+			return index[key], nil
+		}
+	}
+	var result []map[string]string
+	matchedRight := make([]bool, len(right))
+	for _, l := range left {
+		matches, err := candidates(l)
+		if err != nil {
+			return nil, err
+		}
+		matched := false
+		for _, i := range matches {
+			row := mergeRecords(l, right[i])
+			ok, err := satisfiesConditions(row, join.On)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				result = append(result, row)
+				matched = true
+				matchedRight[i] = true
+			}
+		}
+		if !matched && (join.Type == shared.K3LeftJoin || join.Type == shared.K3FullJoin) {
+			result = append(result, mergeRecords(l, nil))
+		}
+	}
+	if join.Type == shared.K3RightJoin || join.Type == shared.K3FullJoin {
+		for i, r := range right {
+			if !matchedRight[i] {
+				result = append(result, mergeRecords(nil, r))
+			}
+		}
+	}
+	return result, nil
+}
+
+func mergeRecords(left, right map[string]string) map[string]string {
+	row := make(map[string]string, len(left)+len(right))
+	for k, v := range left {
+		row[k] = v
+	}
+	for k, v := range right {
+		row[k] = v
+	}
+	return row
+}
+
+// equiJoinKeys picks the "left = right" conjuncts of an ON condition so the
+// join can be answered with a hash lookup instead of a nested loop.
+func equiJoinKeys(on *shared.K3Expr, rightAlias string) ([]*shared.K3Expr, []*shared.K3Expr) {
+	if on == nil {
+		return nil, nil
+	}
+	if on.Kind == shared.K3ExprAnd {
+		leftA, rightA := equiJoinKeys(on.Args[0], rightAlias)
+		leftB, rightB := equiJoinKeys(on.Args[1], rightAlias)
+		return append(leftA, leftB...), append(rightA, rightB...)
+	}
+	if on.Kind != shared.K3ExprCompare || on.Operator != "=" {
+		return nil, nil
+	}
+	a, b := exprSide(on.Args[0], rightAlias), exprSide(on.Args[1], rightAlias)
+	switch {
+	case a == sideLeft && b == sideRight:
+		return []*shared.K3Expr{on.Args[0]}, []*shared.K3Expr{on.Args[1]}
+	case a == sideRight && b == sideLeft:
+		return []*shared.K3Expr{on.Args[1]}, []*shared.K3Expr{on.Args[0]}
+	}
+	return nil, nil
+}
+
+const (
+	sideNone = iota
+	sideLeft
+	sideRight
+	sideBoth
+)
+
+func exprSide(expr *shared.K3Expr, rightAlias string) int {
+	side := sideNone
+	if expr.Kind == shared.K3ExprColumn {
+		if expr.Table == rightAlias {
+			side = sideRight
+		} else {
+			side = sideLeft
+		}
+	}
+	for _, arg := range expr.Args {
+		argSide := exprSide(arg, rightAlias)
+		if argSide != sideNone && side != sideNone && argSide != side {
+			return sideBoth
+		}
+		if argSide != sideNone {
+			side = argSide
+		}
+	}
+	return side
+}
+
+// joinKey writes the values of keys in their index form, so that rows whose
+// keys compare equal share a key.
+func joinKey(keys []*shared.K3Expr, types []int, row map[string]string) (string, bool, error) {
+	var key strings.Builder
+	for i, expr := range keys {
+		value, err := evalExpr(expr, row)
+		if err != nil {
+			return "", false, err
+		}
+		if value.null {
+			return "", true, nil
+		}
+		value.str = indexValue(types[i], value.str)
+		key.WriteString(strconv.Itoa(len(value.str)) + ":" + value.str)
+	}
+	return key.String(), false, nil
+}
@@ -0,0 +1,110 @@
+package storage
+
+import "k3SQLServer/shared"
+
+type countedRow struct {
+	record map[string]string
+	count  int
+}
+
+type countedRows struct {
+	rows  map[string]*countedRow
+	order []string
+}
+
+func combineRows(query *shared.K3SelectQuery, outer map[string]string, fn func(map[string]string) (bool, error)) error {
+	setOp := query.SetOp
+	left, err := selectRows(setOp.Left, outer)
+	if err != nil {
+		return err
+	}
+	right, err := selectRows(setOp.Right, outer)
+	if err != nil {
+		return err
+	}
+	leftRows := countRows(left, setOp.Left.Columns, query)
+	rightRows := countRows(right, setOp.Right.Columns, query)
+	var records []map[string]string
+	emit := func(row *countedRow, count int) {
+		if !setOp.All {
+			count = min(count, 1)
+		}
+		for range count {
+			records = append(records, row.record)
+		}
+	}
+	switch setOp.Operator {
+	case "UNION":
+		for _, key := range rightRows.order {
+			if row, ok := leftRows.rows[key]; ok {
+				row.count += rightRows.rows[key].count
+			} else {
+				leftRows.add(key, rightRows.rows[key])
+			}
+		}
+		for _, key := range leftRows.order {
+			emit(leftRows.rows[key], leftRows.rows[key].count)
+		}
+	case "INTERSECT":
+		for _, key := range leftRows.order {
+			if other, ok := rightRows.rows[key]; ok {
+				emit(leftRows.rows[key], min(leftRows.rows[key].count, other.count))
+			}
+		}
+	case "EXCEPT":
+		for _, key := range leftRows.order {
+			count := leftRows.rows[key].count
+			if other, ok := rightRows.rows[key]; ok {
+				if !setOp.All {
+					continue
+				}
+				count -= other.count
+			}
+			emit(leftRows.rows[key], count)
+		}
+	}
+	for _, record := range records {
+		next, err := fn(record)
+		if err != nil {
+			return err
+		}
+		if !next {
+			break
+		}
+	}
+	return nil
+}
+
+func (c *countedRows) add(key string, row *countedRow) {
+	c.rows[key] = row
+	c.order = append(c.order, key)
+}
+
+func countRows(results []map[string]string, from []string, query *shared.K3SelectQuery) *countedRows {
+	counted := &countedRows{rows: make(map[string]*countedRow)}
+	for _, result := range results {
+		record := make(map[string]string, len(query.Columns))
+		values := make([]k3Value, len(query.Columns))
+		for i, column := range query.Columns {
+			value, ok := result[from[i]]
+			if !ok {
+				values[i] = k3Value{null: true}
+				continue
+			}
+			if query.Values[i].Type == shared.K3FLOAT {
+				if canonical, err := CanonicalValue(shared.K3FLOAT, value); err == nil {
+					value = canonical
+				}
+			}
+			record[column] = value
+			values[i] = k3Value{str: value}
+		}
+		key := valuesKey(values)
+		if row, ok := counted.rows[key]; ok {
+			row.count++
+		} else {
+			counted.add(key, &countedRow{record: record, count: 1})
+		}
+	}
+	return counted
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"fmt"
+	"k3SQLServer/shared"
+)
+
+func QueryExprs(query *shared.K3SelectQuery) []*shared.K3Expr {
+	exprs := []*shared.K3Expr{query.Where, query.Having}
+	for _, join := range query.Joins {
+		exprs = append(exprs, join.On)
+	}
+	exprs = append(exprs, query.Values...)
+	exprs = append(exprs, query.GroupBy...)
+	exprs = append(exprs, query.Aggregates...)
+	for _, window := range query.Windows {
+		exprs = append(exprs, window)
+		exprs = append(exprs, window.Window.PartitionBy...)
+		for _, order := range window.Window.OrderBy {
+			exprs = append(exprs, order.Expr)
+		}
+	}
+	for _, order := range query.OrderBy {
+		exprs = append(exprs, order.Expr)
+	}
+	return exprs
+}
+
+func materializeSubqueries(exprs ...*shared.K3Expr) error {
+	for _, expr := range exprs {
+		if expr == nil {
+			continue
+		}
+		if expr.Subquery != nil && !expr.Subquery.Correlated {
+			values, err := subqueryValues(expr, nil)
+			if err != nil {
+				return err
+			}
+			if expr.Kind == shared.K3ExprSubquery && len(values) > 1 {
+				return fmt.Errorf("%s: more than one row returned by a subquery used as an expression", shared.InvalidSQLLogic)
+			}
+			for _, value := range values {
+				literal := &shared.K3Expr{Kind: shared.K3ExprLiteral, Type: expr.Subquery.Values[0].Type, Value: value.str}
+				if value.null {
+					literal = &shared.K3Expr{Kind: shared.K3ExprNull}
+				}
+				expr.Args = append(expr.Args, literal)
+			}
+			expr.Subquery = nil
+		}
+		if err := materializeSubqueries(expr.Args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func subqueryValues(expr *shared.K3Expr, record map[string]string) ([]k3Value, error) {
+	if expr.Subquery == nil {
+		values := make([]k3Value, len(expr.Args))
+		for i, arg := range expr.Args {
+			value, err := evalExpr(arg, record)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	}
+	var outer map[string]string
+	if record != nil {
+		outer = make(map[string]string, len(record))
+		for k, v := range record {
+			outer[shared.K3OuterPrefix+k] = v
+		}
+	}
+	rows, err := selectRows(expr.Subquery, outer)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]k3Value, len(rows))
+	for i, row := range rows {
+		value, ok := row[expr.Subquery.Columns[0]]
+		values[i] = k3Value{str: value, null: !ok}
+	}
+	return values, nil
+}
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"k3SQLServer/shared"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timestampLayout keeps up to microseconds and drops trailing zeros, which
+// keeps stored timestamps in order when compared as strings.
+const timestampLayout = "2006-01-02 15:04:05.999999"
+
+// decimalDivisionScale is the number of decimal places kept when a DECIMAL
+// division does not come out exact.
+const decimalDivisionScale = 20
+
+var typeNames = map[int]string{
+	shared.K3INT:       "INT",
+	shared.K3FLOAT:     "FLOAT",
+	shared.K3TEXT:      "TEXT",
+	shared.K3BOOLEAN:   "BOOLEAN",
+	shared.K3DATE:      "DATE",
+	shared.K3TIMESTAMP: "TIMESTAMP",
+	shared.K3DECIMAL:   "DECIMAL",
+	shared.K3UUID:      "UUID",
+	shared.K3BLOB:      "BLOB",
+	shared.K3JSON:      "JSON",
+}
+
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	time.DateTime,
+	"2006-01-02 15:04",
+	time.DateOnly,
+}
+
+var decimalPattern = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d{1,3})?$`)
+
+// formatFloat returns the stored form of a FLOAT, which cannot be NaN or
+// infinite.
+func formatFloat(f float64) (string, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("%s: value out of range for type FLOAT", shared.InvalidSQLLogic)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64), nil
+}
+
+// CanonicalValue checks that value is valid for fieldType and returns the
+// form it is stored in, so that equal values are always equal strings.
+func CanonicalValue(fieldType int, value string) (string, error) {
+	switch fieldType {
+	case shared.K3INT:
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return strconv.FormatInt(n, 10), nil
+		}
+	case shared.K3FLOAT:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			if text, err := formatFloat(f); err == nil {
+				return text, nil
+			}
+		}
+	case shared.K3BOOLEAN:
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "true", "t", "yes", "y", "on", "1":
+			return "true", nil
+		case "false", "f", "no", "n", "off", "0":
+			return "false", nil
+		}
+	case shared.K3DATE:
+		if t, err := time.Parse(time.DateOnly, strings.TrimSpace(value)); err == nil {
+			return t.Format(time.DateOnly), nil
+		}
+	case shared.K3TIMESTAMP:
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+				return t.UTC().Format(timestampLayout), nil
+			}
+		}
+	case shared.K3DECIMAL:
+		if r, ok := parseDecimal(value); ok {
+			return formatDecimal(r), nil
+		}
+	case shared.K3UUID:
+		digits := strings.ReplaceAll(strings.Trim(strings.TrimSpace(value), "{}"), "-", "")
+		if _, err := hex.DecodeString(digits); err == nil && len(digits) == 32 {
+			digits = strings.ToLower(digits)
+			return digits[:8] + "-" + digits[8:12] + "-" + digits[12:16] + "-" + digits[16:20] + "-" + digits[20:], nil
+		}
+	case shared.K3BLOB:
+		// BLOBs are written as \x followed by hex digits; any other text
+		// stands for its own bytes.
+		digits, ok := strings.CutPrefix(value, `\x`)
+		if !ok {
+			return `\x` + hex.EncodeToString([]byte(value)), nil
+		}
+		if data, err := hex.DecodeString(digits); err == nil {
+			return `\x` + hex.EncodeToString(data), nil
+		}
+	case shared.K3JSON:
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, []byte(value)); err == nil {
+			return compact.String(), nil
+		}
+	default:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid input for type %s: %q", typeNames[fieldType], value)
+}
+
+func parseDecimal(value string) (*big.Rat, bool) {
+	value = strings.TrimSpace(value)
+	if !decimalPattern.MatchString(value) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(value)
+}
+
+// formatDecimal writes r, which must have a finite decimal expansion,
+// without an exponent or trailing zeros.
+func formatDecimal(r *big.Rat) string {
+	scale := 0
+	ten := big.NewRat(10, 1)
+	for x := new(big.Rat).Set(r); !x.IsInt(); x.Mul(x, ten) {
+		scale++
+	}
+	return r.FloatString(scale)
+}
+
+// roundDecimal rounds r half away from zero to digits decimal places, which
+// may be negative to round to tens, hundreds and so on.
+func roundDecimal(r *big.Rat, digits int) *big.Rat {
+	if digits >= 0 {
+		rounded, _ := new(big.Rat).SetString(r.FloatString(digits))
+		return rounded
+	}
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-digits)), nil))
+	rounded, _ := new(big.Rat).SetString(new(big.Rat).Quo(r, scale).FloatString(0))
+	return rounded.Mul(rounded, scale)
+}
+
+func decimalQuotient(a, b *big.Rat) *big.Rat {
+	return roundDecimal(new(big.Rat).Quo(a, b), decimalDivisionScale)
+}
+
+func decimalArithmetic(operator string, a, b k3Value) (k3Value, error) {
+	ratA, okA := parseDecimal(a.str)
+	ratB, okB := parseDecimal(b.str)
+	if !okA || !okB {
+		return k3Value{}, fmt.Errorf("%s: %q and %q are not numbers", shared.InvalidSQLLogic, a.str, b.str)
+	}
+	result := new(big.Rat)
+	switch operator {
+	case "+":
+		result.Add(ratA, ratB)
+	case "-":
+		result.Sub(ratA, ratB)
+	case "*":
+		result.Mul(ratA, ratB)
+	case "/", "%":
+		if ratB.Sign() == 0 {
+			return k3Value{}, fmt.Errorf("%s: division by zero", shared.InvalidSQLLogic)
+		}
+		if operator == "/" {
+			result = decimalQuotient(ratA, ratB)
+		} else {
+			quotient := new(big.Int).Quo(new(big.Int).Mul(ratA.Num(), ratB.Denom()), new(big.Int).Mul(ratA.Denom(), ratB.Num()))
+			result.Sub(ratA, result.Mul(ratB, new(big.Rat).SetInt(quotient)))
+		}
+	}
+	return k3Value{str: formatDecimal(result)}, nil
+}
+
+func decimalFunction(expr *shared.K3Expr, args []k3Value) (k3Value, error) {
+	r, ok := parseDecimal(args[0].str)
+	if !ok {
+		return k3Value{}, fmt.Errorf("%s: %q is not a number", shared.InvalidSQLLogic, args[0].str)
+	}
+	switch expr.Operator {
+	case "ABS":
+		r.Abs(r)
+	case "FLOOR", "CEIL":
+		quotient, remainder := new(big.Int).DivMod(r.Num(), r.Denom(), new(big.Int))
+		if expr.Operator == "CEIL" && remainder.Sign() != 0 {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+		r.SetInt(quotient)
+	case "ROUND":
+		digits := 0
+		if len(args) > 1 {
+			var err error
+			if digits, err = strconv.Atoi(args[1].str); err != nil {
+				return k3Value{}, err
+			}
+		}
+		r = roundDecimal(r, max(-shared.MaxDecimalPrecision, min(digits, shared.MaxDecimalPrecision)))
+	}
+	return k3Value{str: formatDecimal(r)}, nil
+}
+
+func timestampValue(value string) string {
+	if len(value) == len(time.DateOnly) {
+		return value + " 00:00:00"
+	}
+	return value
+}
+
+func compareDecimals(a, b string) (int, bool) {
+	ratA, okA := parseDecimal(a)
+	ratB, okB := parseDecimal(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	return ratA.Cmp(ratB), true
+}
+
+// ComparisonType is the type values of types a and b are compared as, where
+// 0 stands for NULL; ok is false when they cannot be compared.
+func ComparisonType(a, b int) (valueType int, ok bool) {
+	numeric := func(t int) bool { return t == shared.K3INT || t == shared.K3FLOAT || t == shared.K3DECIMAL }
+	chronological := func(t int) bool { return t == shared.K3DATE || t == shared.K3TIMESTAMP }
+	switch {
+	case a == 0 || a == b:
+		return b, true
+	case b == 0:
+		return a, true
+	case numeric(a) && numeric(b):
+		return max(a, b), true
+	case chronological(a) && chronological(b):
+		return shared.K3TIMESTAMP, true
+	}
+	return 0, false
+}
+
+// comparisonType is the type the operands of the comparison where, and the
+// values of its subquery, are compared as.
+func comparisonType(where *shared.K3Expr) int {
+	valueType := 0
+	for _, arg := range where.Args {
+		valueType, _ = ComparisonType(valueType, arg.Type)
+	}
+	if where.Subquery != nil {
+		valueType, _ = ComparisonType(valueType, where.Subquery.Values[0].Type)
+	}
+	return valueType
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"k3SQLServer/shared"
+	"testing"
+)
+
+func TestCanonicalValue(t *testing.T) {
+	tests := []struct {
+		fieldType int
+		value     string
+		want      string
+	}{
+		{shared.K3INT, "42", "42"},
+		{shared.K3INT, "007", "7"},
+		{shared.K3INT, "+5", "5"},
+		{shared.K3INT, "-0", "0"},
+		{shared.K3FLOAT, "1.50", "1.5"},
+		{shared.K3FLOAT, "1.0", "1"},
+		{shared.K3FLOAT, "2e3", "2000"},
+		{shared.K3FLOAT, "-0.25", "-0.25"},
+		{shared.K3FLOAT, "1e21", "1e+21"},
+		{shared.K3DECIMAL, "1.50", "1.5"},
+		{shared.K3DECIMAL, "-0012.000", "-12"},
+		{shared.K3BOOLEAN, "Yes", "true"},
+		{shared.K3DATE, "2024-02-29", "2024-02-29"},
+		{shared.K3TIMESTAMP, "2024-01-02T03:04:05+01:00", "2024-01-02 02:04:05"},
+		{shared.K3UUID, "{A0EEBC99-9C0B-4EF8-BB6D-6BB9BD380A11}", "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"},
+		{shared.K3JSON, `{ "a" : [1, 2] }`, `{"a":[1,2]}`},
+		{shared.K3TEXT, " as is ", " as is "},
+	}
+	for _, tt := range tests {
+		got, err := CanonicalValue(tt.fieldType, tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("CanonicalValue(%s, %q) = %q, %v, want %q", typeNames[tt.fieldType], tt.value, got, err, tt.want)
+		}
+	}
+	for _, tt := range []struct {
+		fieldType int
+		value     string
+	}{
+		{shared.K3INT, "1.5"},
+		{shared.K3INT, "99999999999999999999"},
+		{shared.K3FLOAT, "abc"},
+		{shared.K3FLOAT, "NaN"},
+		{shared.K3FLOAT, "-Inf"},
+		{shared.K3FLOAT, "1e400"},
+		{shared.K3DECIMAL, "1e"},
+		{shared.K3DATE, "2023-02-29"},
+		{shared.K3BOOLEAN, "maybe"},
+	} {
+		if got, err := CanonicalValue(tt.fieldType, tt.value); err == nil {
+			t.Errorf("CanonicalValue(%s, %q) = %q, want an error", typeNames[tt.fieldType], tt.value, got)
+		}
+	}
+}
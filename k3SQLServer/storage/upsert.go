@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"k3SQLServer/shared"
+	"os"
+	"slices"
+	"strings"
+)
+
+func upsertRows(query *shared.K3InsertQuery, header string, types map[string]int, inserted []map[string]string) error {
+	table := query.Table
+	if err := loadIndexes(table); err != nil {
+		return err
+	}
+	targets := conflictTargets(table, query.Conflict.Columns)
+	// Rows already in the file are found through the unique indexes, and the
+	// file is only read once DO UPDATE has to change one of them. Until then
+	// records holds just the new rows.
+	var records []map[string]string
+	existing := 0
+	loaded := false
+	index := make([]map[string]int, len(targets))
+	for t := range targets {
+		index[t] = make(map[string]int)
+	}
+	affected := make(map[int]bool)
+	var changed []map[string]string
+	rewrite := false
+	for _, record := range inserted {
+		i, conflict := conflictingRow(index, table, targets, record)
+		if !conflict && !loaded && indexedConflict(table, targets, record) {
+			if query.Conflict.Update == nil {
+				continue
+			}
+			stored, err := readRecords(table)
+			if err != nil {
+				return err
+			}
+			existing, loaded = len(stored), true
+			records = append(stored, records...)
+			shifted := make(map[int]bool, len(affected))
+			for i := range affected {
+				shifted[existing+i] = true
+			}
+			affected = shifted
+			for t := range index {
+				clear(index[t])
+			}
+			for i, record := range records {
+				setConflictKeys(index, table, targets, record, i)
+			}
+			i, conflict = conflictingRow(index, table, targets, record)
+		}
+		if !conflict {
+			records = append(records, record)
+			setConflictKeys(index, table, targets, record, len(records)-1)
+			affected[len(records)-1] = true
+			changed = append(changed, record)
+			continue
+		}
+		if query.Conflict.Update == nil {
+			continue
+		}
+		if affected[i] {
+			return fmt.Errorf("%s: ON CONFLICT DO UPDATE cannot affect a row a second time", shared.InvalidSQLLogic)
+		}
+		merged := make(map[string]string, 2*len(table.Fields))
+		for k, v := range records[i] {
+			merged[table.Name+"."+k] = v
+		}
+		for k, v := range record {
+			merged["excluded."+k] = v
+		}
+		ok, err := satisfiesConditions(merged, query.Conflict.Where)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		values := make(map[string]k3Value, len(query.Conflict.Update))
+		for col, expr := range query.Conflict.Update {
+			val, err := evalExpr(expr, merged)
+			if err != nil {
+				return err
+			}
+			if !val.null {
+				if val.str, err = columnValue(col, types[col], val.str); err != nil {
+					return err
+				}
+			}
+			values[col] = val
+		}
+		for t, target := range targets {
+			if key, ok := conflictKey(records[i], target.Columns, table.Types); ok && index[t][key] == i {
+				delete(index[t], key)
+			}
+		}
+		assignValues(records[i], values)
+		if err := CheckRecord(table, records[i]); err != nil {
+			return err
+		}
+		setConflictKeys(index, table, targets, records[i], i)
+		affected[i] = true
+		changed = append(changed, records[i])
+		rewrite = true
+	}
+	returned, err := returningRows(query.Returning, changed)
+	if err != nil {
+		return err
+	}
+	query.Returned = returned
+	if !rewrite {
+		added := newIndexes(table)
+		lines := make([]string, 0, len(records)-existing)
+		for _, record := range records[existing:] {
+			if err := addKeys(table, added, table.Indexes, record); err != nil {
+				return err
+			}
+			lines = append(lines, formatRecord(record, table.Fields))
+		}
+		if err := appendLines(table, lines); err != nil {
+			return err
+		}
+		mergeIndexes(table, added)
+		return nil
+	}
+	indexes := newIndexes(table)
+	for _, record := range records {
+		if err := addKeys(table, indexes, nil, record); err != nil {
+			return err
+		}
+	}
+	filePath := shared.K3DataPath + table.Database + "/" + table.Name + shared.Extension
+	tempFilePath := filePath + ".tmp"
+	tempFile, err := os.Create(tempFilePath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFilePath)
+	defer tempFile.Close()
+	writer := bufio.NewWriter(tempFile)
+	if _, err := writer.WriteString(header + "\n"); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if _, err := writer.WriteString(formatRecord(record, table.Fields) + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := os.Rename(tempFilePath, filePath); err != nil {
+		return err
+	}
+	table.Indexes = indexes
+	return nil
+}
+
+// conflictTargets returns the unique constraints a new row is checked
+// against: the one on columns, or every one when no columns are given.
+func conflictTargets(table *shared.K3Table, columns []string) []*shared.K3Constraint {
+	var targets []*shared.K3Constraint
+	for _, constraint := range table.Constraints {
+		if !isUnique(constraint) {
+			continue
+		}
+		if columns == nil || len(columns) == len(constraint.Columns) &&
+			!slices.ContainsFunc(columns, func(column string) bool { return !slices.Contains(constraint.Columns, column) }) {
+			targets = append(targets, constraint)
+		}
+	}
+	return targets
+}
+
+func readRecords(table *shared.K3Table) ([]map[string]string, error) {
+	file, err := os.Open(shared.K3DataPath + table.Database + "/" + table.Name + shared.Extension)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var records []map[string]string
+	scanner := bufio.NewScanner(file)
+	scanner.Scan()
+	for scanner.Scan() {
+		records = append(records, parseRecord(scanner.Text(), table.Fields, table.Types))
+	}
+	return records, scanner.Err()
+}
+
+// conflictKey returns the key of record on columns; NULLs never conflict.
+func conflictKey(record map[string]string, columns []string, types map[string]int) (string, bool) {
+	for _, column := range columns {
+		if IsNull(record, column) {
+			return "", false
+		}
+	}
+	return indexKey(record, columns, types), true
+}
+
+// indexedConflict reports whether record conflicts with a row in the file.
+func indexedConflict(table *shared.K3Table, targets []*shared.K3Constraint, record map[string]string) bool {
+	for _, target := range targets {
+		if key, ok := conflictKey(record, target.Columns, table.Types); ok && table.Indexes[target.Name][key] {
+			return true
+		}
+	}
+	return false
+}
+
+func conflictingRow(index []map[string]int, table *shared.K3Table, targets []*shared.K3Constraint, record map[string]string) (int, bool) {
+	for t, target := range targets {
+		if key, ok := conflictKey(record, target.Columns, table.Types); ok {
+			if i, ok := index[t][key]; ok {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func setConflictKeys(index []map[string]int, table *shared.K3Table, targets []*shared.K3Constraint, record map[string]string, i int) {
+	for t, target := range targets {
+		if key, ok := conflictKey(record, target.Columns, table.Types); ok {
+			index[t][key] = i
+		}
+	}
+}
+
+func formatRecord(record map[string]string, fields []string) string {
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		value, ok := record[field]
+		if !ok {
+			value = shared.NullMarker
+		} else {
+			value = escapeValue(value)
+		}
+		values[i] = value
+	}
+	return strings.Join(values, "|")
+}
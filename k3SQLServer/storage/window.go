@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"fmt"
+	"k3SQLServer/shared"
+	"strconv"
+)
+
+func computeWindows(windows []*shared.K3Expr, records []map[string]string) error {
+	for _, window := range windows {
+		partitions := make(map[string]*rowSorter)
+		var order []*rowSorter
+		for _, record := range records {
+			values := make([]k3Value, len(window.Window.PartitionBy))
+			for i, expr := range window.Window.PartitionBy {
+				value, err := evalExpr(expr, record)
+				if err != nil {
+					return err
+				}
+				values[i] = keyValue(expr.Type, value)
+			}
+			key := valuesKey(values)
+			sorter, ok := partitions[key]
+			if !ok {
+				sorter = newRowSorter(window.Window.OrderBy, -1)
+				partitions[key] = sorter
+				order = append(order, sorter)
+			}
+			if err := sorter.add(record); err != nil {
+				return err
+			}
+		}
+		for _, sorter := range order {
+			sorter.sorted()
+			if err := evalWindow(window, sorter.rows); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func evalWindow(window *shared.K3Expr, rows []*sortedRow) error {
+	orderBy := window.Window.OrderBy
+	peer := func(i int) bool {
+		if i == 0 {
+			return false
+		}
+		for k, order := range orderBy {
+			if compareTyped(rows[i-1].keys[k], rows[i].keys[k], order.Expr.Type) != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	switch window.Operator {
+	case "ROW_NUMBER", "RANK", "DENSE_RANK":
+		rank, dense := 0, 0
+		for i, row := range rows {
+			if !peer(i) {
+				rank = i + 1
+				dense++
+			}
+			value := i + 1
+			switch window.Operator {
+			case "RANK":
+				value = rank
+			case "DENSE_RANK":
+				value = dense
+			}
+			row.record[window.Column] = strconv.Itoa(value)
+		}
+	case "LAG", "LEAD":
+		for i, row := range rows {
+			value, err := shiftedValue(window, rows, i)
+			if err != nil {
+				return err
+			}
+			setWindowValue(row.record, window.Column, value)
+		}
+	default:
+		aggregate := *window
+		aggregate.Kind = shared.K3ExprAggregate
+		groups := newGrouper(&shared.K3SelectQuery{Aggregates: []*shared.K3Expr{&aggregate}})
+		for start := 0; start < len(rows); {
+			end := start + 1
+			if len(orderBy) == 0 {
+				end = len(rows)
+			}
+			for end < len(rows) && peer(end) {
+				end++
+			}
+			for _, row := range rows[start:end] {
+				if err := groups.add(row.record); err != nil {
+					return err
+				}
+			}
+			results, err := groups.results()
+			if err != nil {
+				return err
+			}
+			value, ok := results[0][window.Column]
+			for _, row := range rows[start:end] {
+				setWindowValue(row.record, window.Column, k3Value{str: value, null: !ok})
+			}
+			start = end
+		}
+	}
+	return nil
+}
+
+func shiftedValue(window *shared.K3Expr, rows []*sortedRow, i int) (k3Value, error) {
+	record := rows[i].record
+	offset := 1
+	if len(window.Args) > 1 {
+		value, err := evalExpr(window.Args[1], record)
+		if err != nil || value.null {
+			return value, err
+		}
+		if offset, err = strconv.Atoi(value.str); err != nil {
+			return k3Value{}, fmt.Errorf("%s: %q is not a valid offset", shared.InvalidSQLLogic, value.str)
+		}
+	}
+	if window.Operator == "LAG" {
+		offset = -offset
+	}
+	if j := i + offset; j >= 0 && j < len(rows) {
+		return evalExpr(window.Args[0], rows[j].record)
+	}
+	if len(window.Args) > 2 {
+		return evalExpr(window.Args[2], record)
+	}
+	return k3Value{null: true}, nil
+}
+
+func setWindowValue(record map[string]string, column string, value k3Value) {
+	if value.null {
+		delete(record, column)
+	} else {
+		record[column] = value.str
+	}
+}